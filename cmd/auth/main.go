@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/cvele/authentication-service/internal/authentication"
 	"github.com/cvele/authentication-service/internal/config"
 	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/mail"
 	"github.com/cvele/authentication-service/internal/openapi"
 	"github.com/cvele/authentication-service/internal/router"
+	"github.com/cvele/authentication-service/internal/token"
 	"github.com/rs/zerolog/log"
 )
 
@@ -20,26 +24,143 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to load configuration")
 	}
 
-	db, err := db.New(cfg)
+	db, err := db.Open(cfg)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to connect to database")
 	}
 	defer db.Close()
 
+	// Periodically drop expired denylist entries, challenges, reset tokens,
+	// failed logins and rate limit buckets
+	go func() {
+		ticker := time.NewTicker(cfg.CleanupInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			deleted, err := db.DeleteExpired(context.Background())
+			if err != nil {
+				log.Error().Err(err).Msg("failed to clean up expired rows")
+				continue
+			}
+			log.Debug().Int64("deleted", deleted).Msg("cleaned up expired rows")
+		}
+	}()
+
+	// Load the token signing keys and keep them in sync with the other replicas
+	keys, err := token.LoadKeyring(context.Background(), cfg, db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load signing keys")
+	}
+	go keys.Maintain()
+	if keys.Active().Symmetric() {
+		log.Warn().Msg("tokens are signed with HS256, OpenID Connect is unavailable until an asymmetric key is configured")
+	}
+
+	// Mail is only delivered when an SMTP relay is configured
+	var mailer mail.Sender = &mail.SMTPSender{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	}
+	if cfg.SMTPHost == "" {
+		if cfg.EmailVerificationRequired {
+			log.Fatal().Msg("EMAIL_VERIFICATION_REQUIRED needs SMTP_HOST, no one could verify their address otherwise")
+		}
+		log.Warn().Msg("SMTP_HOST is not set, emails are dropped and not delivered")
+		mailer = mail.LogSender{}
+	}
+
 	// Create API
-	api, err := authentication.NewAPI(cfg, *db)
+	api, err := authentication.NewAPI(cfg, db, keys, mailer)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to create API")
 	}
 
+	// Client addresses are taken from X-Forwarded-For only behind trusted proxies
+	trustedProxies, err := router.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse trusted proxies")
+	}
+
+	// Rate limits are shared between replicas when kept in the database. The
+	// default limit applies to every request and is always counted in
+	// memory, so that it does not cost a transaction per request.
+	var limits router.Store
+	defaultLimits := router.NewMemoryStore()
+	switch cfg.RateLimitBackend {
+	case "memory":
+		limits = router.NewMemoryStore()
+	case "database":
+		limits = router.NewDBStore(db)
+	default:
+		log.Fatal().Str("backend", cfg.RateLimitBackend).Msg("unknown rate limit backend")
+	}
+
+	defaultLimit, err := router.ParseLimit(cfg.RateLimitDefault)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse RATE_LIMIT_DEFAULT")
+	}
+	loginLimit, err := router.ParseLimit(cfg.RateLimitLogin)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse RATE_LIMIT_LOGIN")
+	}
+	registerLimit, err := router.ParseLimit(cfg.RateLimitRegister)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to parse RATE_LIMIT_REGISTER")
+	}
+	loginRateLimit := router.RateLimit(limits, "login", loginLimit, router.ByIP, router.ByUsername)
+	registerRateLimit := router.RateLimit(limits, "register", registerLimit, router.ByIP)
+
 	// Create router and endpoints
 	r := router.New()
+	r.Use(router.RealIP(trustedProxies), router.RateLimit(defaultLimits, "default", defaultLimit, router.ByIP))
 
-	r.HandleFunc("/login", api.LoginHandler).Methods("POST")
+	r.HandleFunc("/login", api.LoginHandler, loginRateLimit).Methods("POST")
+	r.HandleFunc("/login/mfa", api.LoginMFAHandler, loginRateLimit).Methods("POST")
 	r.HandleFunc("/refresh", api.RefreshHandler).Methods("POST")
 	r.HandleFunc("/validate", api.ValidateHandler).Methods("POST")
-	r.HandleFunc("/register", api.RegisterHandler).Methods("POST")
-	r.HandleFunc("/change-password", api.ChangePasswordHandler).Methods("PUT")
+	r.HandleFunc("/logout", api.LogoutHandler).Methods("POST")
+	r.HandleFunc("/logout-all", api.LogoutAllHandler).Methods("POST")
+	r.HandleFunc("/register", api.RegisterHandler, registerRateLimit).Methods("POST")
+	r.HandleFunc("/verify-email", api.VerifyEmailHandler).Methods("GET")
+	r.HandleFunc("/verify-email/resend", api.ResendVerificationEmailHandler, registerRateLimit).Methods("POST")
+	r.HandleFunc("/change-password", api.ChangePasswordHandler, loginRateLimit).Methods("PUT")
+	r.HandleFunc("/password-reset/request", api.RequestPasswordResetHandler, registerRateLimit).Methods("POST")
+	r.HandleFunc("/password-reset/confirm", api.ConfirmPasswordResetHandler).Methods("POST")
+	r.HandleFunc("/mfa/totp", api.EnrollTOTPHandler).Methods("POST")
+	r.HandleFunc("/mfa/totp/confirm", api.ConfirmTOTPHandler).Methods("POST")
+	r.HandleFunc("/mfa/totp/disable", api.DisableTOTPHandler, loginRateLimit).Methods("POST")
+	r.HandleFunc("/webauthn/register/begin", api.BeginWebAuthnRegistrationHandler, loginRateLimit).Methods("POST")
+	r.HandleFunc("/webauthn/register/finish", api.FinishWebAuthnRegistrationHandler).Methods("POST")
+	r.HandleFunc("/webauthn/login/begin", api.BeginWebAuthnLoginHandler).Methods("POST")
+	r.HandleFunc("/webauthn/login/finish", api.FinishWebAuthnLoginHandler, loginRateLimit).Methods("POST")
+	r.HandleFunc("/webauthn/credentials", api.ListWebAuthnCredentialsHandler).Methods("GET")
+	r.HandleFunc("/webauthn/credentials/{id}", api.DeleteWebAuthnCredentialHandler).Methods("DELETE")
+	r.HandleFunc("/admin/roles", api.RequirePermission(token.AdminPermission, api.ListRolesHandler)).Methods("GET")
+	r.HandleFunc("/admin/roles/{role}", api.RequirePermission(token.AdminPermission, api.SaveRoleHandler)).Methods("PUT")
+	r.HandleFunc("/admin/users", api.RequirePermission(token.AdminPermission, api.ListUsersHandler)).Methods("GET")
+	r.HandleFunc("/admin/users", api.RequirePermission(token.AdminPermission, api.CreateUserHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/by-username/{username}", api.RequirePermission(token.AdminPermission, api.GetUserByUsernameHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}", api.RequirePermission(token.AdminPermission, api.GetUserHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}", api.RequirePermission(token.AdminPermission, api.DeleteUserHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id}/disabled", api.RequirePermission(token.AdminPermission, api.DisableUserHandler)).Methods("PUT")
+	r.HandleFunc("/admin/users/{id}/disabled", api.RequirePermission(token.AdminPermission, api.EnableUserHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id}/password-reset", api.RequirePermission(token.AdminPermission, api.ForcePasswordResetHandler)).Methods("POST")
+	r.HandleFunc("/admin/users/{id}/roles", api.RequirePermission(token.AdminPermission, api.UserRolesHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}/roles/{role}", api.RequirePermission(token.AdminPermission, api.AssignRoleHandler)).Methods("PUT")
+	r.HandleFunc("/admin/users/{id}/roles/{role}", api.RequirePermission(token.AdminPermission, api.UnassignRoleHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/users/{id}/lockout", api.RequirePermission(token.AdminPermission, api.LockoutHandler)).Methods("GET")
+	r.HandleFunc("/admin/users/{id}/lockout", api.RequirePermission(token.AdminPermission, api.UnlockUserHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/clients", api.RequirePermission(token.AdminPermission, api.ListClientsHandler)).Methods("GET")
+	r.HandleFunc("/admin/clients", api.RequirePermission(token.AdminPermission, api.CreateClientHandler)).Methods("POST")
+	r.HandleFunc("/admin/clients/{id}", api.RequirePermission(token.AdminPermission, api.DeleteClientHandler)).Methods("DELETE")
+	r.HandleFunc("/admin/audit-events", api.RequirePermission(token.AdminPermission, api.ListAuditEventsHandler)).Methods("GET")
+	r.HandleFunc("/authorize", api.AuthorizeHandler, loginRateLimit).Methods("GET", "POST")
+	r.HandleFunc("/token", api.TokenHandler, loginRateLimit).Methods("POST")
+	r.HandleFunc("/userinfo", api.UserInfoHandler).Methods("GET", "POST")
+	r.HandleFunc("/.well-known/openid-configuration", api.OpenIDConfigurationHandler).Methods("GET")
+	r.HandleFunc("/.well-known/jwks.json", api.JWKSHandler).Methods("GET")
 	r.HandleFunc("/openapi", openapi.OpenAPIHandler).Methods("GET")
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+const usage = `Usage: authctl [-json] <command> [arguments]
+
+Works on the database of the service directly, configured by the same
+environment variables. Passwords are read from the first line of standard
+input. With -json the output is JSON instead of tables.
+
+Commands:
+  user create [-email address] [-verified] [-admin] [-temporary] <username>
+        create a user, an admin with -admin; -temporary requires the user
+        to choose a new password at the first login
+  user list [-q text] [-disabled true|false] [-after username] [-limit n]
+        list users ordered by username
+  user show <username>
+        show a user with their roles and permissions
+  user set-password [-temporary] <username>
+        set the password of a user and log them out everywhere
+  user disable <username>
+        keep a user from logging in and log them out everywhere
+  user enable <username>
+        let a disabled user log in again
+  user assign-role <username> <role>
+  user unassign-role <username> <role>
+        grant or take away a role by name
+  session list <username>
+        list the logins of a user that can still be refreshed
+  session revoke <username>
+        log a user out everywhere
+  token mint [-audience audience] [-ttl duration] <username>
+        issue an access token for a user, for debugging
+  token decode <token>
+        print the header and claims of a token without verifying it
+  token verify [-audience audience] <token>
+        verify a token as the service would; exits with 1 if it is invalid
+  key rotate
+        generate a new signing key and retire the active one
+  key list
+        list the signing keys that can still verify tokens
+  audit verify [-anchor seq:hash]
+        check that the audit log has not been tampered with, and that it
+        still holds an event recorded earlier; exits with 1 if it does not
+`
+
+// app carries what every command needs.
+type app struct {
+	ctx   context.Context
+	cfg   *config.Config
+	store db.Store
+	json  bool
+}
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	jsonOutput := flag.Bool("json", false, "write JSON instead of tables")
+	flag.Parse()
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load configuration")
+	}
+	if cfg.DBBackend == "memory" {
+		log.Fatal().Msg("the memory backend is only reachable from within the service")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to database")
+	}
+	defer store.Close()
+
+	a := &app{ctx: context.Background(), cfg: cfg, store: store, json: *jsonOutput}
+	commands := map[string]func([]string){
+		"user create":        a.createUser,
+		"user list":          a.listUsers,
+		"user show":          a.showUser,
+		"user set-password":  a.setPassword,
+		"user disable":       a.disableUser,
+		"user enable":        a.enableUser,
+		"user assign-role":   a.assignRole,
+		"user unassign-role": a.unassignRole,
+		"session list":       a.listSessions,
+		"session revoke":     a.revokeSessions,
+		"token mint":         a.mintToken,
+		"token decode":       a.decodeToken,
+		"token verify":       a.verifyToken,
+		"key rotate":         a.rotateKey,
+		"key list":           a.listKeys,
+		"audit verify":       a.verifyAudit,
+	}
+	command, ok := commands[args[0]+" "+args[1]]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command(args[2:])
+}
+
+// parse parses the flags of a command and returns its arguments, of which
+// there must be n.
+func parse(flags *flag.FlagSet, args []string, n int) []string {
+	flags.Usage = flag.Usage
+	flags.Parse(args)
+	if flags.NArg() != n {
+		flag.Usage()
+		os.Exit(2)
+	}
+	return flags.Args()
+}
+
+// noFlags returns the arguments of a command without flags, of which there
+// must be n.
+func noFlags(args []string, n int) []string {
+	return parse(flag.NewFlagSet("", flag.ExitOnError), args, n)
+}
+
+// write writes v as JSON in JSON mode, and otherwise the table written by
+// table.
+func (a *app) write(v interface{}, table func(w io.Writer)) {
+	if a.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			log.Fatal().Err(err).Msg("failed to write output")
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	table(w)
+	w.Flush()
+}
+
+// user returns the user named username.
+func (a *app) user(username string) *db.User {
+	user, err := a.store.GetUserByUsername(a.ctx, username)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Fatal().Str("username", username).Msg("no such user")
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get user")
+	}
+	return user
+}
+
+// readPassword reads a password from the first line of standard input,
+// prompting for it when that is a terminal.
+func readPassword() string {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		log.Fatal().Err(err).Msg("failed to read password")
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		log.Fatal().Msg("no password on standard input")
+	}
+	return password
+}
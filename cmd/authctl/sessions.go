@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+type sessionOutput struct {
+	FamilyID    string    `json:"family_id"`
+	ClientID    string    `json:"client_id,omitempty"`
+	Audience    string    `json:"audience,omitempty"`
+	Scope       string    `json:"scope,omitempty"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func (a *app) listSessions(args []string) {
+	user := a.user(noFlags(args, 1)[0])
+	sessions, err := a.store.ListSessions(a.ctx, user.ID)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to list sessions")
+	}
+
+	output := make([]sessionOutput, 0, len(sessions))
+	for _, rt := range sessions {
+		output = append(output, sessionOutput{
+			FamilyID:    rt.FamilyID,
+			ClientID:    rt.ClientID,
+			Audience:    rt.Audience,
+			Scope:       rt.Scope,
+			RefreshedAt: rt.CreatedAt,
+			ExpiresAt:   rt.ExpiresAt,
+		})
+	}
+
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintln(w, "FAMILY\tCLIENT\tAUDIENCE\tREFRESHED\tEXPIRES")
+		for _, s := range output {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.FamilyID, s.ClientID, s.Audience,
+				s.RefreshedAt.Format(time.RFC3339), s.ExpiresAt.Format(time.RFC3339))
+		}
+	})
+}
+
+func (a *app) revokeSessions(args []string) {
+	user := a.user(noFlags(args, 1)[0])
+	sessions, err := a.store.ListSessions(a.ctx, user.ID)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to list sessions")
+	}
+	a.revokeAll(user)
+	a.audit(db.AuditEvent{Type: audit.SessionsRevoke, TargetID: user.ID, Username: user.Username})
+
+	output := struct {
+		UserID  string `json:"user_id"`
+		Revoked int    `json:"revoked"`
+	}{user.ID, len(sessions)}
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintf(w, "revoked %d sessions of %s, and every access token issued to them so far\n", output.Revoked, user.Username)
+	})
+}
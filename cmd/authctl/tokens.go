@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+	"github.com/rs/zerolog/log"
+)
+
+type mintOutput struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (a *app) mintToken(args []string) {
+	flags := flag.NewFlagSet("token mint", flag.ExitOnError)
+	audience := flags.String("audience", "", "audience of the token, the first of JWT_AUDIENCES by default")
+	ttl := flags.Duration("ttl", a.cfg.TokenTTL, "lifetime of the token")
+	user := a.user(parse(flags, args, 1)[0])
+
+	if !token.AllowedAudience(*audience, a.cfg) {
+		log.Fatal().Str("audience", *audience).Msg("audience is not one of JWT_AUDIENCES")
+	}
+	if user.DisabledAt != nil {
+		log.Warn().Str("username", user.Username).Msg("user is disabled")
+	}
+	roles, permissions, err := a.store.GetUserRoles(a.ctx, user.ID)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get user roles")
+	}
+	keys, err := token.LoadKeyring(a.ctx, a.cfg, a.store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load signing keys")
+	}
+
+	cfg := *a.cfg
+	cfg.TokenTTL = *ttl
+	claims := &token.Claims{UserID: user.ID, Roles: roles, Permissions: permissions}
+	tokenString, err := token.New(claims, *audience, keys, &cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to mint token")
+	}
+	a.audit(db.AuditEvent{Type: audit.TokenMint, TargetID: user.ID, Username: user.Username, Detail: *audience})
+
+	output := mintOutput{AccessToken: tokenString, TokenType: "Bearer", ExpiresIn: int64(ttl.Seconds())}
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintln(w, output.AccessToken)
+	})
+}
+
+type decodeOutput struct {
+	Header json.RawMessage `json:"header"`
+	Claims json.RawMessage `json:"claims"`
+}
+
+func (a *app) decodeToken(args []string) {
+	parts := strings.Split(noFlags(args, 1)[0], ".")
+	if len(parts) != 3 {
+		log.Fatal().Msg("token is not a JWT")
+	}
+
+	var output decodeOutput
+	for i, part := range []*json.RawMessage{&output.Header, &output.Claims} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil || !json.Valid(decoded) {
+			log.Fatal().Msg("token is not a JWT")
+		}
+		*part = decoded
+	}
+
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintf(w, "Header:\n%s\nClaims:\n%s\n", indent(output.Header), indent(output.Claims))
+	})
+}
+
+type verifyOutput struct {
+	Valid  bool          `json:"valid"`
+	Error  string        `json:"error,omitempty"`
+	Claims *token.Claims `json:"claims,omitempty"`
+}
+
+func (a *app) verifyToken(args []string) {
+	flags := flag.NewFlagSet("token verify", flag.ExitOnError)
+	audience := flags.String("audience", "", "audience the token must be for, any of JWT_AUDIENCES by default")
+	tokenString := parse(flags, args, 1)[0]
+
+	keys, err := token.LoadKeyring(a.ctx, a.cfg, a.store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load signing keys")
+	}
+
+	var output verifyOutput
+	output.Claims, err = token.Validate(a.ctx, tokenString, *audience, keys, a.store, a.cfg)
+	if err != nil {
+		output.Error = err.Error()
+	}
+	output.Valid = err == nil
+
+	a.write(output, func(w io.Writer) {
+		if !output.Valid {
+			fmt.Fprintf(w, "invalid: %s\n", output.Error)
+			return
+		}
+		claims, _ := json.Marshal(output.Claims)
+		fmt.Fprintf(w, "valid\n%s\n", indent(claims))
+	})
+	if !output.Valid {
+		os.Exit(1)
+	}
+}
+
+// indent indents the JSON document data, or returns it as it is if it is not
+// one.
+func indent(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return data
+	}
+	return buf.Bytes()
+}
+
+type keyOutput struct {
+	ID        string     `json:"kid"`
+	Algorithm string     `json:"algorithm"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (a *app) rotateKey(args []string) {
+	noFlags(args, 0)
+	keys, err := token.LoadKeyring(a.ctx, a.cfg, a.store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load signing keys")
+	}
+
+	key, err := keys.Rotate(a.ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to rotate signing key")
+	}
+	a.listKeys(nil)
+	log.Info().Str("kid", key.ID).Str("algorithm", key.Algorithm).Msg("signing key rotated")
+}
+
+func (a *app) listKeys(args []string) {
+	noFlags(args, 0)
+	stored, err := a.store.ListSigningKeys(a.ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to list signing keys")
+	}
+
+	output := make([]keyOutput, 0, len(stored))
+	for _, key := range stored {
+		output = append(output, keyOutput{
+			ID:        key.ID,
+			Algorithm: key.Algorithm,
+			CreatedAt: key.CreatedAt,
+			RetiredAt: key.RetiredAt,
+			ExpiresAt: key.ExpiresAt,
+		})
+	}
+
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintln(w, "KID\tALGORITHM\tCREATED\tSTATUS")
+		for _, key := range output {
+			status := "active"
+			if key.RetiredAt != nil {
+				status = "verify-only until " + key.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.CreatedAt.Format(time.RFC3339), status)
+		}
+	})
+}
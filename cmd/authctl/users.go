@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	netmail "net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/crypt"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/policy"
+	"github.com/rs/zerolog/log"
+)
+
+type userOutput struct {
+	ID                     string     `json:"id"`
+	Username               string     `json:"username"`
+	Email                  string     `json:"email,omitempty"`
+	EmailVerified          bool       `json:"email_verified"`
+	PasswordChangedAt      *time.Time `json:"password_changed_at,omitempty"`
+	PasswordBreached       bool       `json:"password_breached"`
+	PasswordChangeRequired bool       `json:"password_change_required"`
+	Disabled               bool       `json:"disabled"`
+	DisabledAt             *time.Time `json:"disabled_at,omitempty"`
+	Roles                  []string   `json:"roles,omitempty"`
+	Permissions            []string   `json:"permissions,omitempty"`
+}
+
+func newUserOutput(user *db.User) userOutput {
+	return userOutput{
+		ID:                     user.ID,
+		Username:               user.Username,
+		Email:                  user.Email,
+		EmailVerified:          user.EmailVerifiedAt != nil,
+		PasswordChangedAt:      user.PasswordChangedAt,
+		PasswordBreached:       user.PasswordBreachedAt != nil,
+		PasswordChangeRequired: user.PasswordChangeRequiredAt != nil,
+		Disabled:               user.DisabledAt != nil,
+		DisabledAt:             user.DisabledAt,
+	}
+}
+
+// status describes the state of the account in a word.
+func (u userOutput) status() string {
+	switch {
+	case u.Disabled:
+		return "disabled"
+	case u.PasswordChangeRequired:
+		return "password-change-required"
+	case u.PasswordBreached:
+		return "password-breached"
+	}
+	return "active"
+}
+
+func (a *app) createUser(args []string) {
+	flags := flag.NewFlagSet("user create", flag.ExitOnError)
+	email := flags.String("email", "", "email address of the user")
+	verified := flags.Bool("verified", false, "consider the email address verified")
+	admin := flags.Bool("admin", false, "assign the admin role")
+	temporary := flags.Bool("temporary", false, "require a new password at the first login")
+	username := parse(flags, args, 1)[0]
+
+	if *email != "" {
+		if addr, err := netmail.ParseAddress(*email); err != nil || addr.Address != *email {
+			log.Fatal().Str("email", *email).Msg("invalid email address")
+		}
+	}
+	password := a.checkPassword(readPassword(), username, *email)
+
+	passwords, err := crypt.New(a.cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to configure password hashing")
+	}
+	hash, err := passwords.Hash(password)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to hash password")
+	}
+	id, err := a.store.NewUUID()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to generate user id")
+	}
+
+	now := time.Now()
+	user := &db.User{ID: id, Username: username, Email: *email, Password: hash, PasswordChangedAt: &now}
+	if *verified && *email != "" {
+		user.EmailVerifiedAt = &now
+	}
+	if *temporary {
+		user.PasswordChangeRequiredAt = &now
+	}
+	inserted, err := a.store.ImportUser(a.ctx, user)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create user")
+	}
+	if !inserted {
+		log.Fatal().Msg("username or email address is already in use")
+	}
+
+	a.audit(db.AuditEvent{Type: audit.UserCreate, TargetID: user.ID, Username: user.Username})
+
+	if *admin {
+		a.changeRole(user, "admin", audit.RoleAssign, a.store.AssignRole)
+	}
+	a.writeUser(user)
+}
+
+func (a *app) listUsers(args []string) {
+	flags := flag.NewFlagSet("user list", flag.ExitOnError)
+	query := flags.String("q", "", "part of the username or email address, ignoring case")
+	disabled := flags.String("disabled", "", "only list disabled (true) or enabled (false) users")
+	after := flags.String("after", "", "username to list from")
+	limit := flags.Int("limit", 100, "maximum number of users")
+	parse(flags, args, 0)
+
+	filter := db.UserFilter{Query: *query, After: *after, Limit: *limit}
+	if *disabled != "" {
+		d, err := strconv.ParseBool(*disabled)
+		if err != nil {
+			log.Fatal().Str("disabled", *disabled).Msg("disabled must be true or false")
+		}
+		filter.Disabled = &d
+	}
+
+	users, err := a.store.ListUsers(a.ctx, filter)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to list users")
+	}
+	output := make([]userOutput, 0, len(users))
+	for i := range users {
+		output = append(output, newUserOutput(&users[i]))
+	}
+
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tSTATUS")
+		for _, u := range output {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Email, u.status())
+		}
+	})
+}
+
+func (a *app) showUser(args []string) {
+	a.writeUser(a.user(noFlags(args, 1)[0]))
+}
+
+func (a *app) setPassword(args []string) {
+	flags := flag.NewFlagSet("user set-password", flag.ExitOnError)
+	temporary := flags.Bool("temporary", false, "require a new password at the next login")
+	user := a.user(parse(flags, args, 1)[0])
+	password := a.checkPassword(readPassword(), user.Username, user.Email)
+
+	passwords, err := crypt.New(a.cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to configure password hashing")
+	}
+	hash, err := passwords.Hash(password)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to hash password")
+	}
+
+	// The service keeps PASSWORD_HISTORY-1 old passwords, which together
+	// with the current one are those that may not be reused.
+	keep := a.cfg.PasswordHistory - 1
+	if keep < 0 {
+		keep = 0
+	}
+	now := time.Now()
+	if err := a.store.UpdatePassword(a.ctx, user.ID, hash, now, keep); err != nil {
+		log.Fatal().Err(err).Msg("failed to set password")
+	}
+	if *temporary {
+		if _, err := a.store.RequirePasswordChange(a.ctx, user.ID, now); err != nil {
+			log.Fatal().Err(err).Msg("failed to require a password change")
+		}
+	}
+	a.audit(db.AuditEvent{Type: audit.UserSetPassword, TargetID: user.ID, Username: user.Username})
+	a.revokeAll(user)
+	a.writeUser(a.user(user.Username))
+}
+
+func (a *app) disableUser(args []string) {
+	user := a.user(noFlags(args, 1)[0])
+	if _, err := a.store.DisableUser(a.ctx, user.ID, time.Now()); err != nil {
+		log.Fatal().Err(err).Msg("failed to disable user")
+	}
+	a.audit(db.AuditEvent{Type: audit.UserDisable, TargetID: user.ID, Username: user.Username})
+	a.revokeAll(user)
+	a.writeUser(a.user(user.Username))
+}
+
+func (a *app) enableUser(args []string) {
+	user := a.user(noFlags(args, 1)[0])
+	if _, err := a.store.EnableUser(a.ctx, user.ID); err != nil {
+		log.Fatal().Err(err).Msg("failed to enable user")
+	}
+	a.audit(db.AuditEvent{Type: audit.UserEnable, TargetID: user.ID, Username: user.Username})
+	a.writeUser(a.user(user.Username))
+}
+
+func (a *app) assignRole(args []string) {
+	args = noFlags(args, 2)
+	user := a.user(args[0])
+	a.changeRole(user, args[1], audit.RoleAssign, a.store.AssignRole)
+	a.writeUser(user)
+}
+
+func (a *app) unassignRole(args []string) {
+	args = noFlags(args, 2)
+	user := a.user(args[0])
+	a.changeRole(user, args[1], audit.RoleUnassign, a.store.UnassignRole)
+	a.writeUser(user)
+}
+
+// changeRole assigns or unassigns, depending on change, the role named name
+// to user, recording it as eventType.
+func (a *app) changeRole(user *db.User, name, eventType string, change func(ctx context.Context, userID, roleID string) error) {
+	role, err := a.store.GetRoleByName(a.ctx, name)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Fatal().Str("role", name).Msg("no such role")
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get role")
+	}
+	if err := change(a.ctx, user.ID, role.ID); err != nil {
+		log.Fatal().Err(err).Msg("failed to change role")
+	}
+	a.audit(db.AuditEvent{Type: eventType, TargetID: user.ID, Username: user.Username, Detail: role.Name})
+}
+
+// checkPassword returns password if it meets the password policy.
+func (a *app) checkPassword(password, username, email string) string {
+	p, err := policy.New(a.cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to configure password policy")
+	}
+	violations, err := p.Check(password, username, email)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to check password policy")
+	}
+	if len(violations) > 0 {
+		messages := make([]string, 0, len(violations))
+		for _, v := range violations {
+			messages = append(messages, v.Message)
+		}
+		log.Fatal().Strs("violations", messages).Msg("password does not meet the password policy")
+	}
+	return password
+}
+
+// writeUser writes user along with their roles and permissions.
+func (a *app) writeUser(user *db.User) {
+	output := newUserOutput(user)
+	roles, permissions, err := a.store.GetUserRoles(a.ctx, user.ID)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to get user roles")
+	}
+	output.Roles, output.Permissions = roles, permissions
+
+	a.write(output, func(w io.Writer) {
+		fmt.Fprintf(w, "ID:\t%s\n", output.ID)
+		fmt.Fprintf(w, "Username:\t%s\n", output.Username)
+		fmt.Fprintf(w, "Email:\t%s\n", output.Email)
+		fmt.Fprintf(w, "Email verified:\t%t\n", output.EmailVerified)
+		if output.PasswordChangedAt != nil {
+			fmt.Fprintf(w, "Password changed:\t%s\n", output.PasswordChangedAt.Format(time.RFC3339))
+		}
+		fmt.Fprintf(w, "Status:\t%s\n", output.status())
+		fmt.Fprintf(w, "Roles:\t%s\n", strings.Join(output.Roles, ", "))
+		fmt.Fprintf(w, "Permissions:\t%s\n", strings.Join(output.Permissions, ", "))
+	})
+}
+
+// revokeAll logs user out everywhere: their refresh tokens are revoked, and
+// so are the access tokens issued to them until now.
+func (a *app) revokeAll(user *db.User) {
+	now := time.Now()
+	if err := a.store.RevokeAllUserTokens(a.ctx, user.ID, now, now.Add(a.cfg.TokenTTL)); err != nil {
+		log.Fatal().Err(err).Msg("failed to revoke sessions")
+	}
+}
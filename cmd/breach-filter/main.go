@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cvele/authentication-service/internal/breach"
+	"github.com/rs/zerolog/log"
+)
+
+const usage = `Usage: breach-filter [flags] <dataset> <filter>
+
+Builds a bloom filter of breached passwords for BREACHED_PASSWORDS_PATH from
+a Pwned Passwords dataset: a directory of range files named by hash prefix,
+or a file listing full SHA-1 hashes as HASH:COUNT lines.
+
+Flags:
+`
+
+func main() {
+	falsePositives := flag.Float64("false-positive-rate", 0.001, "share of passwords that are not breached but reported as breached")
+	minCount := flag.Int("min-count", 1, "times a password must have been seen to be included")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 || *falsePositives <= 0 || *falsePositives >= 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dataset, output := flag.Arg(0), flag.Arg(1)
+
+	// The filter is sized by the number of hashes, so they are read twice
+	var n uint64
+	err := readHashes(dataset, *minCount, func(string) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to read dataset")
+	}
+
+	filter := breach.NewFilter(n, *falsePositives)
+	if err := readHashes(dataset, *minCount, filter.Add); err != nil {
+		log.Fatal().Err(err).Msg("failed to read dataset")
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to create filter")
+	}
+	size, err := filter.WriteTo(f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to write filter")
+	}
+	log.Info().Uint64("hashes", n).Int64("bytes", size).Msg("filter written")
+}
+
+// readHashes calls fn with the full hash of every password in dataset seen
+// at least minCount times.
+func readHashes(dataset string, minCount int, fn func(hash string) error) error {
+	info, err := os.Stat(dataset)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return readFile(dataset, "", minCount, fn)
+	}
+
+	entries, err := os.ReadDir(dataset)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		prefix := strings.ToUpper(strings.TrimSuffix(entry.Name(), ".txt"))
+		if entry.IsDir() || len(prefix) != breach.PrefixLength {
+			continue
+		}
+		if err := readFile(filepath.Join(dataset, entry.Name()), prefix, minCount, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path, prefix string, minCount int, fn func(hash string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var fnErr error
+	err = breach.ReadRange(f, func(suffix string, count int) bool {
+		if count >= minCount {
+			fnErr = fn(prefix + suffix)
+		}
+		return fnErr == nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return fnErr
+}
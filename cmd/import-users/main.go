@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/crypt"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/rs/zerolog/log"
+)
+
+const usage = `Usage: import-users [flags] <file>
+
+Imports users with their password hashes, which are replaced by native ones
+as the users log in. Users whose username or email address is taken are
+skipped.
+
+Formats:
+  jsonl     one JSON object per line with username, email, email_verified
+            and password_hash, a hash in any format the service verifies
+  keycloak  a Keycloak realm or users export, with PBKDF2 password hashes
+  firebase  a Firebase Authentication export from firebase auth:export, with
+            the hash parameters of the project given as flags; set
+            FIREBASE_SIGNER_KEY for the service to verify the hashes
+
+Flags:
+`
+
+// user is a user to import. PasswordHash is in one of the formats
+// crypt.Passwords verifies.
+type user struct {
+	Username      string `json:"username"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	PasswordHash  string `json:"password_hash"`
+}
+
+// firebaseParams are the password hash parameters of a Firebase project.
+type firebaseParams struct {
+	saltSeparator string
+	rounds        int
+	memCost       int
+}
+
+func main() {
+	format := flag.String("format", "jsonl", "format of the file: jsonl, keycloak or firebase")
+	dryRun := flag.Bool("dry-run", false, "check the file without importing anything")
+	var firebase firebaseParams
+	flag.StringVar(&firebase.saltSeparator, "firebase-salt-separator", "", "base64 salt separator of the Firebase project")
+	flag.IntVar(&firebase.rounds, "firebase-rounds", 8, "rounds of the Firebase project")
+	flag.IntVar(&firebase.memCost, "firebase-mem-cost", 14, "memory cost of the Firebase project")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load configuration")
+	}
+	if cfg.DBBackend == "memory" {
+		log.Fatal().Msg("the memory backend is only reachable from within the service")
+	}
+
+	passwords, err := crypt.New(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to configure password hashing")
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to open file")
+	}
+	defer f.Close()
+
+	var users []user
+	switch *format {
+	case "jsonl":
+		users, err = readJSONL(f)
+	case "keycloak":
+		users, err = readKeycloak(f)
+	case "firebase":
+		users, err = readFirebase(f, firebase)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to read users")
+	}
+
+	var store db.Store
+	if !*dryRun {
+		store, err = db.Open(cfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to connect to database")
+		}
+		defer store.Close()
+	}
+
+	var imported, skipped int
+	for _, u := range users {
+		if u.Username == "" {
+			log.Warn().Msg("skipping user without username")
+			skipped++
+			continue
+		}
+		// Hashes with out of range parameters could not be verified later,
+		// and are better found now than at the first login.
+		if err := passwords.Check(u.PasswordHash); err != nil {
+			log.Warn().Err(err).Str("username", u.Username).Msg("skipping user with unknown or invalid password hash")
+			skipped++
+			continue
+		}
+		if *dryRun {
+			imported++
+			continue
+		}
+
+		id, err := store.NewUUID()
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to generate uuid")
+		}
+		now := time.Now()
+		record := &db.User{ID: id, Username: u.Username, Email: u.Email, Password: u.PasswordHash, PasswordChangedAt: &now}
+		if u.EmailVerified && u.Email != "" {
+			record.EmailVerifiedAt = &now
+		}
+
+		inserted, err := store.ImportUser(context.Background(), record)
+		if err != nil {
+			log.Fatal().Err(err).Str("username", u.Username).Msg("failed to import user")
+		}
+		if !inserted {
+			log.Warn().Str("username", u.Username).Msg("skipping user whose username or email address is taken")
+			skipped++
+			continue
+		}
+		imported++
+	}
+
+	log.Info().Int("imported", imported).Int("skipped", skipped).Bool("dry_run", *dryRun).Msg("users imported")
+}
+
+func readJSONL(r io.Reader) ([]user, error) {
+	var users []user
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var u user
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		users = append(users, u)
+	}
+	return users, scanner.Err()
+}
+
+// readKeycloak reads the users of a Keycloak export. Their password
+// credentials hold JSON documents as strings.
+func readKeycloak(r io.Reader) ([]user, error) {
+	var export struct {
+		Users []struct {
+			Username      string `json:"username"`
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"emailVerified"`
+			Credentials   []struct {
+				Type           string `json:"type"`
+				SecretData     string `json:"secretData"`
+				CredentialData string `json:"credentialData"`
+			} `json:"credentials"`
+		} `json:"users"`
+	}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+
+	users := make([]user, 0, len(export.Users))
+	for _, ku := range export.Users {
+		u := user{Username: ku.Username, Email: ku.Email, EmailVerified: ku.EmailVerified}
+		for _, credential := range ku.Credentials {
+			if credential.Type != "password" {
+				continue
+			}
+			hash, err := keycloakHash(credential.SecretData, credential.CredentialData)
+			if err != nil {
+				log.Warn().Err(err).Str("username", ku.Username).Msg("unsupported password credential")
+				continue
+			}
+			u.PasswordHash = hash
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func keycloakHash(secretData, credentialData string) (string, error) {
+	var secret struct {
+		Value string `json:"value"`
+		Salt  string `json:"salt"`
+	}
+	var params struct {
+		HashIterations int    `json:"hashIterations"`
+		Algorithm      string `json:"algorithm"`
+	}
+	if err := json.Unmarshal([]byte(secretData), &secret); err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(credentialData), &params); err != nil {
+		return "", err
+	}
+
+	digest, ok := map[string]string{
+		"pbkdf2":        "sha1",
+		"pbkdf2-sha256": "sha256",
+		"pbkdf2-sha512": "sha512",
+	}[params.Algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported algorithm %q", params.Algorithm)
+	}
+	salt, err := base64.StdEncoding.DecodeString(secret.Salt)
+	if err != nil {
+		return "", err
+	}
+	key, err := base64.StdEncoding.DecodeString(secret.Value)
+	if err != nil {
+		return "", err
+	}
+	return crypt.EncodePBKDF2(digest, params.HashIterations, salt, key), nil
+}
+
+// readFirebase reads the users of a Firebase Authentication export. Firebase
+// has no usernames, users log in with their email address.
+func readFirebase(r io.Reader, params firebaseParams) ([]user, error) {
+	saltSeparator, err := base64.StdEncoding.DecodeString(params.saltSeparator)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt separator: %v", err)
+	}
+
+	var export struct {
+		Users []struct {
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"emailVerified"`
+			PasswordHash  string `json:"passwordHash"`
+			Salt          string `json:"salt"`
+		} `json:"users"`
+	}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+
+	users := make([]user, 0, len(export.Users))
+	for _, fu := range export.Users {
+		u := user{Username: fu.Email, Email: fu.Email, EmailVerified: fu.EmailVerified}
+		salt, saltErr := base64.StdEncoding.DecodeString(fu.Salt)
+		hash, hashErr := base64.StdEncoding.DecodeString(fu.PasswordHash)
+		if saltErr == nil && hashErr == nil && len(hash) > 0 {
+			u.PasswordHash = crypt.EncodeFirebaseScrypt(params.memCost, params.rounds, saltSeparator, salt, hash)
+		}
+		users = append(users, u)
+	}
+	return users, nil
+}
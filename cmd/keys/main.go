@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+	"github.com/rs/zerolog/log"
+)
+
+const usage = `Usage: keys <command>
+
+Commands:
+  rotate  generate a new signing key and retire the active one
+  list    list the signing keys that can still verify tokens
+`
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to load configuration")
+	}
+	if cfg.DBBackend == "memory" {
+		log.Fatal().Msg("the memory backend is only reachable from within the service")
+	}
+
+	store, err := db.Open(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to connect to database")
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	switch os.Args[1] {
+	case "rotate":
+		keys, err := token.LoadKeyring(ctx, cfg, store)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to load signing keys")
+		}
+
+		key, err := keys.Rotate(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to rotate signing key")
+		}
+		log.Info().Str("kid", key.ID).Str("algorithm", key.Algorithm).Msg("signing key rotated")
+	case "list":
+		stored, err := store.ListSigningKeys(ctx)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to list signing keys")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KID\tALGORITHM\tCREATED\tSTATUS")
+		for _, key := range stored {
+			status := "active"
+			if key.RetiredAt != nil {
+				status = "verify-only until " + key.ExpiresAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key.ID, key.Algorithm, key.CreatedAt.Format(time.RFC3339), status)
+		}
+		w.Flush()
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
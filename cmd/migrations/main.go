@@ -19,6 +19,12 @@ func main() {
 		log.Fatal().Err(err).Msg("failed to load configuration")
 	}
 
+	// The service creates the schema of the other backends itself
+	if cfg.DBBackend != "cockroachdb" {
+		log.Info().Str("backend", cfg.DBBackend).Msg("nothing to migrate")
+		return
+	}
+
 	// Connect to the database
 	connStr := fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
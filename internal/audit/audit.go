@@ -0,0 +1,133 @@
+// Package audit names the events recorded in the audit log, and verifies
+// that the chain the log forms has not been tampered with.
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cvele/authentication-service/internal/db"
+)
+
+// Types of events.
+const (
+	Login                = "login"
+	LoginMFA             = "login.mfa"
+	LoginPasskey         = "login.passkey"
+	Register             = "register"
+	EmailVerify          = "email.verify"
+	PasswordChange       = "password.change"
+	PasswordResetRequest = "password.reset_request"
+	PasswordReset        = "password.reset"
+	Logout               = "logout"
+	LogoutAll            = "logout.all"
+	RefreshTokenReuse    = "refresh_token.reuse"
+	TOTPEnable           = "totp.enable"
+	TOTPDisable          = "totp.disable"
+	PasskeyAdd           = "passkey.add"
+	PasskeyRemove        = "passkey.remove"
+	OAuthAuthorize       = "oauth.authorize"
+	OAuthToken           = "oauth.token"
+	UserCreate           = "user.create"
+	UserDisable          = "user.disable"
+	UserEnable           = "user.enable"
+	UserDelete           = "user.delete"
+	UserSetPassword      = "user.set_password"
+	UserForceReset       = "user.force_password_reset"
+	UserUnlock           = "user.unlock"
+	SessionsRevoke       = "sessions.revoke"
+	RoleSave             = "role.save"
+	RoleAssign           = "role.assign"
+	RoleUnassign         = "role.unassign"
+	ClientCreate         = "client.create"
+	ClientDelete         = "client.delete"
+	KeyRotate            = "key.rotate"
+	TokenMint            = "token.mint"
+)
+
+// Outcomes of events.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Reasons events failed, or what is left to be done after they succeeded.
+const (
+	ReasonInvalidCredentials = "invalid_credentials"
+	ReasonLocked             = "locked"
+	ReasonDisabled           = "disabled"
+	ReasonEmailUnverified    = "email_unverified"
+	ReasonPasswordExpired    = "password_expired"
+	ReasonMFARequired        = "mfa_required"
+	ReasonInvalidCode        = "invalid_code"
+	ReasonInvalidToken       = "invalid_token"
+	ReasonUnknownEmail       = "unknown_email"
+)
+
+// verifyBatchSize is how many events Verify reads at a time.
+const verifyBatchSize = 1000
+
+// Store is what Verify reads the chain from. db.Store implements it.
+type Store interface {
+	GetAuditChainHead(ctx context.Context) (int64, string, error)
+	ListAuditEvents(ctx context.Context, filter db.AuditFilter) ([]db.AuditEvent, error)
+}
+
+// ChainError is returned by Verify for the newest event at which the chain
+// is broken. Events before it may have been tampered with as well.
+type ChainError struct {
+	Seq     int64
+	Problem string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at event %d: %s", e.Seq, e.Problem)
+}
+
+// Verify walks the chain from its head back to the first event, checking
+// that every event has the hash of its fields, that it is the event the
+// next one points at, and that none is missing. It returns how many events
+// were checked. Rewriting the chain from some event on, hashes included,
+// goes unnoticed unless the head was recorded elsewhere before.
+func Verify(ctx context.Context, store Store) (int64, error) {
+	seq, hash, err := store.GetAuditChainHead(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// The event checked next must have the seq and hash the one checked
+	// before, or the head, points at.
+	var checked, before int64
+	for {
+		events, err := store.ListAuditEvents(ctx, db.AuditFilter{Before: before, Limit: verifyBatchSize})
+		if err != nil {
+			return checked, err
+		}
+		for _, e := range events {
+			switch {
+			case e.Seq > seq:
+				return checked, &ChainError{Seq: e.Seq, Problem: "event is not part of the chain"}
+			case e.Seq < seq:
+				return checked, &ChainError{Seq: seq, Problem: "event is missing"}
+			case e.Hash != hash:
+				return checked, &ChainError{Seq: seq, Problem: "event was replaced"}
+			case e.ChainHash() != e.Hash:
+				return checked, &ChainError{Seq: seq, Problem: "event was altered"}
+			}
+			seq, hash = e.Seq-1, e.PrevHash
+			checked++
+		}
+		if len(events) < verifyBatchSize {
+			break
+		}
+		before = events[len(events)-1].Seq
+	}
+
+	switch {
+	case seq > 0:
+		return checked, &ChainError{Seq: seq, Problem: "event is missing"}
+	case hash != "":
+		return checked, &ChainError{Seq: 1, Problem: "first event points at an earlier one"}
+	}
+	return checked, nil
+}
@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/db"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	for _, n := range []int{0, 1, 3, verifyBatchSize, 2*verifyBatchSize + 500} {
+		store := db.NewMemory()
+		appendEvents(t, store, n)
+		checked, err := Verify(ctx, store)
+		if err != nil {
+			t.Errorf("Verify() of %d events error = %v, want nil", n, err)
+		}
+		if checked != int64(n) {
+			t.Errorf("Verify() checked %d events, want %d", checked, n)
+		}
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := []struct {
+		name    string
+		tamper  func(c *chain)
+		wantSeq int64
+		want    string
+	}{
+		{
+			name:    "field altered",
+			tamper:  func(c *chain) { c.events[4].Outcome = Success },
+			wantSeq: 5,
+			want:    "event was altered",
+		},
+		{
+			name: "event replaced along with its hash",
+			tamper: func(c *chain) {
+				c.events[4].Outcome = Success
+				c.events[4].Hash = c.events[4].ChainHash()
+			},
+			wantSeq: 5,
+			want:    "event was replaced",
+		},
+		{
+			name: "newest event altered along with its hash",
+			tamper: func(c *chain) {
+				c.events[9].Outcome = Success
+				c.events[9].Hash = c.events[9].ChainHash()
+			},
+			wantSeq: 10,
+			want:    "event was replaced",
+		},
+		{
+			name:    "event deleted",
+			tamper:  func(c *chain) { c.events = append(c.events[:4], c.events[5:]...) },
+			wantSeq: 5,
+			want:    "event is missing",
+		},
+		{
+			name:    "newest event deleted",
+			tamper:  func(c *chain) { c.events = c.events[:9] },
+			wantSeq: 10,
+			want:    "event is missing",
+		},
+		{
+			name:    "first event deleted",
+			tamper:  func(c *chain) { c.events = c.events[1:] },
+			wantSeq: 1,
+			want:    "event is missing",
+		},
+		{
+			name: "event added after the head",
+			tamper: func(c *chain) {
+				e := c.events[9]
+				e.Seq, e.PrevHash = 11, e.Hash
+				e.Hash = e.ChainHash()
+				c.events = append(c.events, e)
+			},
+			wantSeq: 11,
+			want:    "event is not part of the chain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChain(t, 10)
+			tt.tamper(c)
+			_, err := Verify(context.Background(), c)
+			var chainErr *ChainError
+			if !errors.As(err, &chainErr) {
+				t.Fatalf("Verify() error = %v, want a *ChainError", err)
+			}
+			if chainErr.Seq != tt.wantSeq || chainErr.Problem != tt.want {
+				t.Errorf("Verify() error = %v, want %q at event %d", err, tt.want, tt.wantSeq)
+			}
+		})
+	}
+}
+
+func TestVerifyDetectsPrevHashOfFirstEvent(t *testing.T) {
+	c := newChain(t, 1)
+	c.events[0].PrevHash = "00"
+	c.events[0].Hash = c.events[0].ChainHash()
+	c.headHash = c.events[0].Hash
+
+	_, err := Verify(context.Background(), c)
+	var chainErr *ChainError
+	if !errors.As(err, &chainErr) || chainErr.Seq != 1 || chainErr.Problem != "first event points at an earlier one" {
+		t.Errorf("Verify() error = %v, want the first event pointing at an earlier one", err)
+	}
+}
+
+func TestVerifyReturnsStoreErrors(t *testing.T) {
+	c := newChain(t, 3)
+	c.err = errors.New("unavailable")
+	if _, err := Verify(context.Background(), c); err != c.err {
+		t.Errorf("Verify() error = %v, want %v", err, c.err)
+	}
+}
+
+// chain is a Store holding a copy of the events of a db.Memory, oldest
+// first, that tests can tamper with.
+type chain struct {
+	headSeq  int64
+	headHash string
+	events   []db.AuditEvent
+	err      error
+}
+
+func newChain(t *testing.T, n int) *chain {
+	t.Helper()
+	ctx := context.Background()
+	store := db.NewMemory()
+	appendEvents(t, store, n)
+
+	c := &chain{}
+	var err error
+	c.headSeq, c.headHash, err = store.GetAuditChainHead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := store.ListAuditEvents(ctx, db.AuditFilter{Limit: n})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		c.events = append(c.events, events[i])
+	}
+	return c
+}
+
+func (c *chain) GetAuditChainHead(ctx context.Context) (int64, string, error) {
+	return c.headSeq, c.headHash, nil
+}
+
+func (c *chain) ListAuditEvents(ctx context.Context, filter db.AuditFilter) ([]db.AuditEvent, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	var events []db.AuditEvent
+	for i := len(c.events) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		if filter.Before == 0 || c.events[i].Seq < filter.Before {
+			events = append(events, c.events[i])
+		}
+	}
+	return events, nil
+}
+
+func appendEvents(t *testing.T, store *db.Memory, n int) {
+	t.Helper()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		err := store.AppendAuditEvent(context.Background(), &db.AuditEvent{
+			OccurredAt: start.Add(time.Duration(i) * time.Second),
+			Type:       Login,
+			Username:   fmt.Sprintf("user-%d", i),
+			IP:         "198.51.100.1",
+			Outcome:    Failure,
+			Reason:     ReasonInvalidCredentials,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+type RoleResponse struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type UserRolesResponse struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// RequirePermission only lets requests through to next if they carry a valid
+// bearer token holding permission.
+func (api *API) RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString := bearerToken(r)
+		if tokenString == "" {
+			http.Error(w, "Authorization header is missing", http.StatusUnauthorized)
+			return
+		}
+		claims, err := token.Validate(r.Context(), tokenString, "", api.keys, api.db, api.cfg)
+		if err != nil {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		if !claims.HasPermission(permission) {
+			http.Error(w, "missing permission", http.StatusForbidden)
+			return
+		}
+
+		next(w, withClaims(r, claims))
+	}
+}
+
+// @Summary List roles
+// @Description List every role along with its permissions
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} RoleResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/roles [get]
+func (api *API) ListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := api.db.ListRoles(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing roles")
+		http.Error(w, "Error listing roles", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]RoleResponse, 0, len(roles))
+	for _, role := range roles {
+		response = append(response, newRoleResponse(&role))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Create or update a role
+// @Description Create a role, or update the description of an existing one, and replace its permissions.
+// @Description Permissions that do not exist yet are created.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param role path string true "Role name"
+// @Param description body string false "Description"
+// @Param permissions body []string true "Permissions"
+// @Success 200 {object} RoleResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/roles/{role} [put]
+func (api *API) SaveRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Description string   `json:"description"`
+		Permissions []string `json:"permissions"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	role := &db.Role{
+		Name:        mux.Vars(r)["role"],
+		Description: data.Description,
+		Permissions: data.Permissions,
+	}
+	for _, permission := range role.Permissions {
+		if permission == "" {
+			http.Error(w, "permissions must not be empty", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := api.db.SaveRole(r.Context(), role); err != nil {
+		log.Error().Err(err).Msg("Error saving role")
+		http.Error(w, "Error saving role", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.RoleSave, Outcome: audit.Success, Detail: role.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(newRoleResponse(role))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary List the roles of a user
+// @Description List the roles assigned to a user and the permissions they grant
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} UserRolesResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/roles [get]
+func (api *API) UserRolesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.adminUser(r.Context(), w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	roles, permissions, err := api.db.GetUserRoles(r.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user roles")
+		http.Error(w, "Error fetching user roles", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(UserRolesResponse{
+		Roles:       append([]string{}, roles...),
+		Permissions: append([]string{}, permissions...),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Assign a role
+// @Description Assign a role to a user. It is reflected in tokens issued from then on.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param role path string true "Role name"
+// @Success 200 {object} EmptyResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/roles/{role} [put]
+func (api *API) AssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeUserRole(w, r, audit.RoleAssign, api.db.AssignRole)
+}
+
+// @Summary Unassign a role
+// @Description Remove a role from a user. It is reflected in tokens issued from then on.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Param role path string true "Role name"
+// @Success 200 {object} EmptyResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/roles/{role} [delete]
+func (api *API) UnassignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	api.changeUserRole(w, r, audit.RoleUnassign, api.db.UnassignRole)
+}
+
+func (api *API) changeUserRole(w http.ResponseWriter, r *http.Request, eventType string, change func(ctx context.Context, userID, roleID string) error) {
+	vars := mux.Vars(r)
+	user, ok := api.adminUser(r.Context(), w, vars["id"])
+	if !ok {
+		return
+	}
+
+	role, err := api.db.GetRoleByName(r.Context(), vars["role"])
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching role")
+		http.Error(w, "Error fetching role", http.StatusInternalServerError)
+		return
+	}
+
+	if err := change(r.Context(), user.ID, role.ID); err != nil {
+		log.Error().Err(err).Msg("Error updating user roles")
+		http.Error(w, "Error updating user roles", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: eventType, TargetID: user.ID, Username: user.Username, Outcome: audit.Success, Detail: role.Name})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// adminUser fetches the user an admin request refers to, writing the error
+// response itself when that fails.
+func (api *API) adminUser(ctx context.Context, w http.ResponseWriter, id string) (*db.User, bool) {
+	user, err := api.db.GetUserByID(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+func newRoleResponse(role *db.Role) RoleResponse {
+	return RoleResponse{
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: append([]string{}, role.Permissions...),
+	}
+}
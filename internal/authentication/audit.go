@@ -0,0 +1,179 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+const (
+	defaultAuditListLimit = 50
+	maxAuditListLimit     = 500
+)
+
+// maxUserAgentLength bounds the user agents recorded in the audit log.
+const maxUserAgentLength = 512
+
+type AuditEventResponse struct {
+	Seq        int64     `json:"seq"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Type       string    `json:"type"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	TargetID   string    `json:"target_id,omitempty"`
+	ClientID   string    `json:"client_id,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Reason     string    `json:"reason,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// AuditEventListResponse is a page of audit events, newest first. Next is
+// passed as before to get the following page, and is zero on the last one.
+type AuditEventListResponse struct {
+	Events []AuditEventResponse `json:"events"`
+	Next   int64                `json:"next,omitempty"`
+}
+
+// claimsKey is the context key RequirePermission stores the claims of the
+// token it let through under.
+type claimsKey struct{}
+
+// audit records event about r in the audit log, filling in when and where
+// from it happened and, if it is left empty, the admin acting. The request
+// goes on if the event cannot be recorded.
+func (api *API) audit(r *http.Request, event db.AuditEvent) {
+	event.OccurredAt = time.Now()
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	if len(event.UserAgent) > maxUserAgentLength {
+		event.UserAgent = event.UserAgent[:maxUserAgentLength]
+	}
+	// The database only takes valid UTF-8, which headers need not be.
+	event.UserAgent = strings.ToValidUTF8(event.UserAgent, "")
+	if claims, ok := r.Context().Value(claimsKey{}).(*token.Claims); ok && event.ActorID == "" {
+		event.ActorID, event.ClientID = claims.Subject, claims.ClientID
+	}
+
+	if err := api.db.AppendAuditEvent(r.Context(), &event); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Error recording audit event")
+	}
+}
+
+// withClaims returns r carrying claims for audit.
+func withClaims(r *http.Request, claims *token.Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+}
+
+// @Summary List audit events
+// @Description List the events of the audit log, newest first, a page at a time.
+// @Description Every filter selects the events with exactly that value.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param type query string false "Event type, such as login or user.disable"
+// @Param actor_id query string false "User or client that caused the event"
+// @Param target_id query string false "User the event happened to"
+// @Param client_id query string false "OAuth client the event went through"
+// @Param username query string false "Username given"
+// @Param ip query string false "Address the request came from"
+// @Param outcome query string false "success or failure"
+// @Param since query string false "Only events at or after this time, in RFC 3339 format"
+// @Param until query string false "Only events before this time, in RFC 3339 format"
+// @Param before query int false "Sequence number to list from, the next field of the previous page"
+// @Param limit query int false "Events per page, 50 by default and at most 500"
+// @Success 200 {object} AuditEventListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/audit-events [get]
+func (api *API) ListAuditEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Type:     query.Get("type"),
+		ActorID:  query.Get("actor_id"),
+		TargetID: query.Get("target_id"),
+		ClientID: query.Get("client_id"),
+		Username: query.Get("username"),
+		IP:       query.Get("ip"),
+		Outcome:  query.Get("outcome"),
+		Limit:    defaultAuditListLimit,
+	}
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if s := query.Get(name); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*t = parsed
+		}
+	}
+	if s := query.Get("before"); s != "" {
+		before, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || before < 1 {
+			http.Error(w, "invalid before", http.StatusBadRequest)
+			return
+		}
+		filter.Before = before
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxAuditListLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	// One more event than asked for tells whether there is another page.
+	filter.Limit++
+	events, err := api.db.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing audit events")
+		http.Error(w, "Error listing audit events", http.StatusInternalServerError)
+		return
+	}
+
+	response := AuditEventListResponse{Events: []AuditEventResponse{}}
+	if len(events) == filter.Limit {
+		events = events[:len(events)-1]
+		response.Next = events[len(events)-1].Seq
+	}
+	for _, e := range events {
+		response.Events = append(response.Events, AuditEventResponse{
+			Seq:        e.Seq,
+			OccurredAt: e.OccurredAt,
+			Type:       e.Type,
+			ActorID:    e.ActorID,
+			TargetID:   e.TargetID,
+			ClientID:   e.ClientID,
+			Username:   e.Username,
+			IP:         e.IP,
+			UserAgent:  e.UserAgent,
+			Outcome:    e.Outcome,
+			Reason:     e.Reason,
+			Detail:     e.Detail,
+			PrevHash:   e.PrevHash,
+			Hash:       e.Hash,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
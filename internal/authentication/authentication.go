@@ -1,68 +1,230 @@
 package authentication
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
+	"github.com/cvele/authentication-service/internal/audit"
 	"github.com/cvele/authentication-service/internal/config"
 	"github.com/cvele/authentication-service/internal/crypt"
 	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/mail"
+	"github.com/cvele/authentication-service/internal/policy"
 	"github.com/cvele/authentication-service/internal/token"
 )
 
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
 type API struct {
-	cfg *config.Config
-	db  db.DB
+	cfg       *config.Config
+	db        db.Store
+	keys      *token.Keyring
+	mailer    mail.Sender
+	passwords *crypt.Passwords
+	policy    *policy.Policy
 }
 type EmptyResponse struct{}
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
 
+// TokenResponse is a token pair. PasswordBreached is set on logins of users
+// whose password was found among the breached passwords.
 type TokenResponse struct {
-	Token string `json:"token"`
+	Token            string `json:"token"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Scope            string `json:"scope,omitempty"`
+	PasswordBreached bool   `json:"password_breached,omitempty"`
 }
 
-func NewAPI(cfg *config.Config, db db.DB) (*API, error) {
+func NewAPI(cfg *config.Config, db db.Store, keys *token.Keyring, mailer mail.Sender) (*API, error) {
+	passwords, err := crypt.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy, err := policy.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Delivered verification links must not depend on the Host header.
+	if cfg.SMTPHost != "" && cfg.EmailVerificationURL == "" && issuerURL(cfg) == "" {
+		return nil, errors.New("EMAIL_VERIFICATION_URL, or JWT_ISSUER set to the URL of the service, is required when SMTP_HOST is set")
+	}
 	return &API{
-		cfg: cfg,
-		db:  db,
+		cfg:       cfg,
+		db:        db,
+		keys:      keys,
+		mailer:    mailer,
+		passwords: passwords,
+		policy:    passwordPolicy,
 	}, nil
 }
 
-func (api *API) AuthenticateUser(username string, password string) (bool, string, error) {
+// AuthenticateUser returns the user with username if password is theirs, or
+// nil if it is not.
+func (api *API) AuthenticateUser(ctx context.Context, username string, password string) (*db.User, error) {
 	// Fetch the user's record from the database
-	user, err := api.db.GetUserByUsername(username)
+	user, err := api.db.GetUserByUsername(ctx, username)
 	if err != nil {
-		return false, "", err
+		return nil, err
 	}
 
-	if crypt.CheckPasswordHash(password, user.Password) {
-		return true, user.ID, nil
-	}
 	// Check the password against the stored hash
-	return false, "", nil
+	ok, rehash, err := api.passwords.Verify(password, user.Password)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	// Hashes made with other parameters than the current ones are replaced
+	// while the password is at hand. The login goes on if that fails.
+	if rehash {
+		if hash, err := api.passwords.Hash(password); err != nil {
+			log.Error().Err(err).Msg("Error rehashing password")
+		} else if err := api.db.RehashPassword(ctx, user.ID, user.Password, hash); err != nil {
+			log.Error().Err(err).Msg("Error rehashing password")
+		}
+	}
+
+	if api.cfg.BreachedPasswordsOnLogin && user.PasswordBreachedAt == nil {
+		api.flagBreachedPassword(ctx, user, password)
+	}
+	return user, nil
+}
+
+// flagBreachedPassword flags user if password, theirs, is among the breached
+// passwords, so that they can be asked to change it.
+func (api *API) flagBreachedPassword(ctx context.Context, user *db.User, password string) {
+	breached, err := api.policy.IsBreached(password)
+	if err != nil {
+		log.Error().Err(err).Msg("Error checking for breached password")
+		return
+	}
+	if !breached {
+		return
+	}
+
+	now := time.Now()
+	if err := api.db.FlagPasswordBreached(ctx, user.ID, now); err != nil {
+		log.Error().Err(err).Msg("Error flagging breached password")
+		return
+	}
+	user.PasswordBreachedAt = &now
+}
+
+// bearerToken returns the token from the Authorization header, with or
+// without the "Bearer" scheme prefix.
+func bearerToken(r *http.Request) string {
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return header
+}
+
+// grant describes whom a token pair is issued to. Tokens issued through the
+// OAuth endpoints also record the client and the scope it was granted.
+type grant struct {
+	userID   string
+	audience string
+	clientID string
+	scope    string
+}
+
+func refreshTokenGrant(rt *db.RefreshToken) grant {
+	return grant{userID: rt.UserID, audience: rt.Audience, clientID: rt.ClientID, scope: rt.Scope}
+}
+
+// issueTokens mints an access token for g together with a new refresh token
+// in familyID. When previous is set it is consumed in the same transaction,
+// so every refresh token can be exchanged exactly once.
+func (api *API) issueTokens(ctx context.Context, g grant, familyID string, previous *db.RefreshToken) (*TokenResponse, error) {
+	roles, permissions, err := api.db.GetUserRoles(ctx, g.userID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := token.New(&token.Claims{
+		UserID:      g.userID,
+		ClientID:    g.clientID,
+		Scope:       g.scope,
+		Roles:       roles,
+		Permissions: permissions,
+	}, g.audience, api.keys, api.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := token.NewOpaque()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := api.db.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rt := &db.RefreshToken{
+		ID:        id,
+		UserID:    g.userID,
+		FamilyID:  familyID,
+		TokenHash: token.HashOpaque(refreshToken),
+		Audience:  g.audience,
+		ClientID:  g.clientID,
+		Scope:     g.scope,
+		CreatedAt: now,
+		ExpiresAt: now.Add(api.cfg.RefreshTokenTTL),
+	}
+
+	if previous == nil {
+		err = api.db.InsertRefreshToken(ctx, rt)
+	} else {
+		err = api.db.RotateRefreshToken(ctx, previous.ID, rt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(api.cfg.TokenTTL.Seconds()),
+		Scope:        g.scope,
+	}, nil
 }
 
 // @Summary Authenticate a user
 // @Description Authenticate a user with a username and password
+// @Description Users with TOTP enabled get a 202 with an mfa_token instead, to complete the login at /login/mfa.
+// @Description Passkeys do not make a second factor required: they are an alternative to the password, see /webauthn/login/begin.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param username body string true "Username"
 // @Param password body string true "Password"
+// @Param audience body string false "Audience the token is intended for, one of JWT_AUDIENCES"
 // @Success 200 {object} TokenResponse
+// @Success 202 {object} MFAChallengeResponse "Two-factor authentication is required, continue at /login/mfa"
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} PasswordExpiredResponse "The email address has to be verified first, or the password has expired and has to be changed with the returned token"
+// @Failure 429 {object} ErrorResponse "Too many failed logins or requests, retry after the Retry-After header"
 // @Failure 500 {object} ErrorResponse
 // @Router /login [post]
 func (api *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Audience string `json:"audience"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
@@ -70,39 +232,98 @@ func (api *API) LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authenticated, userID, err := api.AuthenticateUser(data.Username, data.Password)
-	if err != nil || !authenticated {
+	if !token.AllowedAudience(data.Audience, api.cfg) {
+		http.Error(w, "unknown audience", http.StatusBadRequest)
+		return
+	}
+
+	ip := clientIP(r)
+	lockedUntil, err := api.loginLockedUntil(r.Context(), data.Username, ip)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching failed logins")
+		http.Error(w, "Error fetching failed logins", http.StatusInternalServerError)
+		return
+	}
+	if !lockedUntil.IsZero() {
+		api.audit(r, db.AuditEvent{Type: audit.Login, Username: data.Username, Outcome: audit.Failure, Reason: audit.ReasonLocked})
+		writeLoginLocked(w, lockedUntil)
+		return
+	}
+
+	user, err := api.AuthenticateUser(r.Context(), data.Username, data.Password)
+	if err != nil || user == nil {
+		api.loginFailed(r.Context(), data.Username, ip, err)
+		api.audit(r, db.AuditEvent{Type: audit.Login, Username: data.Username, Outcome: audit.Failure, Reason: audit.ReasonInvalidCredentials})
 		http.Error(w, "invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	if user.DisabledAt != nil {
+		api.audit(r, db.AuditEvent{Type: audit.Login, TargetID: user.ID, Username: data.Username, Outcome: audit.Failure, Reason: audit.ReasonDisabled})
+		http.Error(w, accountDisabled, http.StatusForbidden)
+		return
+	}
+	userID := user.ID
+	if !api.checkEmailVerified(r.Context(), w, userID) {
+		api.audit(r, db.AuditEvent{Type: audit.Login, TargetID: userID, Username: data.Username, Outcome: audit.Failure, Reason: audit.ReasonEmailUnverified})
+		return
+	}
 
-	tokenString, err := token.New(userID, api.cfg)
+	mfa, err := api.requiresMFA(r.Context(), userID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Error().Err(err).Msg("Error fetching TOTP secret")
+		http.Error(w, "Error fetching TOTP secret", http.StatusInternalServerError)
+		return
+	}
+	if mfa != nil {
+		api.audit(r, db.AuditEvent{Type: audit.Login, ActorID: userID, TargetID: userID, Username: data.Username, Outcome: audit.Success, Reason: audit.ReasonMFARequired})
+		api.writeMFAChallenge(r.Context(), w, userID, data.Audience)
+		return
+	}
+	api.clearLoginFailures(r.Context(), data.Username)
+	if api.passwordExpired(user) {
+		api.audit(r, db.AuditEvent{Type: audit.Login, ActorID: userID, TargetID: userID, Username: data.Username, Outcome: audit.Success, Reason: audit.ReasonPasswordExpired})
+		api.writePasswordExpired(w, userID)
 		return
 	}
 
+	// Every login starts a new refresh token family.
+	familyID, err := api.db.NewUUID()
+	if err != nil {
+		http.Error(w, "Error generating uuid", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := api.issueTokens(r.Context(), grant{userID: userID, audience: data.Audience}, familyID, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Error issuing tokens")
+		http.Error(w, "Error issuing tokens", http.StatusInternalServerError)
+		return
+	}
+	tokens.PasswordBreached = user.PasswordBreachedAt != nil
+	api.audit(r, db.AuditEvent{Type: audit.Login, ActorID: userID, TargetID: userID, Username: data.Username, Outcome: audit.Success})
+
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(TokenResponse{
-		Token: tokenString,
-	})
+	err = json.NewEncoder(w).Encode(tokens)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
 // @Summary Refresh an authentication token
-// @Description Refresh an existing token with a new one
+// @Description Exchange a refresh token for a new access token and refresh token.
+// @Description Each refresh token can be used once; presenting it again revokes every token issued from the same login.
 // @Tags Authentication
+// @Accept json
 // @Produce json
-// @Param token body string true "Token"
+// @Param refresh_token body string true "Refresh token"
 // @Success 200 {object} TokenResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
 // @Router /refresh [post]
 func (api *API) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Token string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
@@ -110,39 +331,88 @@ func (api *API) RefreshHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, err := token.Validate(data.Token, api.cfg)
-	if err != nil {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+	if data.RefreshToken == "" {
+		http.Error(w, "refresh_token is required", http.StatusBadRequest)
 		return
 	}
 
-	tokenString, err := token.New(userID, api.cfg)
+	// Refresh tokens issued to OAuth clients can only be used at /token.
+	tokens, err := api.refresh(r, data.RefreshToken, "")
+	if errors.Is(err, errInvalidRefreshToken) {
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Error().Err(err).Msg("Error refreshing tokens")
+		http.Error(w, "Error refreshing tokens", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(TokenResponse{
-		Token: tokenString,
-	})
+	err = json.NewEncoder(w).Encode(tokens)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
+// refresh exchanges the presented refresh token, which must have been issued
+// to clientID, for a new token pair. errInvalidRefreshToken is returned for
+// unknown, expired, revoked and replayed tokens.
+func (api *API) refresh(r *http.Request, presented, clientID string) (*TokenResponse, error) {
+	ctx := r.Context()
+	stored, err := api.db.GetRefreshTokenByHash(ctx, token.HashOpaque(presented))
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.ClientID != clientID || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		return nil, errInvalidRefreshToken
+	}
+
+	// A refresh token that was already exchanged is being replayed, so the
+	// whole family has to be considered compromised.
+	if stored.UsedAt != nil {
+		api.revokeRefreshTokenFamily(r, stored)
+		return nil, errInvalidRefreshToken
+	}
+
+	tokens, err := api.issueTokens(ctx, refreshTokenGrant(stored), stored.FamilyID, stored)
+	if errors.Is(err, db.ErrRefreshTokenReused) {
+		api.revokeRefreshTokenFamily(r, stored)
+		return nil, errInvalidRefreshToken
+	}
+	return tokens, err
+}
+
+func (api *API) revokeRefreshTokenFamily(r *http.Request, rt *db.RefreshToken) {
+	log.Warn().Str("user_id", rt.UserID).Str("family_id", rt.FamilyID).Msg("Refresh token reuse detected, revoking token family")
+	api.audit(r, db.AuditEvent{Type: audit.RefreshTokenReuse, TargetID: rt.UserID, ClientID: rt.ClientID, Outcome: audit.Failure, Reason: audit.ReasonInvalidToken})
+	if err := api.db.RevokeRefreshTokenFamily(r.Context(), rt.FamilyID); err != nil {
+		log.Error().Err(err).Msg("Error revoking refresh token family")
+	}
+}
+
 // @Summary Validate a token
-// @Description Validate a JWT token
+// @Description Validate a JWT token and check that it has not been revoked.
+// @Description Without an audience, tokens for any of JWT_AUDIENCES are accepted.
+// @Description When a permission is given, the token must also hold it.
 // @Tags Authentication
 // @Produce json
 // @Param token body string true "Token"
+// @Param audience body string false "Audience the token must be intended for"
+// @Param permission body string false "Permission the token must hold"
 // @Success 200 {object} EmptyResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
 // @Router /validate [post]
 func (api *API) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 	var data struct {
-		Token string `json:"token"`
+		Token      string `json:"token"`
+		Audience   string `json:"audience"`
+		Permission string `json:"permission"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
@@ -150,13 +420,19 @@ func (api *API) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := token.Validate(data.Token, api.cfg); err != nil {
+	claims, err := token.Validate(r.Context(), data.Token, data.Audience, api.keys, api.db, api.cfg)
+	if err != nil {
 		http.Error(w, "invalid token", http.StatusUnauthorized)
 		return
 	}
 
+	if data.Permission != "" && !claims.HasPermission(data.Permission) {
+		http.Error(w, "missing permission", http.StatusForbidden)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	err := json.NewEncoder(w).Encode(EmptyResponse{})
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -164,20 +440,24 @@ func (api *API) ValidateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // @Summary Register a user
-// @Description Register a new user with a username and password
+// @Description Register a new user with a username, email address and password.
+// @Description A link to verify the address is sent to it.
 // @Tags Authentication
 // @Accept json
 // @Produce json
 // @Param username body string true "Username"
+// @Param email body string true "Email address"
 // @Param password body string true "Password"
 // @Success 201 {object} EmptyResponse
-// @Failure 400 {object} ErrorResponse
+// @Failure 400 {object} PasswordPolicyErrorResponse "Invalid request, or a password violating the password policy"
 // @Failure 409 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
 // @Failure 500 {object} ErrorResponse
 // @Router /register [post]
 func (api *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 	type RegisterRequest struct {
 		Username string `json:"username"`
+		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
 	// Read the request body.
@@ -200,8 +480,8 @@ func (api *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingUser, err := api.db.GetUserByUsername(req.Username)
-	if err != nil {
+	existingUser, err := api.db.GetUserByUsername(r.Context(), req.Username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
 		log.Error().Err(err).Msg("Error checking for existing user")
 		http.Error(w, "Error checking for existing user", http.StatusInternalServerError)
 		return
@@ -211,8 +491,28 @@ func (api *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	req.Email = strings.TrimSpace(req.Email)
+	if err := validateEmail(req.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	existingUser, err = api.db.GetUserByEmail(r.Context(), req.Email)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("Error checking for existing user")
+		http.Error(w, "Error checking for existing user", http.StatusInternalServerError)
+		return
+	}
+	if existingUser != nil {
+		http.Error(w, "Email address is already in use", http.StatusConflict)
+		return
+	}
+
+	if !api.checkPasswordPolicy(w, req.Password, req.Username, req.Email) {
+		return
+	}
+
 	// Hash the user's password before storing it in the database.
-	hashedPassword, err := crypt.HashPassword(req.Password)
+	hashedPassword, err := api.passwords.Hash(req.Password)
 	if err != nil {
 		http.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
@@ -225,7 +525,7 @@ func (api *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Insert the new user into the database.
-	err = api.db.InsertUser(id, req.Username, string(hashedPassword))
+	err = api.db.InsertUser(r.Context(), id, req.Username, req.Email, string(hashedPassword))
 
 	if err != nil {
 		log.Error().Err(err).Msg("Error creating user")
@@ -233,20 +533,29 @@ func (api *API) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	api.audit(r, db.AuditEvent{Type: audit.Register, ActorID: id, TargetID: id, Username: req.Username, Outcome: audit.Success})
+
+	// The user can ask for another link if this one does not arrive.
+	if err := api.sendVerificationEmail(id, req.Email); err != nil {
+		log.Error().Err(err).Msg("Error sending verification email")
+	}
+
 	w.WriteHeader(http.StatusCreated)
 }
 
 // @Summary Change Password
-// @Description Change a user's password
+// @Description Change a user's password. Users whose password expired call this with the token from the /login response.
+// @Description The new password must not be one of the last PASSWORD_HISTORY passwords of the user.
 // @Tags Authentication
 // @Produce json
 // @Param old_password body string true "Old Password"
 // @Param new_password body string true "New Password"
 // @Security BearerAuth
 // @Success 200 {object} EmptyResponse
-// @Failure 400 {object} ErrorResponse
+// @Failure 400 {object} PasswordPolicyErrorResponse "Invalid request, or a password violating the password policy or reused"
 // @Failure 401 {object} ErrorResponse
 // @Failure 404 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
 // @Failure 500 {object} ErrorResponse
 // @Router /change-password [put]
 func (api *API) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
@@ -274,48 +583,72 @@ func (api *API) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the user ID from the token.
-	tokenString := r.Header.Get("Authorization")
+	tokenString := bearerToken(r)
 	if tokenString == "" {
 		http.Error(w, "Authorization header is missing", http.StatusBadRequest)
 		return
 	}
-	userID, err := token.Validate(tokenString, api.cfg)
-	if err != nil {
+	// Users whose password expired only have a password change token.
+	var userID string
+	if claims, err := token.Validate(r.Context(), tokenString, "", api.keys, api.db, api.cfg); err == nil {
+		if claims.UserID == "" {
+			http.Error(w, "token was not issued to a user", http.StatusBadRequest)
+			return
+		}
+		userID = claims.UserID
+	} else if claims, err := token.ValidatePasswordChange(tokenString, api.keys, api.cfg); err == nil {
+		userID = claims.Subject
+	} else {
 		http.Error(w, "invalid token", http.StatusUnauthorized)
 		return
 	}
 
 	// Get the user's record from the database.
-	user, err := api.db.GetUserByID(userID)
+	user, err := api.db.GetUserByID(r.Context(), userID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Msg("Error fetching user")
 		http.Error(w, "Error fetching user", http.StatusInternalServerError)
 		return
 	}
-	if user == nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
 
 	// Check the old password against the stored hash.
-	if !crypt.CheckPasswordHash(data.OldPassword, user.Password) {
+	ok, _, err := api.passwords.Verify(data.OldPassword, user.Password)
+	if err != nil {
+		log.Error().Err(err).Msg("Error verifying password")
+		http.Error(w, "Error verifying password", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		api.audit(r, db.AuditEvent{Type: audit.PasswordChange, TargetID: userID, Username: user.Username, Outcome: audit.Failure, Reason: audit.ReasonInvalidCredentials})
 		http.Error(w, "invalid old password", http.StatusUnauthorized)
 		return
 	}
 
+	if !api.checkPasswordPolicy(w, data.NewPassword, user.Username, user.Email) {
+		return
+	}
+	if !api.checkPasswordHistory(r.Context(), w, user, data.NewPassword) {
+		return
+	}
+
 	// Hash the new password.
-	hashedPassword, err := crypt.HashPassword(data.NewPassword)
+	hashedPassword, err := api.passwords.Hash(data.NewPassword)
 	if err != nil {
 		http.Error(w, "Error hashing password", http.StatusInternalServerError)
 		return
 	}
 
 	// Update the user's password in the database.
-	if err := api.db.UpdatePassword(userID, string(hashedPassword)); err != nil {
+	if err := api.db.UpdatePassword(r.Context(), userID, string(hashedPassword), time.Now(), api.passwordHistoryKept()); err != nil {
 		log.Error().Err(err).Msg("Error updating password")
 		http.Error(w, "Error updating password", http.StatusInternalServerError)
 		return
 	}
+	api.audit(r, db.AuditEvent{Type: audit.PasswordChange, ActorID: userID, TargetID: userID, Username: user.Username, Outcome: audit.Success})
 
 	w.WriteHeader(http.StatusOK)
 }
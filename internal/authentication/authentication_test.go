@@ -0,0 +1,209 @@
+package authentication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/mail"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+// testPassword passes the default password policy.
+const testPassword = "correct-Horse-battery-9"
+
+// testAPI is an API keeping its data in memory, with the store and mailer at
+// hand for tests to look into.
+type testAPI struct {
+	*API
+	store  *db.Memory
+	mailer *mail.MemorySender
+}
+
+// newTestAPI returns an API with the default configuration, except for
+// cheap password hashes and no delay after failed logins. configure, if not
+// nil, can change the configuration further.
+func newTestAPI(t *testing.T, configure func(cfg *config.Config)) *testAPI {
+	t.Helper()
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DBBackend = "memory"
+	cfg.SMTPHost = ""
+	cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism = 64, 1, 1
+	cfg.LoginBackoff = 0
+	if configure != nil {
+		configure(cfg)
+	}
+
+	store := db.NewMemory()
+	keys, err := token.LoadKeyring(context.Background(), cfg, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailer := &mail.MemorySender{}
+	api, err := NewAPI(cfg, store, keys, mailer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAPI{API: api, store: store, mailer: mailer}
+}
+
+// createUser stores a user with password.
+func (a *testAPI) createUser(t *testing.T, username, email, password string) *db.User {
+	t.Helper()
+	ctx := context.Background()
+	id, err := a.store.NewUUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := a.passwords.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.store.InsertUser(ctx, id, username, email, string(hash)); err != nil {
+		t.Fatal(err)
+	}
+	user, err := a.store.GetUserByID(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// post calls handler with body encoded as JSON, from remoteAddr if it is not
+// empty, and returns the response.
+func post(t *testing.T, handler http.HandlerFunc, body interface{}, remoteAddr string) *httptest.ResponseRecorder {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+	r.Header.Set("Content-Type", "application/json")
+	if remoteAddr != "" {
+		r.RemoteAddr = remoteAddr
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decode decodes the JSON body of w into v, failing the test unless w has
+// status.
+func decode(t *testing.T, w *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("error decoding %s: %v", w.Body.String(), err)
+	}
+}
+
+// login logs username in with password and returns the tokens, failing the
+// test unless the login succeeds without a second factor.
+func (a *testAPI) login(t *testing.T, username, password string) *TokenResponse {
+	t.Helper()
+	var tokens TokenResponse
+	decode(t, post(t, a.LoginHandler, map[string]string{"username": username, "password": password}, ""), http.StatusOK, &tokens)
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("login returned %+v", tokens)
+	}
+	return &tokens
+}
+
+func TestLogin(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "", testPassword)
+
+	tokens := api.login(t, "jane", testPassword)
+	claims, err := token.Validate(context.Background(), tokens.Token, "", api.keys, api.store, api.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID {
+		t.Errorf("token issued to %q, want %q", claims.UserID, user.ID)
+	}
+
+	for _, body := range []map[string]string{
+		{"username": "jane", "password": "wrong"},
+		{"username": "joe", "password": testPassword},
+		{"username": "jane", "password": ""},
+	} {
+		if w := post(t, api.LoginHandler, body, ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("login with %v: status %d, want 401", body, w.Code)
+		}
+	}
+	if w := post(t, api.LoginHandler, map[string]string{"username": "jane", "password": testPassword, "audience": "elsewhere"}, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("login for an unknown audience: status %d, want 400", w.Code)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.createUser(t, "jane", "", testPassword)
+	tokens := api.login(t, "jane", testPassword)
+
+	var next TokenResponse
+	decode(t, post(t, api.RefreshHandler, map[string]string{"refresh_token": tokens.RefreshToken}, ""), http.StatusOK, &next)
+	if next.RefreshToken == "" || next.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh returned refresh token %q", next.RefreshToken)
+	}
+	if _, err := token.Validate(context.Background(), next.Token, "", api.keys, api.store, api.cfg); err != nil {
+		t.Fatalf("refreshed access token is invalid: %v", err)
+	}
+
+	var last TokenResponse
+	decode(t, post(t, api.RefreshHandler, map[string]string{"refresh_token": next.RefreshToken}, ""), http.StatusOK, &last)
+}
+
+func TestRefreshTokenReuseRevokesTheFamily(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "", testPassword)
+	first := api.login(t, "jane", testPassword)
+	other := api.login(t, "jane", testPassword)
+
+	var next TokenResponse
+	decode(t, post(t, api.RefreshHandler, map[string]string{"refresh_token": first.RefreshToken}, ""), http.StatusOK, &next)
+
+	// Whoever presents the used token again, the thief or the user, is
+	// refused, and so is the token that replaced it.
+	if w := post(t, api.RefreshHandler, map[string]string{"refresh_token": first.RefreshToken}, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("reused refresh token: status %d, want 401", w.Code)
+	}
+	if w := post(t, api.RefreshHandler, map[string]string{"refresh_token": next.RefreshToken}, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("refresh token of a revoked family: status %d, want 401", w.Code)
+	}
+
+	// Tokens of other logins are left alone.
+	decode(t, post(t, api.RefreshHandler, map[string]string{"refresh_token": other.RefreshToken}, ""), http.StatusOK, nil)
+
+	events, err := api.store.ListAuditEvents(context.Background(), db.AuditFilter{Type: audit.RefreshTokenReuse, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) == 0 || events[len(events)-1].TargetID != user.ID {
+		t.Errorf("reuse was not recorded for the user: %+v", events)
+	}
+}
+
+func TestRefreshRejectsInvalidTokens(t *testing.T) {
+	api := newTestAPI(t, nil)
+	if w := post(t, api.RefreshHandler, map[string]string{"refresh_token": ""}, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("empty refresh token: status %d, want 400", w.Code)
+	}
+	if w := post(t, api.RefreshHandler, map[string]string{"refresh_token": "unknown"}, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown refresh token: status %d, want 401", w.Code)
+	}
+}
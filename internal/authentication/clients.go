@@ -0,0 +1,282 @@
+package authentication
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+type ClientResponse struct {
+	ClientID                string    `json:"client_id"`
+	ClientSecret            string    `json:"client_secret,omitempty"`
+	Name                    string    `json:"name"`
+	RedirectURIs            []string  `json:"redirect_uris"`
+	Scopes                  []string  `json:"scopes"`
+	GrantTypes              []string  `json:"grant_types"`
+	Confidential            bool      `json:"confidential"`
+	TokenEndpointAuthMethod string    `json:"token_endpoint_auth_method"`
+	CreatedAt               time.Time `json:"created_at"`
+}
+
+// @Summary Register an OAuth client
+// @Description Register a client for the authorization code flow and/or the client credentials grant.
+// @Description Confidential clients are issued a client_secret, which is only returned in this response,
+// @Description unless they register a jwks to authenticate with private_key_jwt instead.
+// @Description Public clients, such as SPAs and native apps, get none and cannot use the client credentials grant.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name body string true "Client name, shown on the sign in form"
+// @Param redirect_uris body []string false "Allowed redirect URIs, required for the authorization_code grant"
+// @Param scopes body []string false "Scopes the client may request"
+// @Param grant_types body []string false "authorization_code and/or client_credentials, authorization_code by default"
+// @Param confidential body bool false "Whether the client can keep a secret"
+// @Param jwks body token.JWKS false "Public keys for private_key_jwt client authentication"
+// @Success 200 {object} ClientResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/clients [post]
+func (api *API) CreateClientHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name         string          `json:"name"`
+		RedirectURIs []string        `json:"redirect_uris"`
+		Scopes       []string        `json:"scopes"`
+		GrantTypes   []string        `json:"grant_types"`
+		Confidential bool            `json:"confidential"`
+		JWKS         json.RawMessage `json:"jwks"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if data.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(data.GrantTypes) == 0 {
+		data.GrantTypes = []string{grantAuthorizationCode}
+	}
+	for _, grantType := range data.GrantTypes {
+		if grantType != grantAuthorizationCode && grantType != grantClientCredentials {
+			http.Error(w, fmt.Sprintf("unsupported grant type %q", grantType), http.StatusBadRequest)
+			return
+		}
+	}
+	if contains(data.GrantTypes, grantAuthorizationCode) && len(data.RedirectURIs) == 0 {
+		http.Error(w, "at least one redirect URI is required", http.StatusBadRequest)
+		return
+	}
+
+	var jwks string
+	if len(data.JWKS) > 0 && string(data.JWKS) != "null" {
+		if _, err := token.ParseJWKS(data.JWKS); err != nil {
+			http.Error(w, fmt.Sprintf("invalid jwks: %v", err), http.StatusBadRequest)
+			return
+		}
+		jwks = string(data.JWKS)
+	}
+	if jwks != "" && data.Confidential {
+		http.Error(w, "clients with a jwks are not issued a secret", http.StatusBadRequest)
+		return
+	}
+	if contains(data.GrantTypes, grantClientCredentials) && !data.Confidential && jwks == "" {
+		http.Error(w, "the client_credentials grant requires a confidential client", http.StatusBadRequest)
+		return
+	}
+	for _, redirectURI := range data.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for _, scope := range data.Scopes {
+		// RFC 6749 scope tokens are printable ASCII without spaces, " or \.
+		if len(strings.Fields(scope)) != 1 || scope != strings.TrimSpace(scope) || strings.ContainsAny(scope, "\"\\") {
+			http.Error(w, fmt.Sprintf("invalid scope %q", scope), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, err := api.db.NewUUID()
+	if err != nil {
+		log.Error().Err(err).Msg("Error generating client ID")
+		http.Error(w, "Error registering client", http.StatusInternalServerError)
+		return
+	}
+
+	client := &db.Client{
+		ID:           id,
+		Name:         data.Name,
+		RedirectURIs: data.RedirectURIs,
+		Scopes:       data.Scopes,
+		GrantTypes:   data.GrantTypes,
+		JWKS:         jwks,
+		CreatedAt:    time.Now(),
+	}
+
+	var secret string
+	if data.Confidential {
+		secret, err = token.NewOpaque()
+		if err == nil {
+			client.SecretHash, err = api.passwords.Hash(secret)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Error generating client secret")
+			http.Error(w, "Error registering client", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := api.db.InsertClient(r.Context(), client); err != nil {
+		log.Error().Err(err).Msg("Error registering client")
+		http.Error(w, "Error registering client", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.ClientCreate, Outcome: audit.Success, Detail: client.ID})
+
+	response := newClientResponse(client)
+	response.ClientSecret = secret
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary List OAuth clients
+// @Description List every registered client. Secrets are never included.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} ClientResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/clients [get]
+func (api *API) ListClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := api.db.ListClients(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing clients")
+		http.Error(w, "Error listing clients", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]ClientResponse, 0, len(clients))
+	for _, client := range clients {
+		response = append(response, newClientResponse(&client))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Delete an OAuth client
+// @Description Delete a client. Its outstanding authorization codes are deleted with it and its refresh tokens can no longer be used.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Client ID"
+// @Success 200 {object} EmptyResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/clients/{id} [delete]
+func (api *API) DeleteClientHandler(w http.ResponseWriter, r *http.Request) {
+	client, err := api.db.GetClientByID(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching client")
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+
+	if err := api.db.DeleteClient(r.Context(), client.ID); err != nil {
+		log.Error().Err(err).Msg("Error deleting client")
+		http.Error(w, "Error deleting client", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.ClientDelete, Outcome: audit.Success, Detail: client.ID})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// validateRedirectURI only accepts absolute URIs without a fragment. Plain
+// http is limited to loopback addresses, for native apps.
+func validateRedirectURI(redirectURI string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return fmt.Errorf("redirect URI %q must be absolute", redirectURI)
+	}
+	if strings.Contains(redirectURI, "#") {
+		return fmt.Errorf("redirect URI %q must not contain a fragment", redirectURI)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !isLoopback(u.Hostname()) {
+			return fmt.Errorf("redirect URI %q must use https", redirectURI)
+		}
+	default:
+		return fmt.Errorf("redirect URI %q must use https", redirectURI)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func newClientResponse(client *db.Client) ClientResponse {
+	authMethod := "none"
+	switch {
+	case client.JWKS != "":
+		authMethod = "private_key_jwt"
+	case client.SecretHash != "":
+		authMethod = "client_secret_basic"
+	}
+
+	return ClientResponse{
+		ClientID:                client.ID,
+		Name:                    client.Name,
+		RedirectURIs:            append([]string{}, client.RedirectURIs...),
+		Scopes:                  append([]string{}, client.Scopes...),
+		GrantTypes:              append([]string{}, client.GrantTypes...),
+		Confidential:            authMethod != "none",
+		TokenEndpointAuthMethod: authMethod,
+		CreatedAt:               client.CreatedAt,
+	}
+}
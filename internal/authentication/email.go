@@ -0,0 +1,192 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/mail"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+const verificationEmailSubject = "Verify your email address"
+
+// @Summary Verify an email address
+// @Description Confirm the email address of a user with the token from the link sent at registration.
+// @Tags Authentication
+// @Produce json
+// @Param token query string true "Token from the verification link"
+// @Success 200 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /verify-email [get]
+func (api *API) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	claims, err := token.ValidateEmailVerification(r.URL.Query().Get("token"), api.keys, api.cfg)
+	if err != nil {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// The link is void once the user changed to another address.
+	verified, err := api.db.MarkEmailVerified(r.Context(), claims.Subject, claims.Email)
+	if err != nil {
+		log.Error().Err(err).Msg("Error verifying email address")
+		http.Error(w, "Error verifying email address", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.EmailVerify, ActorID: claims.Subject, TargetID: claims.Subject, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Resend the verification email
+// @Description Send a new verification link to an address that has not been verified yet.
+// @Description The response is the same whether or not the address is known.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param email body string true "Email address"
+// @Success 202 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} ErrorResponse
+// @Router /verify-email/resend [post]
+func (api *API) ResendVerificationEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := api.db.GetUserByEmail(r.Context(), strings.TrimSpace(data.Email))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return
+	}
+	if user != nil && user.EmailVerifiedAt == nil {
+		// Sending in the background keeps the response time from telling
+		// known addresses apart.
+		go func() {
+			if err := api.sendVerificationEmail(user.ID, user.Email); err != nil {
+				log.Error().Err(err).Msg("Error sending verification email")
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+}
+
+// sendVerificationEmail mails userID a link proving ownership of email. The
+// link is never built from the request, whose Host header the client picks;
+// without a configured URL the token itself is mailed.
+func (api *API) sendVerificationEmail(userID, email string) error {
+	verificationToken, err := token.NewEmailVerification(userID, email, api.keys, api.cfg)
+	if err != nil {
+		return err
+	}
+
+	instructions := "Use the token below to verify your email address:\n\n" + verificationToken
+	if link := api.verificationURL(); link != "" {
+		instructions = "Open the link below to verify your email address:\n\n" + linkWithToken(link, verificationToken)
+	}
+
+	return api.mailer.Send(&mail.Message{
+		To:      email,
+		Subject: verificationEmailSubject,
+		Body: fmt.Sprintf("%s\n\nIt expires in %s. If you did not create an account, you can ignore this message.\n",
+			instructions, api.cfg.EmailVerificationTTL),
+	})
+}
+
+// verificationURL is the page verification links point to: EMAIL_VERIFICATION_URL,
+// or /verify-email of the service if JWT_ISSUER is its URL. It is empty if
+// neither is configured.
+func (api *API) verificationURL() string {
+	if api.cfg.EmailVerificationURL != "" {
+		return api.cfg.EmailVerificationURL
+	}
+	if issuer := issuerURL(api.cfg); issuer != "" {
+		return issuer + "/verify-email"
+	}
+	return ""
+}
+
+// emailVerificationPending reports whether userID may not log in yet because
+// EMAIL_VERIFICATION_REQUIRED is set and the user's address is unverified.
+// Users without an address, who registered before addresses were collected,
+// have nothing to verify and no way to add one, so they are let through.
+func (api *API) emailVerificationPending(ctx context.Context, userID string) (bool, error) {
+	if !api.cfg.EmailVerificationRequired {
+		return false, nil
+	}
+	user, err := api.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return user.Email != "" && user.EmailVerifiedAt == nil, nil
+}
+
+// checkEmailVerified writes the error response for logins of users whose
+// address is still to be verified, and reports whether the login may go on.
+func (api *API) checkEmailVerified(ctx context.Context, w http.ResponseWriter, userID string) bool {
+	pending, err := api.emailVerificationPending(ctx, userID)
+	// The user may have been deleted since their credentials were checked.
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return false
+	}
+	if pending {
+		http.Error(w, "email address is not verified", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// linkWithToken adds tokenValue to link as its token query parameter.
+func linkWithToken(link, tokenValue string) string {
+	separator := "?"
+	if strings.Contains(link, "?") {
+		separator = "&"
+	}
+	return link + separator + "token=" + url.QueryEscape(tokenValue)
+}
+
+// validateEmail only accepts a bare address, without a display name.
+func validateEmail(email string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid email address %q", email)
+	}
+	return nil
+}
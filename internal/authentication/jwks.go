@@ -0,0 +1,26 @@
+package authentication
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// @Summary JSON Web Key Set
+// @Description Public keys that can be used to verify tokens issued by this service, selected by the kid header.
+// @Description Keys that were rotated out are listed until their overlap window ends.
+// @Description Symmetric (HS256) keys are never published.
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} token.JWKS
+// @Failure 500 {object} ErrorResponse
+// @Router /.well-known/jwks.json [get]
+func (api *API) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	jwks := api.keys.JWKS()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err := json.NewEncoder(w).Encode(jwks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
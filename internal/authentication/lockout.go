@@ -0,0 +1,215 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+)
+
+// maxBackoffShift keeps the backoff delay from overflowing.
+const maxBackoffShift = 30
+
+// LockoutResponse is the lockout state of a user. Locked is also set while
+// logins are held back after a few failures, before the user is locked out.
+type LockoutResponse struct {
+	Locked       bool       `json:"locked"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+	Failures     int        `json:"failures"`
+	LastFailedAt *time.Time `json:"last_failed_at,omitempty"`
+}
+
+// @Summary Get the lockout state of a user
+// @Description Show the failed logins counted for a user and whether logins are refused because of them.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} LockoutResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/lockout [get]
+func (api *API) LockoutHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.adminUser(r.Context(), w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	lf, err := api.db.GetLoginFailures(r.Context(), db.LoginScopeUser, user.Username)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("Error fetching failed logins")
+		http.Error(w, "Error fetching failed logins", http.StatusInternalServerError)
+		return
+	}
+
+	response := LockoutResponse{}
+	if lf != nil {
+		response.Failures = lf.Failures
+		response.LastFailedAt = &lf.LastFailedAt
+		if lf.LockedUntil != nil && lf.LockedUntil.After(time.Now()) {
+			response.Locked = true
+			response.LockedUntil = lf.LockedUntil
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Unlock a user
+// @Description Forget the failed logins of a user, lifting any lockout.
+// @Description Lockouts of the source addresses the logins came from are not lifted.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} EmptyResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/lockout [delete]
+func (api *API) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.adminUser(r.Context(), w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	if _, err := api.db.ClearLoginFailures(r.Context(), db.LoginScopeUser, user.Username); err != nil {
+		log.Error().Err(err).Msg("Error clearing failed logins")
+		http.Error(w, "Error clearing failed logins", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.UserUnlock, TargetID: user.ID, Username: user.Username, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// loginLockedUntil returns until when password logins for username from ip
+// are refused, or the zero time if they are allowed. Failures are counted by
+// username rather than user, so unknown usernames get locked just the same.
+func (api *API) loginLockedUntil(ctx context.Context, username, ip string) (time.Time, error) {
+	var until time.Time
+	now := time.Now()
+	for scope, subject := range map[string]string{db.LoginScopeUser: username, db.LoginScopeIP: ip} {
+		lf, err := api.db.GetLoginFailures(ctx, scope, subject)
+		if errors.Is(err, db.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		if lf != nil && lf.LockedUntil != nil && lf.LockedUntil.After(now) && lf.LockedUntil.After(until) {
+			until = *lf.LockedUntil
+		}
+	}
+	return until, nil
+}
+
+// loginFailed counts a failed password login for username from ip. Logins
+// that failed with an error other than an unknown username are not counted,
+// they are no guess.
+func (api *API) loginFailed(ctx context.Context, username, ip string, err error) {
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return
+	}
+	if err := api.recordLoginFailure(ctx, username, ip); err != nil {
+		log.Error().Err(err).Msg("Error recording failed login")
+	}
+}
+
+// recordLoginFailure counts a failed login for username from ip. Every
+// failure holds back further logins for the username, twice as long as the
+// one before, until LOGIN_MAX_FAILURES locks it out. Source addresses are
+// only locked out, after LOGIN_MAX_IP_FAILURES, as many users may share one.
+func (api *API) recordLoginFailure(ctx context.Context, username, ip string) error {
+	now := time.Now()
+	expiresAt := now.Add(api.cfg.LoginLockoutDuration)
+
+	failures, err := api.db.RecordLoginFailure(ctx, db.LoginScopeUser, username, now, expiresAt)
+	if err != nil {
+		return err
+	}
+	if delay := api.loginDelay(failures); delay > 0 {
+		if err := api.db.LockLogin(ctx, db.LoginScopeUser, username, now.Add(delay)); err != nil {
+			return err
+		}
+	}
+
+	failures, err = api.db.RecordLoginFailure(ctx, db.LoginScopeIP, ip, now, expiresAt)
+	if err != nil {
+		return err
+	}
+	if api.cfg.LoginMaxIPFailures > 0 && failures >= api.cfg.LoginMaxIPFailures {
+		return api.db.LockLogin(ctx, db.LoginScopeIP, ip, now.Add(api.cfg.LoginLockoutDuration))
+	}
+	return nil
+}
+
+// loginDelay is how long logins for a username are held back after failures
+// failed ones in a row.
+func (api *API) loginDelay(failures int) time.Duration {
+	if api.cfg.LoginMaxFailures > 0 && failures >= api.cfg.LoginMaxFailures {
+		return api.cfg.LoginLockoutDuration
+	}
+	if api.cfg.LoginBackoff <= 0 || failures < 1 {
+		return 0
+	}
+
+	shift := failures - 1
+	if shift > maxBackoffShift {
+		shift = maxBackoffShift
+	}
+	delay := api.cfg.LoginBackoff << uint(shift)
+	if delay <= 0 || delay > api.cfg.LoginLockoutDuration {
+		return api.cfg.LoginLockoutDuration
+	}
+	return delay
+}
+
+// clearLoginFailures forgets the failed logins for username after a
+// successful login.
+func (api *API) clearLoginFailures(ctx context.Context, username string) {
+	if _, err := api.db.ClearLoginFailures(ctx, db.LoginScopeUser, username); err != nil {
+		log.Error().Err(err).Msg("Error clearing failed logins")
+	}
+}
+
+// writeLoginLocked answers a login refused until until. The response is the
+// same for every username, whether it exists or not.
+func writeLoginLocked(w http.ResponseWriter, until time.Time) {
+	seconds := int64(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	http.Error(w, "too many failed login attempts, try again later", http.StatusTooManyRequests)
+}
+
+// clientIP is the address a request came from.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
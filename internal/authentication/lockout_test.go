@@ -0,0 +1,128 @@
+package authentication
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+)
+
+// loginStatus tries to log username in from remoteAddr and returns the status.
+func (a *testAPI) loginStatus(t *testing.T, username, password, remoteAddr string) int {
+	t.Helper()
+	return post(t, a.LoginHandler, map[string]string{"username": username, "password": password}, remoteAddr).Code
+}
+
+func TestLoginLockout(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.createUser(t, "jane", "", testPassword)
+	api.createUser(t, "joe", "", testPassword)
+
+	for i := 0; i < api.cfg.LoginMaxFailures; i++ {
+		if code := api.loginStatus(t, "jane", "wrong", "198.51.100.1:1234"); code != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: status %d, want 401", i+1, code)
+		}
+	}
+
+	// The right password no longer helps, from any address.
+	w := post(t, api.LoginHandler, map[string]string{"username": "jane", "password": testPassword}, "198.51.100.2:1234")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login after %d failures: status %d, want 429", api.cfg.LoginMaxFailures, w.Code)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 0 || retryAfter > int(api.cfg.LoginLockoutDuration.Seconds()) {
+		t.Errorf("Retry-After = %q, want at most %v", w.Header().Get("Retry-After"), api.cfg.LoginLockoutDuration)
+	}
+
+	// Other users are not affected.
+	api.login(t, "joe", testPassword)
+}
+
+func TestLoginLockoutOfUnknownUsernames(t *testing.T) {
+	api := newTestAPI(t, nil)
+	for i := 0; i < api.cfg.LoginMaxFailures; i++ {
+		api.loginStatus(t, "nobody", "wrong", "")
+	}
+	// Locked the same as an existing user, so that the response does not
+	// tell whether the username exists.
+	if code := api.loginStatus(t, "nobody", "wrong", ""); code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429", code)
+	}
+}
+
+func TestSuccessfulLoginClearsFailures(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.createUser(t, "jane", "", testPassword)
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < api.cfg.LoginMaxFailures-1; i++ {
+			api.loginStatus(t, "jane", "wrong", "")
+		}
+		api.login(t, "jane", testPassword)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) { cfg.LoginBackoff = time.Minute })
+	api.createUser(t, "jane", "", testPassword)
+
+	api.loginStatus(t, "jane", "wrong", "")
+	w := post(t, api.LoginHandler, map[string]string{"username": "jane", "password": testPassword}, "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("login right after a failure: status %d, want 429", w.Code)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Retry-After = %q, want 60", retryAfter)
+	}
+}
+
+func TestLoginLockoutByAddress(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) { cfg.LoginMaxIPFailures = 3 })
+	api.createUser(t, "jane", "", testPassword)
+
+	// One guess for each of many usernames is a guess all the same.
+	for _, username := range []string{"a", "b", "c"} {
+		api.loginStatus(t, username, "wrong", "198.51.100.1:1234")
+	}
+	if code := api.loginStatus(t, "jane", testPassword, "198.51.100.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("login from a locked address: status %d, want 429", code)
+	}
+	if code := api.loginStatus(t, "jane", testPassword, "198.51.100.2:1234"); code != http.StatusOK {
+		t.Errorf("login from another address: status %d, want 200", code)
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) {
+		cfg.LoginBackoff = time.Second
+		cfg.LoginMaxFailures = 5
+		cfg.LoginLockoutDuration = 15 * time.Minute
+	})
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{5, 15 * time.Minute},
+		{100, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := api.loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+
+	// Without a lockout the delay still stops growing at the lockout
+	// duration, and does not overflow.
+	api.cfg.LoginMaxFailures = 0
+	for _, failures := range []int{11, 40, 1000} {
+		if got := api.loginDelay(failures); got != 15*time.Minute {
+			t.Errorf("loginDelay(%d) without a lockout = %v, want 15m", failures, got)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+// @Summary Log out
+// @Description Revoke the access token from the Authorization header.
+// @Description If a refresh token is supplied, every refresh token issued from the same login is revoked as well.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param refresh_token body string false "Refresh token"
+// @Security BearerAuth
+// @Success 200 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /logout [post]
+func (api *API) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// The body is optional, a bare logout only revokes the access token.
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		http.Error(w, "Authorization header is missing", http.StatusBadRequest)
+		return
+	}
+	claims, err := token.Validate(r.Context(), tokenString, "", api.keys, api.db, api.cfg)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	expiresAt := time.Now().Add(api.cfg.TokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if err := api.db.RevokeToken(r.Context(), claims.ID, claims.UserID, expiresAt); err != nil {
+		log.Error().Err(err).Msg("Error revoking token")
+		http.Error(w, "Error revoking token", http.StatusInternalServerError)
+		return
+	}
+
+	if data.RefreshToken != "" {
+		stored, err := api.db.GetRefreshTokenByHash(r.Context(), token.HashOpaque(data.RefreshToken))
+		if err != nil && !errors.Is(err, db.ErrNotFound) {
+			log.Error().Err(err).Msg("Error fetching refresh token")
+			http.Error(w, "Error fetching refresh token", http.StatusInternalServerError)
+			return
+		}
+		if stored != nil && stored.UserID == claims.UserID {
+			if err := api.db.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID); err != nil {
+				log.Error().Err(err).Msg("Error revoking refresh token family")
+				http.Error(w, "Error revoking refresh token", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	api.audit(r, db.AuditEvent{Type: audit.Logout, ActorID: claims.Subject, TargetID: claims.UserID, ClientID: claims.ClientID, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Log out everywhere
+// @Description Revoke every access and refresh token issued to the user of the token in the Authorization header
+// @Tags Authentication
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /logout-all [post]
+func (api *API) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		http.Error(w, "Authorization header is missing", http.StatusBadRequest)
+		return
+	}
+	claims, err := token.Validate(r.Context(), tokenString, "", api.keys, api.db, api.cfg)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	if claims.UserID == "" {
+		http.Error(w, "token was not issued to a user", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.revokeAllSessions(r.Context(), claims.UserID); err != nil {
+		log.Error().Err(err).Msg("Error revoking tokens")
+		http.Error(w, "Error revoking tokens", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.LogoutAll, ActorID: claims.UserID, TargetID: claims.UserID, ClientID: claims.ClientID, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// revokeAllSessions invalidates every access and refresh token issued to
+// userID so far.
+func (api *API) revokeAllSessions(ctx context.Context, userID string) error {
+	now := time.Now()
+	return api.db.RevokeAllUserTokens(ctx, userID, now, now.Add(api.cfg.TokenTTL))
+}
@@ -0,0 +1,546 @@
+package authentication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+	"github.com/cvele/authentication-service/internal/totp"
+	"github.com/cvele/authentication-service/internal/webauthn"
+)
+
+const (
+	recoveryCodeCount = 10
+	// maxMFAAttempts is how many codes can be tried against one challenge.
+	maxMFAAttempts = 5
+)
+
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAChallengeResponse asks for a second factor. WebAuthn is set when the
+// user has passkeys, which can be used instead of a code.
+type MFAChallengeResponse struct {
+	MFARequired bool                     `json:"mfa_required"`
+	MFAToken    string                   `json:"mfa_token"`
+	ExpiresIn   int64                    `json:"expires_in"`
+	WebAuthn    *webauthn.RequestOptions `json:"webauthn,omitempty"`
+}
+
+// @Summary Start TOTP enrollment
+// @Description Generate a new TOTP secret for the current user. The otpauth_uri is meant to be shown as a QR code for an authenticator app.
+// @Description The secret only protects logins once it is confirmed with /mfa/totp/confirm.
+// @Tags MFA
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} TOTPEnrollmentResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /mfa/totp [post]
+func (api *API) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := api.tokenUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Error generating secret", http.StatusInternalServerError)
+		return
+	}
+	saved, err := api.db.SaveTOTPSecret(r.Context(), user.ID, secret)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving TOTP secret")
+		http.Error(w, "Error saving TOTP secret", http.StatusInternalServerError)
+		return
+	}
+	if !saved {
+		http.Error(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(TOTPEnrollmentResponse{
+		Secret: secret,
+		URI:    totp.URI(api.cfg.MFAIssuer, user.Username, secret),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Confirm TOTP enrollment
+// @Description Enable TOTP for the current user with a first code from the authenticator app.
+// @Description Returns one-time recovery codes, which are never shown again.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body string true "Code from the authenticator app"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /mfa/totp/confirm [post]
+func (api *API) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := api.userClaims(w, r)
+	if !ok {
+		return
+	}
+
+	mfa, err := api.db.GetTOTP(r.Context(), claims.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "TOTP enrollment has not been started", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching TOTP secret")
+		http.Error(w, "Error fetching TOTP secret", http.StatusInternalServerError)
+		return
+	}
+	if mfa.EnabledAt != nil {
+		http.Error(w, "TOTP is already enabled", http.StatusConflict)
+		return
+	}
+
+	step, ok := totp.Validate(mfa.Secret, strings.TrimSpace(data.Code), time.Now())
+	if !ok {
+		http.Error(w, "invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Error generating recovery codes", http.StatusInternalServerError)
+		return
+	}
+	if err := api.db.EnableTOTP(r.Context(), claims.UserID, step, hashes); err != nil {
+		log.Error().Err(err).Msg("Error enabling TOTP")
+		http.Error(w, "Error enabling TOTP", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.TOTPEnable, ActorID: claims.UserID, TargetID: claims.UserID, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Disable TOTP
+// @Description Turn off two-factor authentication for the current user and delete the recovery codes.
+// @Description The user has to authenticate again with their password and a code or recovery code.
+// @Tags MFA
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param password body string true "Current password"
+// @Param code body string true "Code from the authenticator app, or a recovery code"
+// @Success 200 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} ErrorResponse
+// @Router /mfa/totp/disable [post]
+func (api *API) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, user, ok := api.tokenUser(w, r)
+	if !ok {
+		return
+	}
+	mfa, ok := api.reauthenticate(w, r, user, data.Password, data.Code, audit.TOTPDisable)
+	if !ok {
+		return
+	}
+	if mfa == nil {
+		http.Error(w, "TOTP is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.db.DisableTOTP(r.Context(), user.ID); err != nil {
+		log.Error().Err(err).Msg("Error disabling TOTP")
+		http.Error(w, "Error disabling TOTP", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.TOTPDisable, ActorID: user.ID, TargetID: user.ID, Username: user.Username, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Complete a two-factor login
+// @Description Exchange the mfa_token returned by /login and a code from the authenticator app, or a recovery code, for tokens.
+// @Description Instead of a code, a passkey can sign the webauthn options returned by /login. A challenge can be attempted 5 times.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param mfa_token body string true "MFA token from /login"
+// @Param code body string false "Code from the authenticator app, or a recovery code"
+// @Param webauthn body webauthn.AssertionCredential false "Credential returned by navigator.credentials.get()"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} PasswordExpiredResponse "The password has expired and has to be changed with the returned token"
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} ErrorResponse
+// @Router /login/mfa [post]
+func (api *API) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		MFAToken string                        `json:"mfa_token"`
+		Code     string                        `json:"code"`
+		WebAuthn *webauthn.AssertionCredential `json:"webauthn"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash := token.HashOpaque(data.MFAToken)
+	challenge, err := api.db.AttemptMFAChallenge(r.Context(), hash)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching MFA challenge")
+		http.Error(w, "Error fetching MFA challenge", http.StatusInternalServerError)
+		return
+	}
+	if challenge == nil || challenge.Attempts > maxMFAAttempts || time.Now().After(challenge.ExpiresAt) {
+		http.Error(w, "invalid MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// The user may have been deleted since the first step.
+	user, err := api.db.GetUserByID(r.Context(), challenge.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "invalid MFA token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return
+	}
+	if user.DisabledAt != nil {
+		api.audit(r, db.AuditEvent{Type: audit.LoginMFA, TargetID: user.ID, Username: user.Username, Outcome: audit.Failure, Reason: audit.ReasonDisabled})
+		http.Error(w, accountDisabled, http.StatusForbidden)
+		return
+	}
+
+	verified, err := api.verifyMFAChallenge(r.Context(), challenge, data.Code, data.WebAuthn)
+	if err != nil {
+		log.Error().Err(err).Msg("Error verifying second factor")
+		http.Error(w, "Error verifying code", http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		// Wrong codes count towards the lockout like wrong passwords, or the
+		// password would allow guessing codes 5 at a time without end.
+		if err := api.recordLoginFailure(r.Context(), user.Username, clientIP(r)); err != nil {
+			log.Error().Err(err).Msg("Error recording failed login")
+		}
+		api.audit(r, db.AuditEvent{Type: audit.LoginMFA, TargetID: user.ID, Username: user.Username, Outcome: audit.Failure, Reason: audit.ReasonInvalidCode})
+		http.Error(w, "invalid code", http.StatusUnauthorized)
+		return
+	}
+	api.clearLoginFailures(r.Context(), user.Username)
+
+	if err := api.db.DeleteMFAChallenge(r.Context(), hash); err != nil {
+		log.Error().Err(err).Msg("Error deleting MFA challenge")
+		http.Error(w, "Error deleting MFA challenge", http.StatusInternalServerError)
+		return
+	}
+
+	if api.passwordExpired(user) {
+		api.audit(r, db.AuditEvent{Type: audit.LoginMFA, ActorID: user.ID, TargetID: user.ID, Username: user.Username, Outcome: audit.Success, Reason: audit.ReasonPasswordExpired})
+		api.writePasswordExpired(w, user.ID)
+		return
+	}
+
+	familyID, err := api.db.NewUUID()
+	if err != nil {
+		http.Error(w, "Error generating uuid", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := api.issueTokens(r.Context(), grant{userID: challenge.UserID, audience: challenge.Audience}, familyID, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Error issuing tokens")
+		http.Error(w, "Error issuing tokens", http.StatusInternalServerError)
+		return
+	}
+	tokens.PasswordBreached = user.PasswordBreachedAt != nil
+	api.audit(r, db.AuditEvent{Type: audit.LoginMFA, ActorID: user.ID, TargetID: user.ID, Username: user.Username, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// verifyMFAChallenge checks the second factor presented for challenge, a
+// passkey assertion if one is given and a code otherwise.
+func (api *API) verifyMFAChallenge(ctx context.Context, challenge *db.MFAChallenge, code string, assertion *webauthn.AssertionCredential) (bool, error) {
+	if assertion != nil {
+		userID, err := api.verifyWebAuthnAssertion(ctx, assertion, ceremonyMFA, challenge.UserID, false)
+		return userID != "", err
+	}
+
+	mfa, err := api.requiresMFA(ctx, challenge.UserID)
+	if err != nil || mfa == nil {
+		return false, err
+	}
+	return api.verifySecondFactor(ctx, mfa, code)
+}
+
+// requiresMFA returns the TOTP secret of userID if password logins of the
+// user need a second factor, or nil if the password is enough. Only TOTP
+// turns the second factor on: passkeys are a login of their own, in place of
+// the password, and not a second factor. A user with passkeys but no TOTP
+// still logs in with the password alone.
+func (api *API) requiresMFA(ctx context.Context, userID string) (*db.TOTP, error) {
+	mfa, err := api.db.GetTOTP(ctx, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil || mfa.EnabledAt == nil {
+		return nil, err
+	}
+	return mfa, nil
+}
+
+// verifyLoginSecondFactor checks code for a login of userID that is done in
+// a single step, as on the /authorize form. Users without TOTP have no
+// second factor, see requiresMFA, and any code, empty or not, is accepted
+// for them.
+func (api *API) verifyLoginSecondFactor(ctx context.Context, userID, code string) (bool, error) {
+	mfa, err := api.requiresMFA(ctx, userID)
+	if err != nil || mfa == nil {
+		return err == nil, err
+	}
+	return api.verifySecondFactor(ctx, mfa, code)
+}
+
+// writeMFAChallenge answers a login that needs a second factor with a
+// challenge to be completed at /login/mfa. Users with passkeys also get the
+// options to sign with one of them.
+func (api *API) writeMFAChallenge(ctx context.Context, w http.ResponseWriter, userID, audience string) {
+	credentials, err := api.db.ListWebAuthnCredentials(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching WebAuthn credentials")
+		http.Error(w, "Error fetching WebAuthn credentials", http.StatusInternalServerError)
+		return
+	}
+	var options *webauthn.RequestOptions
+	if len(credentials) > 0 {
+		challenge, err := api.newWebAuthnChallenge(ctx, ceremonyMFA, userID)
+		if err != nil {
+			log.Error().Err(err).Msg("Error storing WebAuthn challenge")
+			http.Error(w, "Error storing WebAuthn challenge", http.StatusInternalServerError)
+			return
+		}
+		options = api.requestOptions(challenge, credentials, "discouraged")
+	}
+
+	mfaToken, err := token.NewOpaque()
+	if err != nil {
+		http.Error(w, "Error generating MFA token", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	err = api.db.InsertMFAChallenge(ctx, &db.MFAChallenge{
+		TokenHash: token.HashOpaque(mfaToken),
+		UserID:    userID,
+		Audience:  audience,
+		CreatedAt: now,
+		ExpiresAt: now.Add(api.cfg.MFAChallengeTTL),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error storing MFA challenge")
+		http.Error(w, "Error storing MFA challenge", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		ExpiresIn:   int64(api.cfg.MFAChallengeTTL.Seconds()),
+		WebAuthn:    options,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing MFA challenge")
+	}
+}
+
+// reauthenticate checks that the user acting with an access token knows
+// their password and, if they enabled TOTP, a code or recovery code, so that
+// a stolen token alone cannot change how the account is secured. It writes
+// the error response and records a failed eventType itself when they do not.
+// The enabled TOTP secret of the user, if any, is returned.
+func (api *API) reauthenticate(w http.ResponseWriter, r *http.Request, user *db.User, password, code, eventType string) (*db.TOTP, bool) {
+	ok, _, err := api.passwords.Verify(password, user.Password)
+	if err != nil {
+		log.Error().Err(err).Msg("Error verifying password")
+		http.Error(w, "Error verifying password", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !ok {
+		api.audit(r, db.AuditEvent{Type: eventType, TargetID: user.ID, Username: user.Username, Outcome: audit.Failure, Reason: audit.ReasonInvalidCredentials})
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	mfa, err := api.requiresMFA(r.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching TOTP secret")
+		http.Error(w, "Error fetching TOTP secret", http.StatusInternalServerError)
+		return nil, false
+	}
+	if mfa == nil {
+		return nil, true
+	}
+
+	verified, err := api.verifySecondFactor(r.Context(), mfa, code)
+	if err != nil {
+		log.Error().Err(err).Msg("Error verifying second factor")
+		http.Error(w, "Error verifying code", http.StatusInternalServerError)
+		return nil, false
+	}
+	if !verified {
+		api.audit(r, db.AuditEvent{Type: eventType, TargetID: user.ID, Username: user.Username, Outcome: audit.Failure, Reason: audit.ReasonInvalidCode})
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return nil, false
+	}
+	return mfa, true
+}
+
+// verifySecondFactor checks code, either a current TOTP code or an unused
+// recovery code, for the user of mfa. Either is only accepted once.
+func (api *API) verifySecondFactor(ctx context.Context, mfa *db.TOTP, code string) (bool, error) {
+	code = strings.Join(strings.Fields(code), "")
+
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(mfa.Secret, code, time.Now())
+		if !ok || step <= mfa.LastUsedStep {
+			return false, nil
+		}
+		err := api.db.UseTOTPStep(ctx, mfa.UserID, step)
+		if errors.Is(err, db.ErrTOTPCodeReused) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	if code == "" {
+		return false, nil
+	}
+	return api.db.UseRecoveryCode(ctx, mfa.UserID, token.HashOpaque(normalizeRecoveryCode(code)))
+}
+
+// userClaims validates the bearer token of r, which must have been issued to
+// a user, writing the error response itself when that fails.
+func (api *API) userClaims(w http.ResponseWriter, r *http.Request) (*token.Claims, bool) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		http.Error(w, "Authorization header is missing", http.StatusBadRequest)
+		return nil, false
+	}
+	claims, err := token.Validate(r.Context(), tokenString, "", api.keys, api.db, api.cfg)
+	if err != nil {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	if claims.UserID == "" {
+		http.Error(w, "token was not issued to a user", http.StatusBadRequest)
+		return nil, false
+	}
+	return claims, true
+}
+
+// tokenUser is userClaims, also returning the user the token was issued to.
+// The user may have been deleted since the token was checked.
+func (api *API) tokenUser(w http.ResponseWriter, r *http.Request) (*token.Claims, *db.User, bool) {
+	claims, ok := api.userClaims(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+	user, err := api.db.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return claims, user, true
+}
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCodes generates a set of recovery codes, formatted for the user
+// as xxxx-xxxx-xxxx-xxxx, along with the hashes they are stored under.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, token.HashOpaque(code))
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
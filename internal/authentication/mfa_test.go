@@ -0,0 +1,219 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/totp"
+)
+
+// enableTOTP turns on TOTP for user and returns its secret and recovery
+// codes.
+func (a *testAPI) enableTOTP(t *testing.T, user *db.User) (string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.store.SaveTOTPSecret(ctx, user.ID, secret); err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As if it was confirmed with a code long ago.
+	if err := a.store.EnableTOTP(ctx, user.ID, totp.Step(time.Now())-10, hashes); err != nil {
+		t.Fatal(err)
+	}
+	return secret, codes
+}
+
+// waitForPeriodStart sleeps past the end of the current period if it ends
+// within the next few seconds, so that codes computed by a test still
+// belong to the period they were computed for when they are checked.
+func waitForPeriodStart() {
+	if left := totp.Period - time.Duration(time.Now().UnixNano())%totp.Period; left < 3*time.Second {
+		time.Sleep(left)
+	}
+}
+
+func code(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	c, err := totp.Code(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "", testPassword)
+	secret, recoveryCodes := api.enableTOTP(t, user)
+	ctx := context.Background()
+	waitForPeriodStart()
+
+	verify := func(code string) bool {
+		t.Helper()
+		mfa, err := api.requiresMFA(ctx, user.ID)
+		if err != nil || mfa == nil {
+			t.Fatalf("requiresMFA() = %v, %v", mfa, err)
+		}
+		ok, err := api.verifySecondFactor(ctx, mfa, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	// Codes from outside the window are refused.
+	if verify(code(t, secret, -2)) || verify(code(t, secret, 2)) {
+		t.Fatal("code from outside the window accepted")
+	}
+	// The previous period is within the window, and spaces are ignored.
+	previous := code(t, secret, -1)
+	if !verify(previous[:3] + " " + previous[3:]) {
+		t.Fatal("code of the previous period refused")
+	}
+	// Replays are refused, and so are codes of the same or an earlier
+	// period once a later one has been used.
+	if verify(previous) {
+		t.Fatal("code accepted twice")
+	}
+	current := code(t, secret, 0)
+	if !verify(current) {
+		t.Fatal("code of the current period refused")
+	}
+	if verify(current) || verify(previous) {
+		t.Fatal("code replayed")
+	}
+
+	// Recovery codes work once, with or without dashes and in any case.
+	if !verify(recoveryCodes[0]) {
+		t.Fatal("recovery code refused")
+	}
+	if verify(recoveryCodes[0]) {
+		t.Fatal("recovery code accepted twice")
+	}
+	if !verify(normalizeRecoveryCode(recoveryCodes[1])) {
+		t.Fatal("recovery code without dashes refused")
+	}
+	if verify("") || verify("000000-0000") {
+		t.Fatal("invalid code accepted")
+	}
+}
+
+func TestRequiresMFA(t *testing.T) {
+	api := newTestAPI(t, nil)
+	ctx := context.Background()
+	user := api.createUser(t, "jane", "", testPassword)
+
+	if mfa, err := api.requiresMFA(ctx, user.ID); err != nil || mfa != nil {
+		t.Fatalf("requiresMFA() without TOTP = %v, %v, want nil, nil", mfa, err)
+	}
+	// A secret that was never confirmed does not count.
+	if _, err := api.store.SaveTOTPSecret(ctx, user.ID, "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"); err != nil {
+		t.Fatal(err)
+	}
+	if mfa, err := api.requiresMFA(ctx, user.ID); err != nil || mfa != nil {
+		t.Fatalf("requiresMFA() before confirmation = %v, %v, want nil, nil", mfa, err)
+	}
+	if err := api.store.EnableTOTP(ctx, user.ID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mfa, err := api.requiresMFA(ctx, user.ID); err != nil || mfa == nil {
+		t.Fatalf("requiresMFA() after confirmation = %v, %v, want the secret", mfa, err)
+	}
+}
+
+// mfaToken logs username in with password and returns the MFA token,
+// failing the test unless a second factor is asked for.
+func (a *testAPI) mfaToken(t *testing.T, username, password string) string {
+	t.Helper()
+	var challenge MFAChallengeResponse
+	decode(t, post(t, a.LoginHandler, map[string]string{"username": username, "password": password}, ""), http.StatusAccepted, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("login returned %+v", challenge)
+	}
+	return challenge.MFAToken
+}
+
+func TestLoginMFA(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "", testPassword)
+	secret, recoveryCodes := api.enableTOTP(t, user)
+	waitForPeriodStart()
+
+	// The password alone gives no tokens, and neither does a wrong code.
+	mfaToken := api.mfaToken(t, "jane", testPassword)
+	if w := post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": "000000"}, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d, want 401", w.Code)
+	}
+	var tokens TokenResponse
+	decode(t, post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": code(t, secret, 0)}, ""), http.StatusOK, &tokens)
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("login returned %+v", tokens)
+	}
+
+	// The challenge is gone once completed.
+	if w := post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": recoveryCodes[0]}, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("completed challenge: status %d, want 401", w.Code)
+	}
+
+	// The code just used cannot log in again, a recovery code can.
+	mfaToken = api.mfaToken(t, "jane", testPassword)
+	if w := post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": code(t, secret, 0)}, ""); w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed code: status %d, want 401", w.Code)
+	}
+	decode(t, post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": recoveryCodes[0]}, ""), http.StatusOK, nil)
+}
+
+func TestLoginMFALimitsAttempts(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) { cfg.LoginMaxFailures = 0 })
+	user := api.createUser(t, "jane", "", testPassword)
+	_, recoveryCodes := api.enableTOTP(t, user)
+
+	mfaToken := api.mfaToken(t, "jane", testPassword)
+	for i := 0; i < maxMFAAttempts; i++ {
+		post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": "000000"}, "")
+	}
+	if w := post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": recoveryCodes[0]}, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("right code after %d attempts: status %d, want 401", maxMFAAttempts, w.Code)
+	}
+}
+
+func TestLoginMFAFailuresCountTowardsTheLockout(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "", testPassword)
+	api.enableTOTP(t, user)
+
+	// Each challenge allows a few codes, but new ones cannot be had without
+	// end to guess more.
+	for i := 0; i < api.cfg.LoginMaxFailures; i++ {
+		mfaToken := api.mfaToken(t, "jane", testPassword)
+		post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": "000000"}, "")
+	}
+	if status := api.loginStatus(t, "jane", testPassword, ""); status != http.StatusTooManyRequests {
+		t.Errorf("login after %d wrong codes: status %d, want 429", api.cfg.LoginMaxFailures, status)
+	}
+}
+
+func TestLoginMFARejectsDisabledUsers(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "", testPassword)
+	_, recoveryCodes := api.enableTOTP(t, user)
+
+	mfaToken := api.mfaToken(t, "jane", testPassword)
+	if _, err := api.store.DisableUser(context.Background(), user.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if w := post(t, api.LoginMFAHandler, map[string]string{"mfa_token": mfaToken, "code": recoveryCodes[0]}, ""); w.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403", w.Code)
+	}
+}
@@ -0,0 +1,725 @@
+package authentication
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+const codeChallengeMethodS256 = "S256"
+
+// csrfCookie holds the CSRF token of the /authorize sign in form, which the
+// form posts back. Another site can make a browser post the form, but cannot
+// read the cookie to include its token.
+const csrfCookie = "authorize_csrf"
+
+// Grant types accepted by /token.
+const (
+	grantAuthorizationCode = "authorization_code"
+	grantRefreshToken      = "refresh_token"
+	grantClientCredentials = "client_credentials"
+)
+
+var supportedGrantTypes = []string{grantAuthorizationCode, grantRefreshToken, grantClientCredentials}
+
+// OAuth error codes from RFC 6749.
+const (
+	oauthInvalidRequest          = "invalid_request"
+	oauthInvalidClient           = "invalid_client"
+	oauthInvalidGrant            = "invalid_grant"
+	oauthInvalidScope            = "invalid_scope"
+	oauthUnauthorizedClient      = "unauthorized_client"
+	oauthUnsupportedGrantType    = "unsupported_grant_type"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+	oauthServerError             = "server_error"
+)
+
+type OAuthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+type OAuthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// authorizationRequest holds the parameters of an /authorize request.
+type authorizationRequest struct {
+	Client       *db.Client
+	ResponseType string
+	RedirectURI  string
+	// RedirectURIGiven is set when the request named RedirectURI, rather
+	// than leaving it to the only one of the client.
+	RedirectURIGiven    bool
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	CSRFToken           string
+	Username            string
+	Error               string
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sign in</title>
+</head>
+<body>
+<h1>Sign in to {{.Client.Name}}</h1>
+{{if .Scope}}<p>{{.Client.Name}} is requesting access to: {{.Scope}}</p>{{end}}
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="/authorize">
+<input type="hidden" name="response_type" value="{{.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Client.ID}}">
+{{if .RedirectURIGiven}}<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">{{end}}
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password" required></label>
+<label>Authentication code, if enabled <input type="text" name="otp" autocomplete="one-time-code"></label>
+<button type="submit">Sign in</button>
+</form>
+</body>
+</html>
+`))
+
+// @Summary OAuth 2.0 authorization endpoint
+// @Description Start the authorization code flow. GET renders a sign in form, which is submitted back with POST.
+// @Description On success the user agent is redirected to redirect_uri with a single use code and the state.
+// @Description PKCE with the S256 method is required.
+// @Description Users with TOTP enabled have to fill in a code, passkeys are not asked for and do not make a code required.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce html
+// @Param response_type query string true "Must be code"
+// @Param client_id query string true "Client ID"
+// @Param redirect_uri query string false "Registered redirect URI, may be omitted if the client has only one"
+// @Param scope query string false "Space separated scopes, a subset of those registered for the client"
+// @Param state query string false "Opaque value returned to the client"
+// @Param code_challenge query string true "PKCE code challenge"
+// @Param code_challenge_method query string true "Must be S256"
+// @Param nonce query string false "OpenID Connect nonce, returned in the ID token"
+// @Param username formData string false "Username, when submitting the form"
+// @Param password formData string false "Password, when submitting the form"
+// @Param otp formData string false "TOTP or recovery code, when submitting the form for a user with two-factor authentication"
+// @Param csrf_token formData string false "CSRF token of the form, matching the authorize_csrf cookie set with it, when submitting the form"
+// @Success 200 {string} string "Sign in form"
+// @Success 302 {string} string "Redirect to redirect_uri"
+// @Failure 400 {string} string "Unknown client or redirect URI"
+// @Failure 403 {string} string "Sign in refused, or a form posted without a valid CSRF token, rendered again"
+// @Router /authorize [get]
+// @Router /authorize [post]
+func (api *API) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error decoding request", http.StatusBadRequest)
+		return
+	}
+
+	client, err := api.db.GetClientByID(r.Context(), r.Form.Get("client_id"))
+	// Without a trustworthy redirect URI errors can only be shown to the
+	// user, redirecting would turn the endpoint into an open redirector.
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching client")
+		http.Error(w, "Error fetching client", http.StatusInternalServerError)
+		return
+	}
+	redirectURI := r.Form.Get("redirect_uri")
+	redirectURIGiven := redirectURI != ""
+	if !redirectURIGiven && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	req := &authorizationRequest{
+		Client:              client,
+		ResponseType:        r.Form.Get("response_type"),
+		RedirectURI:         redirectURI,
+		RedirectURIGiven:    redirectURIGiven,
+		Scope:               strings.Join(strings.Fields(r.Form.Get("scope")), " "),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+
+	switch {
+	case !allowsGrant(client, grantAuthorizationCode):
+		redirectWithError(w, r, req, oauthUnauthorizedClient, "client may not use the authorization code flow")
+		return
+	case req.ResponseType != "code":
+		redirectWithError(w, r, req, oauthUnsupportedResponseType, "response_type must be code")
+		return
+	case req.CodeChallenge == "":
+		redirectWithError(w, r, req, oauthInvalidRequest, "code_challenge is required")
+		return
+	case req.CodeChallengeMethod != codeChallengeMethodS256:
+		redirectWithError(w, r, req, oauthInvalidRequest, "code_challenge_method must be S256")
+		return
+	case !allowedScope(client, req.Scope):
+		redirectWithError(w, r, req, oauthInvalidScope, "scope is not allowed for this client")
+		return
+	case hasScope(req.Scope, scopeOpenID) && api.keys.Active().Symmetric():
+		redirectWithError(w, r, req, oauthInvalidScope, "openid requires an asymmetric signing key")
+		return
+	}
+
+	if r.Method != http.MethodPost || !validCSRFToken(r) {
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			status = http.StatusForbidden
+			req.Error = "The sign in form expired, try again"
+		}
+		if req.CSRFToken, err = newCSRFToken(w, r); err != nil {
+			redirectWithError(w, r, req, oauthServerError, "")
+			return
+		}
+		renderAuthorizeForm(w, status, req)
+		return
+	}
+
+	req.CSRFToken = r.PostForm.Get("csrf_token")
+	req.Username = r.PostForm.Get("username")
+	ip := clientIP(r)
+	lockedUntil, err := api.loginLockedUntil(r.Context(), req.Username, ip)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching failed logins")
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+	if !lockedUntil.IsZero() {
+		api.audit(r, db.AuditEvent{Type: audit.OAuthAuthorize, ClientID: client.ID, Username: req.Username, Outcome: audit.Failure, Reason: audit.ReasonLocked})
+		req.Error = "Too many failed attempts, try again later"
+		renderAuthorizeForm(w, http.StatusTooManyRequests, req)
+		return
+	}
+
+	var userID string
+	user, err := api.AuthenticateUser(r.Context(), req.Username, r.PostForm.Get("password"))
+	authenticated := user != nil
+	if authenticated {
+		userID = user.ID
+		authenticated, err = api.verifyLoginSecondFactor(r.Context(), userID, r.PostForm.Get("otp"))
+		if err != nil {
+			log.Error().Err(err).Msg("Error verifying second factor")
+		}
+	}
+	if err != nil || !authenticated {
+		api.loginFailed(r.Context(), req.Username, ip, err)
+		event := db.AuditEvent{Type: audit.OAuthAuthorize, ClientID: client.ID, Username: req.Username, Outcome: audit.Failure, Reason: audit.ReasonInvalidCredentials}
+		if user != nil {
+			event.TargetID, event.Reason = user.ID, audit.ReasonInvalidCode
+		}
+		api.audit(r, event)
+		req.Error = "Invalid username, password or authentication code"
+		renderAuthorizeForm(w, http.StatusUnauthorized, req)
+		return
+	}
+	api.clearLoginFailures(r.Context(), req.Username)
+	if user.DisabledAt != nil {
+		api.audit(r, db.AuditEvent{Type: audit.OAuthAuthorize, TargetID: userID, ClientID: client.ID, Username: req.Username, Outcome: audit.Failure, Reason: audit.ReasonDisabled})
+		req.Error = "This account is disabled"
+		renderAuthorizeForm(w, http.StatusForbidden, req)
+		return
+	}
+	pending, err := api.emailVerificationPending(r.Context(), userID)
+	if errors.Is(err, db.ErrNotFound) {
+		req.Error = "Invalid username, password or authentication code"
+		renderAuthorizeForm(w, http.StatusUnauthorized, req)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+	if pending {
+		api.audit(r, db.AuditEvent{Type: audit.OAuthAuthorize, TargetID: userID, ClientID: client.ID, Username: req.Username, Outcome: audit.Failure, Reason: audit.ReasonEmailUnverified})
+		req.Error = "Verify your email address before signing in"
+		renderAuthorizeForm(w, http.StatusForbidden, req)
+		return
+	}
+	// The form has no way to change the password, /login hands out the
+	// token to change it with.
+	if api.passwordExpired(user) {
+		api.audit(r, db.AuditEvent{Type: audit.OAuthAuthorize, TargetID: userID, ClientID: client.ID, Username: req.Username, Outcome: audit.Failure, Reason: audit.ReasonPasswordExpired})
+		req.Error = "Your password has expired, change it before signing in"
+		renderAuthorizeForm(w, http.StatusForbidden, req)
+		return
+	}
+
+	code, err := token.NewOpaque()
+	if err != nil {
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+	now := time.Now()
+	err = api.db.InsertAuthorizationCode(r.Context(), &db.AuthorizationCode{
+		CodeHash:            token.HashOpaque(code),
+		ClientID:            client.ID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		RedirectURIGiven:    req.RedirectURIGiven,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            now,
+		CreatedAt:           now,
+		ExpiresAt:           now.Add(api.cfg.AuthorizationCodeTTL),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error storing authorization code")
+		redirectWithError(w, r, req, oauthServerError, "")
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.OAuthAuthorize, ActorID: userID, TargetID: userID, ClientID: client.ID, Username: req.Username, Outcome: audit.Success})
+
+	redirect(w, r, req, url.Values{"code": {code}})
+}
+
+// @Summary OAuth 2.0 token endpoint
+// @Description Exchange an authorization code (authorization_code grant) or a refresh token (refresh_token grant) for tokens.
+// @Description Codes granted the openid scope also return an OpenID Connect ID token.
+// @Description A code can be exchanged once, presenting it again revokes the refresh token issued for it.
+// @Description Confidential clients can obtain an access token on their own behalf with the client_credentials grant.
+// @Description Confidential clients authenticate with HTTP Basic authentication, client_secret or a private_key_jwt client_assertion,
+// @Description public clients only send client_id.
+// @Tags OAuth
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param grant_type formData string true "authorization_code, refresh_token or client_credentials"
+// @Param client_id formData string false "Client ID, unless sent with HTTP Basic authentication"
+// @Param client_secret formData string false "Client secret, unless sent with HTTP Basic authentication"
+// @Param client_assertion_type formData string false "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param client_assertion formData string false "JWT signed with a key of the client"
+// @Param scope formData string false "Space separated scopes for the client_credentials grant, all scopes of the client by default"
+// @Param code formData string false "Authorization code"
+// @Param redirect_uri formData string false "Redirect URI the code was issued for, required if the authorization request included it"
+// @Param code_verifier formData string false "PKCE code verifier"
+// @Param refresh_token formData string false "Refresh token"
+// @Success 200 {object} OAuthTokenResponse
+// @Failure 400 {object} OAuthErrorResponse
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /token [post]
+func (api *API) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "error decoding request body")
+		return
+	}
+
+	client, ok := api.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	grantType := r.PostForm.Get("grant_type")
+	if !contains(supportedGrantTypes, grantType) {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnsupportedGrantType, "")
+		return
+	}
+	if !allowsGrant(client, grantType) {
+		writeOAuthError(w, http.StatusBadRequest, oauthUnauthorizedClient, "grant type is not allowed for this client")
+		return
+	}
+
+	var response *OAuthTokenResponse
+	var err error
+	switch grantType {
+	case grantAuthorizationCode:
+		response, err = api.exchangeAuthorizationCode(r.Context(), client, r.PostForm)
+	case grantClientCredentials:
+		response, err = api.clientCredentials(client, r.PostForm)
+	case grantRefreshToken:
+		var tokens *TokenResponse
+		tokens, err = api.refresh(r, r.PostForm.Get("refresh_token"), client.ID)
+		if errors.Is(err, errInvalidRefreshToken) {
+			err = &oauthError{code: oauthInvalidGrant, description: "invalid refresh token"}
+		}
+		if err == nil {
+			response = newOAuthTokenResponse(tokens)
+		}
+	}
+
+	var oauthErr *oauthError
+	if errors.As(err, &oauthErr) {
+		// Refreshes are too frequent to record, but for reused tokens.
+		if grantType != grantRefreshToken {
+			api.audit(r, db.AuditEvent{Type: audit.OAuthToken, ClientID: client.ID, Outcome: audit.Failure, Reason: oauthErr.code, Detail: grantType})
+		}
+		writeOAuthError(w, http.StatusBadRequest, oauthErr.code, oauthErr.description)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error issuing tokens")
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+	if grantType != grantRefreshToken {
+		api.audit(r, db.AuditEvent{Type: audit.OAuthToken, ActorID: client.ID, ClientID: client.ID, Outcome: audit.Success, Detail: grantType})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func newOAuthTokenResponse(tokens *TokenResponse) *OAuthTokenResponse {
+	return &OAuthTokenResponse{
+		AccessToken:  tokens.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    tokens.ExpiresIn,
+		RefreshToken: tokens.RefreshToken,
+		Scope:        tokens.Scope,
+	}
+}
+
+// oauthError is an error that is reported to the client as is.
+type oauthError struct {
+	code        string
+	description string
+}
+
+func (e *oauthError) Error() string {
+	return e.code + ": " + e.description
+}
+
+// exchangeAuthorizationCode redeems the code in form for tokens, including an
+// ID token when the openid scope was granted.
+func (api *API) exchangeAuthorizationCode(ctx context.Context, client *db.Client, form url.Values) (*OAuthTokenResponse, error) {
+	familyID, err := api.db.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	code, err := api.db.ConsumeAuthorizationCode(ctx, token.HashOpaque(form.Get("code")), familyID)
+	if errors.Is(err, db.ErrAuthorizationCodeUsed) {
+		// A code presented twice may have been stolen, and whoever redeemed it
+		// first may not be the client, so the refresh tokens issued for it are
+		// revoked (RFC 6749, section 4.1.2).
+		log.Warn().Str("client_id", client.ID).Str("family_id", code.FamilyID).Msg("Authorization code reuse detected, revoking token family")
+		if code.FamilyID != "" {
+			if err := api.db.RevokeRefreshTokenFamily(ctx, code.FamilyID); err != nil {
+				log.Error().Err(err).Msg("Error revoking refresh token family")
+			}
+		}
+		return nil, &oauthError{code: oauthInvalidGrant, description: "invalid authorization code"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code == nil || code.ClientID != client.ID || time.Now().After(code.ExpiresAt) {
+		return nil, &oauthError{code: oauthInvalidGrant, description: "invalid authorization code"}
+	}
+	// The redirect_uri must be repeated if the authorization request named
+	// one (RFC 6749, section 4.1.3), and match the one used either way.
+	if redirectURI := form.Get("redirect_uri"); (code.RedirectURIGiven || redirectURI != "") && redirectURI != code.RedirectURI {
+		return nil, &oauthError{code: oauthInvalidGrant, description: "redirect_uri does not match the authorization request"}
+	}
+	if !verifyCodeChallenge(form.Get("code_verifier"), code.CodeChallenge) {
+		return nil, &oauthError{code: oauthInvalidGrant, description: "invalid code_verifier"}
+	}
+
+	tokens, err := api.issueTokens(ctx, grant{userID: code.UserID, clientID: client.ID, scope: code.Scope}, familyID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response := newOAuthTokenResponse(tokens)
+	if hasScope(code.Scope, scopeOpenID) {
+		response.IDToken, err = token.NewIDToken(code.UserID, client.ID, code.Nonce, code.AuthTime, tokens.Token, api.keys, api.cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// clientCredentials issues an access token to client itself. Without a user
+// there is nothing to refresh, so no refresh token is issued.
+func (api *API) clientCredentials(client *db.Client, form url.Values) (*OAuthTokenResponse, error) {
+	scope := strings.Join(strings.Fields(form.Get("scope")), " ")
+	if scope == "" {
+		scope = strings.Join(client.Scopes, " ")
+	}
+	for _, s := range strings.Fields(scope) {
+		if !contains(client.Scopes, s) {
+			return nil, &oauthError{code: oauthInvalidScope, description: "scope is not allowed for this client"}
+		}
+	}
+
+	accessToken, err := token.New(&token.Claims{ClientID: client.ID, Scope: scope}, "", api.keys, api.cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(api.cfg.TokenTTL.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// authenticateClient identifies the client making a /token request, writing
+// the error response itself when that fails. Confidential clients must prove
+// knowledge of their secret, or sign a client assertion (private_key_jwt).
+func (api *API) authenticateClient(w http.ResponseWriter, r *http.Request) (*db.Client, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// Credentials in the Authorization header are form encoded first.
+		var err error
+		if clientID, err = url.QueryUnescape(clientID); err == nil {
+			secret, err = url.QueryUnescape(secret)
+		}
+		if err != nil {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "malformed client credentials")
+			return nil, false
+		}
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+
+	assertion := r.PostForm.Get("client_assertion")
+	if assertion != "" {
+		if basic || secret != "" || r.PostForm.Get("client_assertion_type") != token.ClientAssertionType {
+			writeOAuthError(w, http.StatusBadRequest, oauthInvalidRequest, "unsupported client authentication")
+			return nil, false
+		}
+		if clientID == "" {
+			clientID, _ = token.AssertionIssuer(assertion)
+		}
+	}
+
+	client, err := api.db.GetClientByID(r.Context(), clientID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("Error fetching client")
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return nil, false
+	}
+
+	authenticated := client != nil
+	switch {
+	case !authenticated:
+	case assertion != "":
+		authenticated, err = api.verifyClientAssertion(r, client, assertion)
+		if err != nil {
+			log.Error().Err(err).Msg("Error verifying client assertion")
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+			return nil, false
+		}
+	case client.SecretHash != "":
+		authenticated, _, err = api.passwords.Verify(secret, client.SecretHash)
+		if err != nil {
+			log.Error().Err(err).Msg("Error verifying client secret")
+			writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+			return nil, false
+		}
+	default:
+		// Public clients send no credentials. Clients with keys must use them.
+		authenticated = secret == "" && client.JWKS == ""
+	}
+	if !authenticated {
+		api.audit(r, db.AuditEvent{Type: audit.OAuthToken, ClientID: clientID, Outcome: audit.Failure, Reason: audit.ReasonInvalidCredentials})
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidClient, "client authentication failed")
+		return nil, false
+	}
+	return client, true
+}
+
+// verifyClientAssertion checks a private_key_jwt assertion against the keys
+// registered for client and makes sure it is not replayed. The audience may
+// be either the token endpoint or the issuer.
+func (api *API) verifyClientAssertion(r *http.Request, client *db.Client, assertion string) (bool, error) {
+	if client.JWKS == "" {
+		return false, nil
+	}
+	keys, err := token.ParseJWKS([]byte(client.JWKS))
+	if err != nil {
+		return false, err
+	}
+
+	audiences := []string{api.baseURL(r) + "/token"}
+	if api.cfg.JWTIssuer != "" {
+		audiences = append(audiences, api.cfg.JWTIssuer)
+	}
+	claims, err := token.ValidateClientAssertion(assertion, client.ID, keys, audiences, api.cfg)
+	if err != nil {
+		log.Debug().Err(err).Str("client_id", client.ID).Msg("Invalid client assertion")
+		return false, nil
+	}
+
+	err = api.db.UseClientAssertion(r.Context(), client.ID, claims.ID, claims.ExpiresAt.Time)
+	if errors.Is(err, db.ErrClientAssertionReused) {
+		log.Warn().Str("client_id", client.ID).Msg("Client assertion reuse detected")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// allowsGrant reports whether client was registered for grantType. Refresh
+// tokens come with the authorization code grant, and only confidential clients
+// may act on their own behalf.
+func allowsGrant(client *db.Client, grantType string) bool {
+	switch grantType {
+	case grantRefreshToken:
+		grantType = grantAuthorizationCode
+	case grantClientCredentials:
+		if client.SecretHash == "" && client.JWKS == "" {
+			return false
+		}
+	}
+	return contains(client.GrantTypes, grantType)
+}
+
+// verifyCodeChallenge checks a PKCE code verifier against the S256 challenge
+// sent to /authorize.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// allowedScope reports whether every scope in the space separated scope was
+// registered for client or is one of the standard OpenID Connect scopes.
+func allowedScope(client *db.Client, scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !contains(client.Scopes, s) && !contains(oidcScopes, s) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// newCSRFToken returns a new CSRF token for the sign in form, setting it in
+// csrfCookie too.
+func newCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	csrfToken, err := token.NewOpaque()
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/authorize",
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+// validCSRFToken reports whether the sign in form posted in r carries the
+// CSRF token of its cookie.
+func validCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) == 1
+}
+
+func renderAuthorizeForm(w http.ResponseWriter, status int, req *authorizationRequest) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; form-action 'self'; frame-ancestors 'none'")
+	w.WriteHeader(status)
+	if err := authorizeTemplate.Execute(w, req); err != nil {
+		log.Error().Err(err).Msg("Error rendering authorization form")
+	}
+}
+
+// redirect sends the user agent back to the client with params and the state
+// of the authorization request.
+func redirect(w http.ResponseWriter, r *http.Request, req *authorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+func redirectWithError(w http.ResponseWriter, r *http.Request, req *authorizationRequest, code, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	redirect(w, r, req, params)
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(OAuthErrorResponse{Error: code, ErrorDescription: description})
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing OAuth error response")
+	}
+}
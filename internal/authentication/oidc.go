@@ -0,0 +1,179 @@
+package authentication
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+// Standard OpenID Connect scopes, every client may request them.
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+var oidcScopes = []string{scopeOpenID, scopeProfile, scopeEmail}
+
+type OpenIDConfiguration struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgs      []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// UserInfo holds the claims about a user released for the scopes of an
+// access token.
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+}
+
+// @Summary OpenID Connect discovery document
+// @Description Metadata describing this service as an OpenID Connect provider.
+// @Description Endpoint URLs are relative to JWT_ISSUER when it is a URL, and to the request otherwise.
+// @Tags OAuth
+// @Produce json
+// @Success 200 {object} OpenIDConfiguration
+// @Failure 404 {object} ErrorResponse "OpenID Connect is unavailable while tokens are signed with HS256"
+// @Failure 500 {object} ErrorResponse
+// @Router /.well-known/openid-configuration [get]
+func (api *API) OpenIDConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	if api.keys.Active().Symmetric() {
+		http.Error(w, token.ErrSymmetricIDToken.Error(), http.StatusNotFound)
+		return
+	}
+	base := api.baseURL(r)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err := json.NewEncoder(w).Encode(OpenIDConfiguration{
+		Issuer:                            api.cfg.JWTIssuer,
+		AuthorizationEndpoint:             base + "/authorize",
+		TokenEndpoint:                     base + "/token",
+		UserInfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		ScopesSupported:                   oidcScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               supportedGrantTypes,
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{api.keys.Active().Algorithm},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"},
+		TokenEndpointAuthSigningAlgs:      []string{token.RS256, token.ES256, token.EdDSA},
+		CodeChallengeMethodsSupported:     []string{codeChallengeMethodS256},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash", "preferred_username", "email", "email_verified"},
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary OpenID Connect userinfo endpoint
+// @Description Claims about the user an access token was issued to. The token must have been granted the openid scope.
+// @Description The profile scope releases preferred_username, the email scope email and email_verified.
+// @Tags OAuth
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} UserInfo
+// @Failure 401 {object} OAuthErrorResponse
+// @Failure 403 {object} OAuthErrorResponse
+// @Failure 500 {object} OAuthErrorResponse
+// @Router /userinfo [get]
+// @Router /userinfo [post]
+func (api *API) UserInfoHandler(w http.ResponseWriter, r *http.Request) {
+	tokenString := bearerToken(r)
+	if tokenString == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+		writeOAuthError(w, http.StatusUnauthorized, oauthInvalidRequest, "missing access token")
+		return
+	}
+
+	claims, err := token.Validate(r.Context(), tokenString, "", api.keys, api.db, api.cfg)
+	if err != nil || claims.UserID == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "")
+		return
+	}
+	if !hasScope(claims.Scope, scopeOpenID) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+		writeOAuthError(w, http.StatusForbidden, "insufficient_scope", "the openid scope is required")
+		return
+	}
+
+	user, err := api.db.GetUserByID(r.Context(), claims.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		writeOAuthError(w, http.StatusInternalServerError, oauthServerError, "")
+		return
+	}
+
+	info := UserInfo{Subject: user.ID}
+	if hasScope(claims.Scope, scopeProfile) {
+		info.PreferredUsername = user.Username
+	}
+	if hasScope(claims.Scope, scopeEmail) && user.Email != "" {
+		verified := user.EmailVerifiedAt != nil
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// baseURL is the URL the endpoints of the service are published under. It is
+// taken from JWT_ISSUER if that is a URL, as OpenID Connect expects.
+func (api *API) baseURL(r *http.Request) string {
+	if issuer := issuerURL(api.cfg); issuer != "" {
+		return issuer
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// issuerURL returns JWT_ISSUER without a trailing slash if it is a URL, and
+// an empty string otherwise.
+func issuerURL(cfg *config.Config) string {
+	if u, err := url.Parse(cfg.JWTIssuer); err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" {
+		return strings.TrimSuffix(cfg.JWTIssuer, "/")
+	}
+	return ""
+}
+
+// hasScope reports whether the space separated scopes include scope.
+func hasScope(scopes, scope string) bool {
+	return contains(strings.Fields(scopes), scope)
+}
@@ -0,0 +1,115 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/policy"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+// PasswordExpiredResponse answers logins with an expired password. Token can
+// only be used to change the password at /change-password.
+type PasswordExpiredResponse struct {
+	Error           string `json:"error"`
+	PasswordExpired bool   `json:"password_expired"`
+	Token           string `json:"token"`
+	ExpiresIn       int64  `json:"expires_in"`
+}
+
+// passwordExpired reports whether user has to change their password before
+// logging in, because an admin required it or it is older than
+// PASSWORD_MAX_AGE.
+func (api *API) passwordExpired(user *db.User) bool {
+	if user.PasswordChangeRequiredAt != nil {
+		return true
+	}
+	if api.cfg.PasswordMaxAge <= 0 || user.PasswordChangedAt == nil {
+		return false
+	}
+	return time.Since(*user.PasswordChangedAt) > api.cfg.PasswordMaxAge
+}
+
+// writePasswordExpired answers a login with an expired password with the
+// token userID can change it with.
+func (api *API) writePasswordExpired(w http.ResponseWriter, userID string) {
+	changeToken, err := token.NewPasswordChange(userID, api.keys, api.cfg)
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating password change token")
+		http.Error(w, "Error creating password change token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	err = json.NewEncoder(w).Encode(PasswordExpiredResponse{
+		Error:           "password expired",
+		PasswordExpired: true,
+		Token:           changeToken,
+		ExpiresIn:       int64(api.cfg.PasswordChangeTokenTTL.Seconds()),
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+}
+
+// passwordHistoryKept is how many previous password hashes are kept for
+// each user. The current password counts towards PASSWORD_HISTORY.
+func (api *API) passwordHistoryKept() int {
+	if api.cfg.PasswordHistory <= 1 {
+		return 0
+	}
+	return api.cfg.PasswordHistory - 1
+}
+
+// checkPasswordHistory writes the error response for new passwords that are
+// one of the last PASSWORD_HISTORY passwords of user, and reports whether
+// password may be used.
+func (api *API) checkPasswordHistory(ctx context.Context, w http.ResponseWriter, user *db.User, password string) bool {
+	if api.cfg.PasswordHistory <= 0 {
+		return true
+	}
+
+	history, err := api.db.GetPasswordHistory(ctx, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching password history")
+		http.Error(w, "Error fetching password history", http.StatusInternalServerError)
+		return false
+	}
+	if kept := api.passwordHistoryKept(); len(history) > kept {
+		history = history[:kept]
+	}
+
+	for _, hash := range append([]string{user.Password}, history...) {
+		reused, _, err := api.passwords.Verify(password, hash)
+		if err != nil {
+			// Hashes in formats no longer verified cannot be compared.
+			log.Warn().Err(err).Msg("Error verifying previous password")
+			continue
+		}
+		if !reused {
+			continue
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		err = json.NewEncoder(w).Encode(PasswordPolicyErrorResponse{
+			Error: "password does not meet the password policy",
+			Violations: []policy.Violation{{
+				Rule:    policy.RuleHistory,
+				Message: fmt.Sprintf("must not be one of your last %d passwords", api.cfg.PasswordHistory),
+			}},
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Error writing response")
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/mail"
+	"github.com/cvele/authentication-service/internal/token"
+)
+
+const passwordResetEmailSubject = "Reset your password"
+
+// @Summary Request a password reset
+// @Description Mail a single-use password reset token to the user with the given email address.
+// @Description The response is the same whether or not the address is known.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param email body string true "Email address"
+// @Success 202 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} ErrorResponse
+// @Router /password-reset/request [post]
+func (api *API) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := api.db.GetUserByEmail(r.Context(), strings.TrimSpace(data.Email))
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return
+	}
+	// Requests for unknown addresses are recorded too, so that recording
+	// takes the same time either way.
+	event := db.AuditEvent{Type: audit.PasswordResetRequest, Outcome: audit.Failure, Reason: audit.ReasonUnknownEmail}
+	if user != nil {
+		event = db.AuditEvent{Type: audit.PasswordResetRequest, TargetID: user.ID, Username: user.Username, Outcome: audit.Success}
+	}
+	api.audit(r, event)
+	if user != nil {
+		// The token is stored and sent in the background, so that neither
+		// the response nor its timing tells that the address belongs to a
+		// user. Failures are only logged for the same reason.
+		go func() {
+			msg, err := api.newPasswordResetMessage(context.Background(), user)
+			if err != nil {
+				log.Error().Err(err).Msg("Error creating password reset token")
+				return
+			}
+			if err := api.mailer.Send(msg); err != nil {
+				log.Error().Err(err).Msg("Error sending password reset email")
+			}
+		}()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+}
+
+// @Summary Reset a password
+// @Description Set a new password with a token from /password-reset/request. The token can be used once.
+// @Description Every session of the user is logged out.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param token body string true "Password reset token"
+// @Param new_password body string true "New password"
+// @Success 200 {object} EmptyResponse
+// @Failure 400 {object} PasswordPolicyErrorResponse "Invalid or expired token, or a password violating the password policy"
+// @Failure 403 {object} ErrorResponse "The account is disabled"
+// @Failure 500 {object} ErrorResponse
+// @Router /password-reset/confirm [post]
+func (api *API) ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tokenHash := token.HashOpaque(data.Token)
+	rt, err := api.db.GetPasswordResetToken(r.Context(), tokenHash)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Error().Err(err).Msg("Error fetching password reset token")
+		http.Error(w, "Error fetching password reset token", http.StatusInternalServerError)
+		return
+	}
+	if rt == nil || time.Now().After(rt.ExpiresAt) {
+		api.audit(r, db.AuditEvent{Type: audit.PasswordReset, Outcome: audit.Failure, Reason: audit.ReasonInvalidToken})
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	// Tokens are deleted along with their user, but one may be deleted in
+	// between.
+	user, err := api.db.GetUserByID(r.Context(), rt.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return
+	}
+	// A link sent before the user was disabled must not let them back in.
+	if user.DisabledAt != nil {
+		api.audit(r, db.AuditEvent{Type: audit.PasswordReset, TargetID: user.ID, Username: user.Username, Outcome: audit.Failure, Reason: audit.ReasonDisabled})
+		http.Error(w, accountDisabled, http.StatusForbidden)
+		return
+	}
+	// The token stays valid for another try with a better password.
+	if !api.checkPasswordPolicy(w, data.NewPassword, user.Username, user.Email) {
+		return
+	}
+	if !api.checkPasswordHistory(r.Context(), w, user, data.NewPassword) {
+		return
+	}
+
+	rt, err = api.db.ConsumePasswordResetToken(r.Context(), tokenHash)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching password reset token")
+		http.Error(w, "Error fetching password reset token", http.StatusInternalServerError)
+		return
+	}
+	if rt == nil {
+		http.Error(w, "invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := api.passwords.Hash(data.NewPassword)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := api.db.ResetPassword(r.Context(), rt.UserID, string(hashedPassword), time.Now(), api.passwordHistoryKept()); err != nil {
+		log.Error().Err(err).Msg("Error updating password")
+		http.Error(w, "Error updating password", http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever knew the old password must not stay logged in.
+	if err := api.revokeAllSessions(r.Context(), rt.UserID); err != nil {
+		log.Error().Err(err).Msg("Error revoking tokens")
+		http.Error(w, "Error revoking tokens", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.PasswordReset, ActorID: user.ID, TargetID: user.ID, Username: user.Username, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// newPasswordResetMessage stores a new reset token for user and returns the
+// email delivering it. The token is sent as a link to PASSWORD_RESET_URL
+// when that is set, and as is otherwise.
+func (api *API) newPasswordResetMessage(ctx context.Context, user *db.User) (*mail.Message, error) {
+	resetToken, err := token.NewOpaque()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = api.db.InsertPasswordResetToken(ctx, &db.PasswordResetToken{
+		TokenHash: token.HashOpaque(resetToken),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(api.cfg.PasswordResetTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	instructions := "Use the token below to choose a new password:\n\n" + resetToken
+	if api.cfg.PasswordResetURL != "" {
+		instructions = "Open the link below to choose a new password:\n\n" + linkWithToken(api.cfg.PasswordResetURL, resetToken)
+	}
+
+	return &mail.Message{
+		To:      user.Email,
+		Subject: passwordResetEmailSubject,
+		Body: fmt.Sprintf("%s\n\nIt expires in %s and can be used once. "+
+			"If you did not ask to reset your password, you can ignore this message.\n",
+			instructions, api.cfg.PasswordResetTTL),
+	}, nil
+}
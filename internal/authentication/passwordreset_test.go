@@ -0,0 +1,154 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/mail"
+)
+
+// requestPasswordReset asks for a password reset for email and returns the
+// token mailed for it.
+func (a *testAPI) requestPasswordReset(t *testing.T, email string) string {
+	t.Helper()
+	sent := len(a.mailer.Messages())
+	decode(t, post(t, a.RequestPasswordResetHandler, map[string]string{"email": email}, ""), http.StatusAccepted, nil)
+	msg := a.waitForMessage(t, sent)
+	if msg.To != email || msg.Subject != passwordResetEmailSubject {
+		t.Fatalf("sent %q to %q", msg.Subject, msg.To)
+	}
+	_, rest, ok := strings.Cut(msg.Body, "password:\n\n")
+	if !ok {
+		t.Fatalf("no token in %q", msg.Body)
+	}
+	resetToken, _, _ := strings.Cut(rest, "\n")
+	return resetToken
+}
+
+// waitForMessage waits for the message after the first sent ones, which the
+// service sends in the background.
+func (a *testAPI) waitForMessage(t *testing.T, sent int) mail.Message {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if messages := a.mailer.Messages(); len(messages) > sent {
+			return messages[sent]
+		}
+	}
+	t.Fatal("no message was sent")
+	return mail.Message{}
+}
+
+func TestPasswordReset(t *testing.T) {
+	api := newTestAPI(t, nil)
+	user := api.createUser(t, "jane", "jane@example.com", testPassword)
+	session := api.login(t, "jane", testPassword)
+
+	resetToken := api.requestPasswordReset(t, "jane@example.com")
+	newPassword := "another-Staple-battery-7"
+	decode(t, post(t, api.ConfirmPasswordResetHandler, map[string]string{"token": resetToken, "new_password": newPassword}, ""), http.StatusOK, nil)
+
+	if status := api.loginStatus(t, "jane", testPassword, ""); status != http.StatusUnauthorized {
+		t.Errorf("login with the old password: status %d, want 401", status)
+	}
+	api.login(t, "jane", newPassword)
+
+	// Whoever knew the old password is logged out.
+	if w := post(t, api.RefreshHandler, map[string]string{"refresh_token": session.RefreshToken}, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh token from before the reset: status %d, want 401", w.Code)
+	}
+
+	// The token works once.
+	if w := post(t, api.ConfirmPasswordResetHandler, map[string]string{"token": resetToken, "new_password": "yet-Another-battery-5"}, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("token used again: status %d, want 400", w.Code)
+	}
+
+	events, err := api.store.ListAuditEvents(context.Background(), db.AuditFilter{Type: audit.PasswordReset, Outcome: audit.Success, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].TargetID != user.ID {
+		t.Errorf("audit events %+v, want a reset of %s", events, user.ID)
+	}
+}
+
+func TestPasswordResetLink(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) { cfg.PasswordResetURL = "https://app.example.com/reset" })
+	api.createUser(t, "jane", "jane@example.com", testPassword)
+
+	decode(t, post(t, api.RequestPasswordResetHandler, map[string]string{"email": "jane@example.com"}, ""), http.StatusAccepted, nil)
+	msg := api.waitForMessage(t, 0)
+	if !strings.Contains(msg.Body, "https://app.example.com/reset?token=") {
+		t.Errorf("no link in %q", msg.Body)
+	}
+}
+
+func TestPasswordResetOfUnknownEmail(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.createUser(t, "jane", "jane@example.com", testPassword)
+
+	// Answered like a known address.
+	decode(t, post(t, api.RequestPasswordResetHandler, map[string]string{"email": "joe@example.com"}, ""), http.StatusAccepted, nil)
+
+	events, err := api.store.ListAuditEvents(context.Background(), db.AuditFilter{Type: audit.PasswordResetRequest, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Reason != audit.ReasonUnknownEmail {
+		t.Errorf("audit events %+v, want a request for an unknown address", events)
+	}
+	// Known addresses are still mailed after it, and nothing was sent
+	// before.
+	api.requestPasswordReset(t, "jane@example.com")
+	if messages := api.mailer.Messages(); len(messages) != 1 {
+		t.Errorf("%d messages sent, want 1", len(messages))
+	}
+}
+
+func TestPasswordResetKeepsTheTokenForABetterPassword(t *testing.T) {
+	api := newTestAPI(t, nil)
+	api.createUser(t, "jane", "jane@example.com", testPassword)
+	resetToken := api.requestPasswordReset(t, "jane@example.com")
+
+	if w := post(t, api.ConfirmPasswordResetHandler, map[string]string{"token": resetToken, "new_password": "short"}, ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("weak password: status %d, want 400", w.Code)
+	}
+	decode(t, post(t, api.ConfirmPasswordResetHandler, map[string]string{"token": resetToken, "new_password": "another-Staple-battery-7"}, ""), http.StatusOK, nil)
+}
+
+func TestPasswordResetRejectsInvalidTokens(t *testing.T) {
+	api := newTestAPI(t, func(cfg *config.Config) { cfg.PasswordResetTTL = time.Millisecond })
+	user := api.createUser(t, "jane", "jane@example.com", testPassword)
+	expired := api.requestPasswordReset(t, "jane@example.com")
+	time.Sleep(5 * time.Millisecond)
+
+	api.cfg.PasswordResetTTL = time.Hour
+	disabled := api.requestPasswordReset(t, "jane@example.com")
+	if _, err := api.store.DisableUser(context.Background(), user.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"unknown", "unknown", http.StatusBadRequest},
+		{"empty", "", http.StatusBadRequest},
+		{"expired", expired, http.StatusBadRequest},
+		{"disabled user", disabled, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(t, api.ConfirmPasswordResetHandler, map[string]string{"token": tt.token, "new_password": "another-Staple-battery-7"}, "")
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
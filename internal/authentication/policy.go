@@ -0,0 +1,42 @@
+package authentication
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/policy"
+)
+
+// PasswordPolicyErrorResponse lists the rules of the password policy a new
+// password violates.
+type PasswordPolicyErrorResponse struct {
+	Error      string             `json:"error"`
+	Violations []policy.Violation `json:"violations"`
+}
+
+// checkPasswordPolicy writes the error response for new passwords that
+// violate the password policy, and reports whether password may be used.
+func (api *API) checkPasswordPolicy(w http.ResponseWriter, password, username, email string) bool {
+	violations, err := api.policy.Check(password, username, email)
+	if err != nil {
+		log.Error().Err(err).Msg("Error checking password policy")
+		http.Error(w, "Error checking password policy", http.StatusInternalServerError)
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	err = json.NewEncoder(w).Encode(PasswordPolicyErrorResponse{
+		Error:      "password does not meet the password policy",
+		Violations: violations,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+	return false
+}
@@ -0,0 +1,448 @@
+package authentication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+)
+
+const (
+	defaultUserListLimit = 50
+	maxUserListLimit     = 500
+)
+
+// accountDisabled answers logins of users disabled by an admin.
+const accountDisabled = "account is disabled"
+
+type UserResponse struct {
+	ID                     string     `json:"id"`
+	Username               string     `json:"username"`
+	Email                  string     `json:"email,omitempty"`
+	EmailVerified          bool       `json:"email_verified"`
+	PasswordChangedAt      *time.Time `json:"password_changed_at,omitempty"`
+	PasswordBreached       bool       `json:"password_breached"`
+	PasswordChangeRequired bool       `json:"password_change_required"`
+	Disabled               bool       `json:"disabled"`
+	DisabledAt             *time.Time `json:"disabled_at,omitempty"`
+}
+
+// UserListResponse is a page of users. Next is passed as after to get the
+// following page, and is empty on the last one.
+type UserListResponse struct {
+	Users []UserResponse `json:"users"`
+	Next  string         `json:"next,omitempty"`
+}
+
+// @Summary List users
+// @Description List users ordered by username, a page at a time.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param q query string false "Part of the username or email address, ignoring case"
+// @Param disabled query bool false "Only list disabled, or enabled, users"
+// @Param after query string false "Username to list from, the next field of the previous page"
+// @Param limit query int false "Users per page, 50 by default and at most 500"
+// @Success 200 {object} UserListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users [get]
+func (api *API) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := db.UserFilter{
+		Query: query.Get("q"),
+		After: query.Get("after"),
+		Limit: defaultUserListLimit,
+	}
+	if s := query.Get("disabled"); s != "" {
+		disabled, err := strconv.ParseBool(s)
+		if err != nil {
+			http.Error(w, "invalid disabled", http.StatusBadRequest)
+			return
+		}
+		filter.Disabled = &disabled
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxUserListLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	// One more user than asked for tells whether there is another page.
+	filter.Limit++
+	users, err := api.db.ListUsers(r.Context(), filter)
+	if err != nil {
+		log.Error().Err(err).Msg("Error listing users")
+		http.Error(w, "Error listing users", http.StatusInternalServerError)
+		return
+	}
+
+	response := UserListResponse{Users: []UserResponse{}}
+	if len(users) == filter.Limit {
+		users = users[:len(users)-1]
+		response.Next = users[len(users)-1].Username
+	}
+	for _, user := range users {
+		response.Users = append(response.Users, newUserResponse(&user))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Get a user
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id} [get]
+func (api *API) GetUserHandler(w http.ResponseWriter, r *http.Request) {
+	api.writeAdminUser(w, r, mux.Vars(r)["id"])
+}
+
+// @Summary Get a user by username
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param username path string true "Username"
+// @Success 200 {object} UserResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/by-username/{username} [get]
+func (api *API) GetUserByUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := api.db.GetUserByUsername(r.Context(), mux.Vars(r)["username"])
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(newUserResponse(user))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Create a user
+// @Description Create a user with a password chosen by an admin. The password policy applies.
+// @Description Unless email_verified is set, a link to verify the address is sent to it.
+// @Description With password_change_required the user has to choose a new password when they first log in.
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param username body string true "Username"
+// @Param email body string false "Email address"
+// @Param password body string true "Password"
+// @Param email_verified body bool false "Whether the address is known to be the user's"
+// @Param password_change_required body bool false "Whether the user has to choose a new password"
+// @Success 201 {object} UserResponse
+// @Failure 400 {object} PasswordPolicyErrorResponse "Invalid request, or a password violating the password policy"
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users [post]
+func (api *API) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Username               string `json:"username"`
+		Email                  string `json:"email"`
+		Password               string `json:"password"`
+		EmailVerified          bool   `json:"email_verified"`
+		PasswordChangeRequired bool   `json:"password_change_required"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if data.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	data.Email = strings.TrimSpace(data.Email)
+	if data.Email != "" {
+		if err := validateEmail(data.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !api.checkPasswordPolicy(w, data.Password, data.Username, data.Email) {
+		return
+	}
+
+	hashedPassword, err := api.passwords.Hash(data.Password)
+	if err != nil {
+		http.Error(w, "Error hashing password", http.StatusInternalServerError)
+		return
+	}
+	id, err := api.db.NewUUID()
+	if err != nil {
+		http.Error(w, "Error generating uuid", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	user := &db.User{
+		ID:                id,
+		Username:          data.Username,
+		Email:             data.Email,
+		Password:          hashedPassword,
+		PasswordChangedAt: &now,
+	}
+	if data.EmailVerified && data.Email != "" {
+		user.EmailVerifiedAt = &now
+	}
+	if data.PasswordChangeRequired {
+		user.PasswordChangeRequiredAt = &now
+	}
+
+	inserted, err := api.db.ImportUser(r.Context(), user)
+	if err != nil {
+		log.Error().Err(err).Msg("Error creating user")
+		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		return
+	}
+	if !inserted {
+		http.Error(w, "Username or email address is already in use", http.StatusConflict)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.UserCreate, TargetID: user.ID, Username: user.Username, Outcome: audit.Success})
+
+	if data.Email != "" && user.EmailVerifiedAt == nil {
+		// The user can ask for another link if this one does not arrive.
+		if err := api.sendVerificationEmail(id, data.Email); err != nil {
+			log.Error().Err(err).Msg("Error sending verification email")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(newUserResponse(user))
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing response")
+	}
+}
+
+// @Summary Disable a user
+// @Description Keep a user from logging in, and log out every session of theirs.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/disabled [put]
+func (api *API) DisableUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := api.db.DisableUser(r.Context(), id, time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("Error disabling user")
+		http.Error(w, "Error disabling user", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	api.audit(r, db.AuditEvent{Type: audit.UserDisable, TargetID: id, Outcome: audit.Success})
+
+	if err := api.revokeAllSessions(r.Context(), id); err != nil {
+		log.Error().Err(err).Msg("Error revoking tokens")
+		http.Error(w, "Error revoking tokens", http.StatusInternalServerError)
+		return
+	}
+
+	api.writeAdminUser(w, r, id)
+}
+
+// @Summary Enable a user
+// @Description Let a disabled user log in again.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/disabled [delete]
+func (api *API) EnableUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := api.db.EnableUser(r.Context(), id)
+	if err != nil {
+		log.Error().Err(err).Msg("Error enabling user")
+		http.Error(w, "Error enabling user", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.UserEnable, TargetID: id, Outcome: audit.Success})
+
+	api.writeAdminUser(w, r, id)
+}
+
+// @Summary Force a password reset
+// @Description Require a user to choose a new password, and log out every session of theirs.
+// @Description Logins answer with a token to change the password with, as for expired passwords,
+// @Description and users with an email address are sent a password reset link as well.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} UserResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/password-reset [post]
+func (api *API) ForcePasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := api.adminUser(r.Context(), w, mux.Vars(r)["id"])
+	if !ok {
+		return
+	}
+
+	if _, err := api.db.RequirePasswordChange(r.Context(), user.ID, time.Now()); err != nil {
+		log.Error().Err(err).Msg("Error requiring password change")
+		http.Error(w, "Error requiring password change", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.UserForceReset, TargetID: user.ID, Username: user.Username, Outcome: audit.Success})
+	if err := api.revokeAllSessions(r.Context(), user.ID); err != nil {
+		log.Error().Err(err).Msg("Error revoking tokens")
+		http.Error(w, "Error revoking tokens", http.StatusInternalServerError)
+		return
+	}
+
+	if user.Email != "" {
+		// Failures are only logged, the user can still change the password
+		// at their next login or ask for another link.
+		if msg, err := api.newPasswordResetMessage(r.Context(), user); err != nil {
+			log.Error().Err(err).Msg("Error creating password reset token")
+		} else if err := api.mailer.Send(msg); err != nil {
+			log.Error().Err(err).Msg("Error sending password reset email")
+		}
+	}
+
+	api.writeAdminUser(w, r, user.ID)
+}
+
+// @Summary Delete a user
+// @Description Delete a user along with their sessions, roles and second factors.
+// @Description Access tokens already issued to them are rejected from then on.
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "User ID"
+// @Success 200 {object} EmptyResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id} [delete]
+func (api *API) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := api.db.DeleteUser(r.Context(), id)
+	if err != nil {
+		log.Error().Err(err).Msg("Error deleting user")
+		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.UserDelete, TargetID: id, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkUserEnabled writes the error response for logins of users disabled by
+// an admin, and reports whether the login may go on.
+func (api *API) checkUserEnabled(ctx context.Context, w http.ResponseWriter, userID string) bool {
+	user, err := api.db.GetUserByID(ctx, userID)
+	// The user may have been deleted since their credentials were checked.
+	if errors.Is(err, db.ErrNotFound) {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return false
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching user")
+		http.Error(w, "Error fetching user", http.StatusInternalServerError)
+		return false
+	}
+	if user.DisabledAt != nil {
+		http.Error(w, accountDisabled, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// writeAdminUser answers an admin request with user id as it is stored.
+func (api *API) writeAdminUser(w http.ResponseWriter, r *http.Request, id string) {
+	user, ok := api.adminUser(r.Context(), w, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(newUserResponse(user))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func newUserResponse(user *db.User) UserResponse {
+	return UserResponse{
+		ID:                     user.ID,
+		Username:               user.Username,
+		Email:                  user.Email,
+		EmailVerified:          user.EmailVerifiedAt != nil,
+		PasswordChangedAt:      user.PasswordChangedAt,
+		PasswordBreached:       user.PasswordBreachedAt != nil,
+		PasswordChangeRequired: user.PasswordChangeRequiredAt != nil,
+		Disabled:               user.DisabledAt != nil,
+		DisabledAt:             user.DisabledAt,
+	}
+}
@@ -0,0 +1,492 @@
+package authentication
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/cvele/authentication-service/internal/token"
+	"github.com/cvele/authentication-service/internal/webauthn"
+)
+
+// WebAuthn ceremonies a challenge can be issued for.
+const (
+	ceremonyRegistration = "registration"
+	ceremonyLogin        = "login"
+	ceremonyMFA          = "mfa"
+)
+
+const defaultCredentialName = "Passkey"
+
+type WebAuthnCredentialResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// @Summary Start passkey registration
+// @Description Return the options for navigator.credentials.create() to register a passkey or security key for the current user.
+// @Description A passkey logs the user in on its own, so they have to authenticate again with their password and,
+// @Description if TOTP is enabled, a code or recovery code.
+// @Tags WebAuthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param password body string true "Current password"
+// @Param code body string false "Code from the authenticator app, or a recovery code, if TOTP is enabled"
+// @Success 200 {object} webauthn.CreationOptions
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/register/begin [post]
+func (api *API) BeginWebAuthnRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, user, ok := api.tokenUser(w, r)
+	if !ok {
+		return
+	}
+	// The challenge issued below is what finishing the registration takes,
+	// so it is only handed out to whoever authenticated again.
+	if _, ok := api.reauthenticate(w, r, user, data.Password, data.Code, audit.PasskeyAdd); !ok {
+		return
+	}
+
+	credentials, err := api.db.ListWebAuthnCredentials(r.Context(), user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching WebAuthn credentials")
+		http.Error(w, "Error fetching WebAuthn credentials", http.StatusInternalServerError)
+		return
+	}
+
+	challenge, err := api.newWebAuthnChallenge(r.Context(), ceremonyRegistration, user.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error storing WebAuthn challenge")
+		http.Error(w, "Error storing WebAuthn challenge", http.StatusInternalServerError)
+		return
+	}
+
+	params := make([]webauthn.CredentialParameter, 0, len(webauthn.Algorithms))
+	for _, alg := range webauthn.Algorithms {
+		params = append(params, webauthn.CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(webauthn.CreationOptions{
+		Challenge: challenge,
+		RP:        webauthn.RelyingPartyEntity{ID: api.cfg.WebAuthnRPID, Name: api.cfg.WebAuthnRPName},
+		User: webauthn.UserEntity{
+			ID:          base64.RawURLEncoding.EncodeToString([]byte(user.ID)),
+			Name:        user.Username,
+			DisplayName: user.Username,
+		},
+		PubKeyCredParams:   params,
+		Timeout:            api.cfg.WebAuthnTimeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(credentials),
+		AuthenticatorSelection: webauthn.AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: "preferred",
+		},
+		Attestation: "none",
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Finish passkey registration
+// @Description Store the credential returned by navigator.credentials.create() for the current user.
+// @Tags WebAuthn
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param credential body webauthn.RegistrationCredential true "Credential returned by navigator.credentials.create()"
+// @Param name body string false "Name to tell the credential apart"
+// @Success 201 {object} WebAuthnCredentialResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/register/finish [post]
+func (api *API) FinishWebAuthnRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Name       string                          `json:"name"`
+		Credential webauthn.RegistrationCredential `json:"credential"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	claims, ok := api.userClaims(w, r)
+	if !ok {
+		return
+	}
+
+	challenge, ok := api.consumeWebAuthnChallenge(r.Context(), data.Credential.Response.ClientDataJSON, ceremonyRegistration)
+	if !ok || challenge.UserID != claims.UserID {
+		http.Error(w, "invalid or expired challenge", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := api.relyingParty().VerifyRegistration(&data.Credential, challenge.challenge, false)
+	if err != nil {
+		http.Error(w, "invalid credential", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(data.Name)
+	if name == "" {
+		name = defaultCredentialName
+	}
+	stored := &db.WebAuthnCredential{
+		ID:        credential.ID,
+		UserID:    claims.UserID,
+		Name:      name,
+		PublicKey: base64.RawURLEncoding.EncodeToString(credential.PublicKey),
+		SignCount: int64(credential.SignCount),
+		CreatedAt: time.Now(),
+	}
+	err = api.db.InsertWebAuthnCredential(r.Context(), stored)
+	if errors.Is(err, db.ErrWebAuthnCredentialExists) {
+		http.Error(w, "credential is already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Error storing WebAuthn credential")
+		http.Error(w, "Error storing WebAuthn credential", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.PasskeyAdd, ActorID: claims.UserID, TargetID: claims.UserID, ClientID: claims.ClientID,
+		Outcome: audit.Success, Detail: passkeyAuditDetail(stored.ID, claims)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(newWebAuthnCredentialResponse(stored))
+	if err != nil {
+		log.Error().Err(err).Msg("Error writing WebAuthn credential")
+	}
+}
+
+// @Summary Start a passkey login
+// @Description Return the options for navigator.credentials.get() to log in with a passkey, without a username or password.
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} webauthn.RequestOptions
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/login/begin [post]
+func (api *API) BeginWebAuthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	challenge, err := api.newWebAuthnChallenge(r.Context(), ceremonyLogin, "")
+	if err != nil {
+		log.Error().Err(err).Msg("Error storing WebAuthn challenge")
+		http.Error(w, "Error storing WebAuthn challenge", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(api.requestOptions(challenge, nil, "required"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Finish a passkey login
+// @Description Exchange the credential returned by navigator.credentials.get() for tokens.
+// @Description The authenticator has to verify the user, so no second factor is asked for.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param credential body webauthn.AssertionCredential true "Credential returned by navigator.credentials.get()"
+// @Param audience body string false "Audience the token is intended for, one of JWT_AUDIENCES"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse "The email address has to be verified first"
+// @Failure 429 {object} ErrorResponse "Too many requests, retry after the Retry-After header"
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/login/finish [post]
+func (api *API) FinishWebAuthnLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		Credential webauthn.AssertionCredential `json:"credential"`
+		Audience   string                       `json:"audience"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !token.AllowedAudience(data.Audience, api.cfg) {
+		http.Error(w, "unknown audience", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := api.verifyWebAuthnAssertion(r.Context(), &data.Credential, ceremonyLogin, "", true)
+	if err != nil {
+		log.Error().Err(err).Msg("Error verifying WebAuthn assertion")
+		http.Error(w, "Error verifying credential", http.StatusInternalServerError)
+		return
+	}
+	if userID == "" {
+		api.audit(r, db.AuditEvent{Type: audit.LoginPasskey, Outcome: audit.Failure, Reason: audit.ReasonInvalidCredentials})
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if !api.checkUserEnabled(r.Context(), w, userID) {
+		api.audit(r, db.AuditEvent{Type: audit.LoginPasskey, TargetID: userID, Outcome: audit.Failure, Reason: audit.ReasonDisabled})
+		return
+	}
+	if !api.checkEmailVerified(r.Context(), w, userID) {
+		api.audit(r, db.AuditEvent{Type: audit.LoginPasskey, TargetID: userID, Outcome: audit.Failure, Reason: audit.ReasonEmailUnverified})
+		return
+	}
+
+	familyID, err := api.db.NewUUID()
+	if err != nil {
+		http.Error(w, "Error generating uuid", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := api.issueTokens(r.Context(), grant{userID: userID, audience: data.Audience}, familyID, nil)
+	if err != nil {
+		log.Error().Err(err).Msg("Error issuing tokens")
+		http.Error(w, "Error issuing tokens", http.StatusInternalServerError)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.LoginPasskey, ActorID: userID, TargetID: userID, Outcome: audit.Success})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(tokens)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary List passkeys
+// @Description List the passkeys and security keys registered by the current user.
+// @Tags WebAuthn
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} WebAuthnCredentialResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/credentials [get]
+func (api *API) ListWebAuthnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := api.userClaims(w, r)
+	if !ok {
+		return
+	}
+
+	credentials, err := api.db.ListWebAuthnCredentials(r.Context(), claims.UserID)
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching WebAuthn credentials")
+		http.Error(w, "Error fetching WebAuthn credentials", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]WebAuthnCredentialResponse, 0, len(credentials))
+	for i := range credentials {
+		response = append(response, newWebAuthnCredentialResponse(&credentials[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Delete a passkey
+// @Description Remove a passkey or security key of the current user.
+// @Tags WebAuthn
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "Credential ID"
+// @Success 200 {object} EmptyResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webauthn/credentials/{id} [delete]
+func (api *API) DeleteWebAuthnCredentialHandler(w http.ResponseWriter, r *http.Request) {
+	claims, ok := api.userClaims(w, r)
+	if !ok {
+		return
+	}
+
+	deleted, err := api.db.DeleteWebAuthnCredential(r.Context(), claims.UserID, mux.Vars(r)["id"])
+	if err != nil {
+		log.Error().Err(err).Msg("Error deleting WebAuthn credential")
+		http.Error(w, "Error deleting WebAuthn credential", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+	api.audit(r, db.AuditEvent{Type: audit.PasskeyRemove, ActorID: claims.UserID, TargetID: claims.UserID, ClientID: claims.ClientID,
+		Outcome: audit.Success, Detail: passkeyAuditDetail(mux.Vars(r)["id"], claims)})
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(EmptyResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (api *API) relyingParty() *webauthn.RelyingParty {
+	return &webauthn.RelyingParty{
+		ID:      api.cfg.WebAuthnRPID,
+		Name:    api.cfg.WebAuthnRPName,
+		Origins: api.cfg.WebAuthnOrigins,
+	}
+}
+
+// newWebAuthnChallenge starts a ceremony for userID, which is empty when the
+// user is not known yet, and returns the challenge to sign.
+func (api *API) newWebAuthnChallenge(ctx context.Context, ceremony, userID string) (string, error) {
+	challenge, err := token.NewOpaque()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = api.db.InsertWebAuthnChallenge(ctx, &db.WebAuthnChallenge{
+		ChallengeHash: token.HashOpaque(challenge),
+		Ceremony:      ceremony,
+		UserID:        userID,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(api.cfg.WebAuthnTimeout),
+	})
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+type webAuthnChallenge struct {
+	*db.WebAuthnChallenge
+	challenge string
+}
+
+// consumeWebAuthnChallenge looks up the challenge a response was made for
+// and ends its ceremony, so a response can be verified only once. It reports
+// false if the challenge is unknown, expired or was issued for another
+// ceremony.
+func (api *API) consumeWebAuthnChallenge(ctx context.Context, clientDataJSON, ceremony string) (*webAuthnChallenge, bool) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil || challenge == "" {
+		return nil, false
+	}
+
+	stored, err := api.db.ConsumeWebAuthnChallenge(ctx, token.HashOpaque(challenge))
+	if err != nil {
+		log.Error().Err(err).Msg("Error fetching WebAuthn challenge")
+		return nil, false
+	}
+	if stored == nil || stored.Ceremony != ceremony || time.Now().After(stored.ExpiresAt) {
+		return nil, false
+	}
+	return &webAuthnChallenge{WebAuthnChallenge: stored, challenge: challenge}, true
+}
+
+// verifyWebAuthnAssertion verifies an assertion made for a ceremony, which
+// must be limited to the credentials of userID if it is set, and records the
+// new signature counter. It returns the user the credential belongs to, or an
+// empty string if the assertion does not verify.
+func (api *API) verifyWebAuthnAssertion(ctx context.Context, cred *webauthn.AssertionCredential, ceremony, userID string, requireUV bool) (string, error) {
+	challenge, ok := api.consumeWebAuthnChallenge(ctx, cred.Response.ClientDataJSON, ceremony)
+	if !ok || challenge.UserID != userID {
+		return "", nil
+	}
+
+	credential, err := api.db.GetWebAuthnCredential(ctx, cred.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if userID != "" && credential.UserID != userID {
+		return "", nil
+	}
+
+	publicKey, err := base64.RawURLEncoding.DecodeString(credential.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	signCount, err := api.relyingParty().VerifyAssertion(cred, challenge.challenge, publicKey, requireUV)
+	if err != nil {
+		return "", nil
+	}
+
+	// Authenticators that keep a counter increase it on every use, so a
+	// counter that does not move forward points to a cloned authenticator.
+	if (signCount != 0 || credential.SignCount != 0) && int64(signCount) <= credential.SignCount {
+		log.Warn().Str("credential_id", credential.ID).Msg("WebAuthn signature counter did not increase")
+		return "", nil
+	}
+	updated, err := api.db.UpdateWebAuthnSignCount(ctx, credential.ID, credential.SignCount, int64(signCount))
+	if err != nil || !updated {
+		return "", err
+	}
+	return credential.UserID, nil
+}
+
+// requestOptions returns the options to sign challenge with one of
+// credentials, or with any discoverable credential if there are none.
+func (api *API) requestOptions(challenge string, credentials []db.WebAuthnCredential, userVerification string) *webauthn.RequestOptions {
+	return &webauthn.RequestOptions{
+		Challenge:        challenge,
+		Timeout:          api.cfg.WebAuthnTimeout.Milliseconds(),
+		RPID:             api.cfg.WebAuthnRPID,
+		AllowCredentials: credentialDescriptors(credentials),
+		UserVerification: userVerification,
+	}
+}
+
+func credentialDescriptors(credentials []db.WebAuthnCredential) []webauthn.CredentialDescriptor {
+	descriptors := make([]webauthn.CredentialDescriptor, 0, len(credentials))
+	for _, credential := range credentials {
+		descriptors = append(descriptors, webauthn.CredentialDescriptor{Type: "public-key", ID: credential.ID})
+	}
+	return descriptors
+}
+
+// passkeyAuditDetail names the credential a passkey event is about and the
+// access token, by its jti, of the session that added or removed it.
+func passkeyAuditDetail(credentialID string, claims *token.Claims) string {
+	return "credential " + credentialID + ", token " + claims.ID
+}
+
+func newWebAuthnCredentialResponse(credential *db.WebAuthnCredential) WebAuthnCredentialResponse {
+	return WebAuthnCredentialResponse{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		CreatedAt:  credential.CreatedAt,
+		LastUsedAt: credential.LastUsedAt,
+	}
+}
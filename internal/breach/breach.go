@@ -0,0 +1,112 @@
+// Package breach tells whether passwords appear in breach corpora, from a
+// local copy of the Pwned Passwords dataset or a bloom filter built from it,
+// so that no external service is called when a password is checked.
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// PrefixLength is the length of the hash prefixes the dataset is split
+// into, in hex digits.
+const PrefixLength = 5
+
+// Checker reports whether a password appears in a breach corpus.
+type Checker interface {
+	Contains(password string) (bool, error)
+}
+
+// Open returns the checker for the dataset at path: a directory of range
+// files, or a filter file built by breach-filter. minCount only applies to
+// range files, filters are built with theirs.
+func Open(path string, minCount int) (Checker, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &RangeDir{Dir: path, MinCount: minCount}, nil
+	}
+	return LoadFilter(path)
+}
+
+// Hash returns the upper case hex SHA-1 hash of password, as the dataset
+// lists them.
+func Hash(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// RangeDir looks passwords up in a directory of range files, as downloaded
+// by the Pwned Passwords downloader: a file per hash prefix, named by the
+// prefix with an optional .txt extension, listing the suffixes of the
+// hashes with that prefix and how often each was seen, as SUFFIX:COUNT.
+type RangeDir struct {
+	Dir      string
+	MinCount int
+}
+
+func (d *RangeDir) Contains(password string) (bool, error) {
+	hash := Hash(password)
+	f, err := d.open(hash[:PrefixLength])
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	found := false
+	err = ReadRange(f, func(suffix string, count int) bool {
+		found = suffix == hash[PrefixLength:] && count >= d.MinCount
+		return !found
+	})
+	return found, err
+}
+
+func (d *RangeDir) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return os.Open(filepath.Join(d.Dir, prefix))
+	}
+	return f, err
+}
+
+// ReadRange calls fn with each SUFFIX:COUNT line of a range file, until fn
+// returns false.
+func ReadRange(r io.Reader, fn func(suffix string, count int) bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		suffix, count, err := parseLine(line)
+		if err != nil {
+			return err
+		}
+		if !fn(suffix, count) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func parseLine(line string) (string, int, error) {
+	hash, countText, ok := strings.Cut(line, ":")
+	count, err := strconv.Atoi(countText)
+	if !ok || err != nil {
+		return "", 0, fmt.Errorf("invalid line %q, expected HASH:COUNT", line)
+	}
+	return strings.ToUpper(hash), count, nil
+}
@@ -0,0 +1,149 @@
+package breach
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// filterMagic starts every filter file.
+var filterMagic = [8]byte{'P', 'W', 'B', 'L', 'O', 'O', 'M', 1}
+
+// filterHeaderSize is the size of the header of filter files: the magic,
+// the number of bits and the number of hash functions.
+const filterHeaderSize = 8 + 8 + 4
+
+var errInvalidFilter = errors.New("invalid breach filter file")
+
+// Filter is a bloom filter of SHA-1 hashes of breached passwords. It never
+// misses a password it was built with, and holds other passwords breached at
+// its false positive rate. The hashes are uniform already, so the bits of a
+// password are chosen by double hashing the first 16 bytes of its hash.
+type Filter struct {
+	bits   []uint64
+	m      uint64
+	hashes uint32
+}
+
+// NewFilter returns an empty filter sized for n hashes at the false
+// positive rate p.
+func NewFilter(n uint64, p float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	hashes := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, hashes: hashes}
+}
+
+// LoadFilter reads a filter file written by WriteTo.
+func LoadFilter(path string) (*Filter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(f)
+	var header struct {
+		Magic  [8]byte
+		M      uint64
+		Hashes uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil || header.Magic != filterMagic || header.M == 0 || header.Hashes == 0 {
+		return nil, errInvalidFilter
+	}
+	// The bits are only allocated once the file is known to hold them, so a
+	// damaged header cannot ask for more memory than the file takes.
+	words := (header.M-1)/64 + 1
+	if size := uint64(info.Size()); size < filterHeaderSize || size-filterHeaderSize != words*8 {
+		return nil, errInvalidFilter
+	}
+	filter := &Filter{bits: make([]uint64, words), m: header.M, hashes: header.Hashes}
+	if err := binary.Read(r, binary.LittleEndian, filter.bits); err != nil {
+		return nil, errInvalidFilter
+	}
+	return filter, nil
+}
+
+// Add adds the upper or lower case hex SHA-1 hash.
+func (f *Filter) Add(hash string) error {
+	h1, h2, err := splitHash(hash)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	return nil
+}
+
+func (f *Filter) Contains(password string) (bool, error) {
+	h1, h2, err := splitHash(Hash(password))
+	if err != nil {
+		return false, err
+	}
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// WriteTo writes the filter in the format LoadFilter reads.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	header := struct {
+		Magic  [8]byte
+		M      uint64
+		Hashes uint32
+	}{filterMagic, f.m, f.hashes}
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return cw.n, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, f.bits); err != nil {
+		return cw.n, err
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// splitHash returns the two 64 bit values the bits of hash are chosen by.
+func splitHash(hash string) (uint64, uint64, error) {
+	sum, err := hex.DecodeString(hash)
+	if err != nil || len(sum) != 20 {
+		return 0, 0, fmt.Errorf("invalid SHA-1 hash %q", hash)
+	}
+	// A step of zero would set the same bit for every hash function.
+	return binary.LittleEndian.Uint64(sum[:8]), binary.LittleEndian.Uint64(sum[8:16]) | 1, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
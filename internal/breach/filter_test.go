@@ -0,0 +1,179 @@
+package breach
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFilterContainsAddedPasswords(t *testing.T) {
+	filter := NewFilter(1000, 0.001)
+	for i := 0; i < 1000; i++ {
+		if err := filter.Add(Hash(fmt.Sprintf("breached-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		ok, err := filter.Contains(fmt.Sprintf("breached-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Fatalf("breached-%d is missing", i)
+		}
+	}
+
+	// At a rate of 0.1%, 10000 other passwords should give about 10 false
+	// positives; 50 would mean the filter is sized or indexed wrong.
+	var falsePositives int
+	for i := 0; i < 10000; i++ {
+		ok, err := filter.Contains(fmt.Sprintf("other-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			falsePositives++
+		}
+	}
+	if falsePositives > 50 {
+		t.Errorf("%d false positives out of 10000", falsePositives)
+	}
+}
+
+func TestFilterAddAcceptsUpperAndLowerCase(t *testing.T) {
+	hash := Hash("password")
+	for _, h := range []string{hash, strings.ToLower(hash)} {
+		filter := NewFilter(1, 0.01)
+		if err := filter.Add(h); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := filter.Contains("password"); !ok {
+			t.Errorf("Add(%q) did not add the password", h)
+		}
+	}
+}
+
+func TestFilterAddRejectsInvalidHashes(t *testing.T) {
+	for _, hash := range []string{"", "5BAA6", "zz" + Hash("password")[2:], Hash("password") + "00"} {
+		if err := NewFilter(1, 0.01).Add(hash); err == nil {
+			t.Errorf("Add(%q) did not fail", hash)
+		}
+	}
+}
+
+func TestFilterRoundTrip(t *testing.T) {
+	filter := NewFilter(100, 0.01)
+	for i := 0; i < 100; i++ {
+		if err := filter.Add(Hash(fmt.Sprintf("breached-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := writeFilter(t, filter)
+
+	loaded, err := LoadFilter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.m != filter.m || loaded.hashes != filter.hashes || len(loaded.bits) != len(filter.bits) {
+		t.Fatalf("loaded m=%d hashes=%d words=%d, want m=%d hashes=%d words=%d",
+			loaded.m, loaded.hashes, len(loaded.bits), filter.m, filter.hashes, len(filter.bits))
+	}
+	for i := range filter.bits {
+		if loaded.bits[i] != filter.bits[i] {
+			t.Fatalf("word %d differs", i)
+		}
+	}
+}
+
+func TestLoadFilterRejectsInvalidFiles(t *testing.T) {
+	filter := NewFilter(100, 0.01)
+	var valid bytes.Buffer
+	if _, err := filter.WriteTo(&valid); err != nil {
+		t.Fatal(err)
+	}
+	withM := func(m uint64) []byte {
+		b := append([]byte(nil), valid.Bytes()...)
+		binary.LittleEndian.PutUint64(b[8:16], m)
+		return b
+	}
+	withHashes := func(hashes uint32) []byte {
+		b := append([]byte(nil), valid.Bytes()...)
+		binary.LittleEndian.PutUint32(b[16:20], hashes)
+		return b
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated header", valid.Bytes()[:10]},
+		{"header only", valid.Bytes()[:filterHeaderSize]},
+		{"truncated bits", valid.Bytes()[:valid.Len()-8]},
+		{"trailing data", append(append([]byte(nil), valid.Bytes()...), 0, 0, 0, 0, 0, 0, 0, 0)},
+		{"wrong magic", append([]byte("NOTBLOOM"), valid.Bytes()[8:]...)},
+		{"zero bits", withM(0)},
+		{"zero hash functions", withHashes(0)},
+		{"more bits than the file holds", withM(filter.m + 64)},
+		{"fewer bits than the file holds", withM(filter.m - 64)},
+		{"huge number of bits", withM(1 << 62)},
+		{"number of bits overflowing", withM(^uint64(0))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "filter")
+			if err := os.WriteFile(path, tt.data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadFilter(path); err != errInvalidFilter {
+				t.Errorf("LoadFilter() error = %v, want %v", err, errInvalidFilter)
+			}
+		})
+	}
+}
+
+func TestNewFilterSizing(t *testing.T) {
+	tests := []struct {
+		n          uint64
+		p          float64
+		wantM      uint64
+		wantHashes uint32
+	}{
+		// m = -n ln p / (ln 2)^2, k = m/n ln 2
+		{1000, 0.01, 9586, 7},
+		{1000000, 0.001, 14377588, 10},
+		// Tiny filters still get a word of bits.
+		{0, 0.5, 64, 44},
+	}
+	for _, tt := range tests {
+		filter := NewFilter(tt.n, tt.p)
+		if filter.m != tt.wantM || filter.hashes != tt.wantHashes {
+			t.Errorf("NewFilter(%d, %v) has m=%d hashes=%d, want m=%d hashes=%d",
+				tt.n, tt.p, filter.m, filter.hashes, tt.wantM, tt.wantHashes)
+		}
+		if uint64(len(filter.bits))*64 < filter.m {
+			t.Errorf("NewFilter(%d, %v) has %d words for %d bits", tt.n, tt.p, len(filter.bits), filter.m)
+		}
+	}
+}
+
+func writeFilter(t *testing.T, filter *Filter) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "filter")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
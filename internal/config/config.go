@@ -2,22 +2,83 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 type Config struct {
-	DBHost         string        `envconfig:"DB_HOST" default:"localhost"`
-	DBPort         string        `envconfig:"DB_PORT" default:"26257"`
-	DBName         string        `envconfig:"DB_NAME" default:"authentication"`
-	DBUser         string        `envconfig:"DB_USER" default:"root"`
-	DBPassword     string        `envconfig:"DB_PASSWORD" default:""`
-	DBSSLMode      string        `envconfig:"DB_SSL_MODE" default:"disable"`
-	JWTSecretKey   string        `envconfig:"JWT_SECRET_KEY" default:"123"`
-	TokenTTL       time.Duration `envconfig:"TOKEN_TTL" default:"30m"`
-	PORT           string        `envconfig:"PORT" default:"8080"`
-	MIGRATION_PATH string        `envconfig:"MIGRATION_PATH" default:"/app/migrations"`
+	DBBackend                 string        `envconfig:"DB_BACKEND" default:"cockroachdb"`
+	SQLitePath                string        `envconfig:"SQLITE_PATH" default:"authentication.db"`
+	DBHost                    string        `envconfig:"DB_HOST" default:"localhost"`
+	DBPort                    string        `envconfig:"DB_PORT" default:"26257"`
+	DBName                    string        `envconfig:"DB_NAME" default:"authentication"`
+	DBUser                    string        `envconfig:"DB_USER" default:"root"`
+	DBPassword                string        `envconfig:"DB_PASSWORD" default:""`
+	DBSSLMode                 string        `envconfig:"DB_SSL_MODE" default:"disable"`
+	DBQueryTimeout            time.Duration `envconfig:"DB_QUERY_TIMEOUT" default:"5s"`
+	JWTSecretKey              string        `envconfig:"JWT_SECRET_KEY" default:"123"`
+	JWTSigningAlgorithm       string        `envconfig:"JWT_SIGNING_ALGORITHM" default:"HS256"`
+	JWTPrivateKeyPath         string        `envconfig:"JWT_PRIVATE_KEY_PATH" default:""`
+	JWTKeyID                  string        `envconfig:"JWT_KEY_ID" default:""`
+	JWTVerificationKeyPaths   []string      `envconfig:"JWT_VERIFICATION_KEY_PATHS" default:""`
+	JWTPreviousSecretKeys     []string      `envconfig:"JWT_PREVIOUS_SECRET_KEYS" default:""`
+	JWTKeyRotationInterval    time.Duration `envconfig:"JWT_KEY_ROTATION_INTERVAL" default:"0"`
+	JWTKeyOverlap             time.Duration `envconfig:"JWT_KEY_OVERLAP" default:"1h"`
+	JWTKeySyncInterval        time.Duration `envconfig:"JWT_KEY_SYNC_INTERVAL" default:"1m"`
+	JWTIssuer                 string        `envconfig:"JWT_ISSUER" default:"authentication-service"`
+	JWTAudiences              []string      `envconfig:"JWT_AUDIENCES" default:"authentication-service"`
+	JWTLeeway                 time.Duration `envconfig:"JWT_LEEWAY" default:"30s"`
+	TokenTTL                  time.Duration `envconfig:"TOKEN_TTL" default:"30m"`
+	RefreshTokenTTL           time.Duration `envconfig:"REFRESH_TOKEN_TTL" default:"720h"`
+	AuthorizationCodeTTL      time.Duration `envconfig:"AUTHORIZATION_CODE_TTL" default:"1m"`
+	MFAIssuer                 string        `envconfig:"MFA_ISSUER" default:"authentication-service"`
+	MFAChallengeTTL           time.Duration `envconfig:"MFA_CHALLENGE_TTL" default:"5m"`
+	WebAuthnRPID              string        `envconfig:"WEBAUTHN_RP_ID" default:"localhost"`
+	WebAuthnRPName            string        `envconfig:"WEBAUTHN_RP_NAME" default:"authentication-service"`
+	WebAuthnOrigins           []string      `envconfig:"WEBAUTHN_ORIGINS" default:"http://localhost:8080"`
+	WebAuthnTimeout           time.Duration `envconfig:"WEBAUTHN_TIMEOUT" default:"5m"`
+	SMTPHost                  string        `envconfig:"SMTP_HOST" default:""`
+	SMTPPort                  string        `envconfig:"SMTP_PORT" default:"587"`
+	SMTPUsername              string        `envconfig:"SMTP_USERNAME" default:""`
+	SMTPPassword              string        `envconfig:"SMTP_PASSWORD" default:""`
+	MailFrom                  string        `envconfig:"MAIL_FROM" default:"no-reply@localhost"`
+	EmailVerificationURL      string        `envconfig:"EMAIL_VERIFICATION_URL" default:""`
+	EmailVerificationTTL      time.Duration `envconfig:"EMAIL_VERIFICATION_TTL" default:"24h"`
+	EmailVerificationRequired bool          `envconfig:"EMAIL_VERIFICATION_REQUIRED" default:"false"`
+	PasswordResetURL          string        `envconfig:"PASSWORD_RESET_URL" default:""`
+	PasswordResetTTL          time.Duration `envconfig:"PASSWORD_RESET_TTL" default:"1h"`
+	PasswordMinLength         int           `envconfig:"PASSWORD_MIN_LENGTH" default:"8"`
+	PasswordMaxLength         int           `envconfig:"PASSWORD_MAX_LENGTH" default:"128"`
+	PasswordMinClasses        int           `envconfig:"PASSWORD_MIN_CHARACTER_CLASSES" default:"0"`
+	PasswordMinStrength       int           `envconfig:"PASSWORD_MIN_STRENGTH" default:"2"`
+	PasswordDisallowUsername  bool          `envconfig:"PASSWORD_DISALLOW_USERNAME" default:"true"`
+	PasswordDisallowCommon    bool          `envconfig:"PASSWORD_DISALLOW_COMMON" default:"true"`
+	PasswordHistory           int           `envconfig:"PASSWORD_HISTORY" default:"0"`
+	PasswordMaxAge            time.Duration `envconfig:"PASSWORD_MAX_AGE" default:"0"`
+	PasswordChangeTokenTTL    time.Duration `envconfig:"PASSWORD_CHANGE_TOKEN_TTL" default:"10m"`
+	BreachedPasswordsPath     string        `envconfig:"BREACHED_PASSWORDS_PATH" default:""`
+	BreachedPasswordsMinCount int           `envconfig:"BREACHED_PASSWORDS_MIN_COUNT" default:"1"`
+	BreachedPasswordsOnLogin  bool          `envconfig:"BREACHED_PASSWORDS_CHECK_ON_LOGIN" default:"false"`
+	PasswordHasher            string        `envconfig:"PASSWORD_HASHER" default:"argon2id"`
+	Argon2Memory              uint32        `envconfig:"ARGON2_MEMORY" default:"65536"`
+	Argon2Iterations          uint32        `envconfig:"ARGON2_ITERATIONS" default:"3"`
+	Argon2Parallelism         uint8         `envconfig:"ARGON2_PARALLELISM" default:"4"`
+	BcryptCost                int           `envconfig:"BCRYPT_COST" default:"10"`
+	FirebaseSignerKey         string        `envconfig:"FIREBASE_SIGNER_KEY" default:""`
+	LoginMaxFailures          int           `envconfig:"LOGIN_MAX_FAILURES" default:"5"`
+	LoginMaxIPFailures        int           `envconfig:"LOGIN_MAX_IP_FAILURES" default:"50"`
+	LoginBackoff              time.Duration `envconfig:"LOGIN_BACKOFF" default:"1s"`
+	LoginLockoutDuration      time.Duration `envconfig:"LOGIN_LOCKOUT_DURATION" default:"15m"`
+	TrustedProxies            []string      `envconfig:"TRUSTED_PROXIES" default:""`
+	RateLimitBackend          string        `envconfig:"RATE_LIMIT_BACKEND" default:"memory"`
+	RateLimitDefault          string        `envconfig:"RATE_LIMIT_DEFAULT" default:"600/1m"`
+	RateLimitLogin            string        `envconfig:"RATE_LIMIT_LOGIN" default:"20/1m"`
+	RateLimitRegister         string        `envconfig:"RATE_LIMIT_REGISTER" default:"10/1h"`
+	CleanupInterval           time.Duration `envconfig:"CLEANUP_INTERVAL" default:"1h"`
+	PORT                      string        `envconfig:"PORT" default:"8080"`
+	MIGRATION_PATH            string        `envconfig:"MIGRATION_PATH" default:"/app/migrations"`
 }
 
 func Load() (*Config, error) {
@@ -27,5 +88,16 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("error loading configuration: %v", err)
 	}
 
+	// CLEANUP_INTERVAL was called REVOCATION_CLEANUP_INTERVAL while only the
+	// token denylist was cleaned up.
+	if _, ok := os.LookupEnv("CLEANUP_INTERVAL"); !ok {
+		if s, ok := os.LookupEnv("REVOCATION_CLEANUP_INTERVAL"); ok {
+			cfg.CleanupInterval, err = time.ParseDuration(s)
+			if err != nil {
+				return nil, fmt.Errorf("error loading configuration: REVOCATION_CLEANUP_INTERVAL: %v", err)
+			}
+		}
+	}
+
 	return &cfg, nil
 }
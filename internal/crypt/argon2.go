@@ -0,0 +1,130 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// DefaultArgon2id are the parameters of the first recommended option of RFC
+// 9106 for memory constrained environments, with 64 MiB of memory.
+var DefaultArgon2id = Argon2id{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// Upper bounds of argon2id parameters, for hashes to verify and for
+// PASSWORD_HASHER alike. Memory is in KiB, 1 GiB.
+const (
+	maxArgon2Memory      = 1024 * 1024
+	maxArgon2Iterations  = 64
+	maxArgon2Parallelism = 64
+)
+
+// Argon2id hashes passwords with argon2id into PHC strings such as
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>. Memory is in KiB.
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return a.phc(salt, argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)).String(), nil
+}
+
+func (a *Argon2id) Verify(password, hash string) (bool, error) {
+	p, params, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), p.salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(p.hash)))
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (a *Argon2id) Recognizes(hash string) bool {
+	p, err := parsePHC(hash)
+	return err == nil && p.id == Argon2idName
+}
+
+func (a *Argon2id) Check(hash string) error {
+	_, _, err := parseArgon2id(hash)
+	return err
+}
+
+func (a *Argon2id) NeedsRehash(hash string) bool {
+	p, params, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory != a.Memory || params.Iterations != a.Iterations ||
+		params.Parallelism != a.Parallelism || uint32(len(p.salt)) != a.SaltLength ||
+		uint32(len(p.hash)) != a.KeyLength
+}
+
+// validParams reports whether the memory, iterations and parallelism of a
+// are within the bounds of argon2id and the upper bounds above.
+func (a *Argon2id) validParams() bool {
+	return a.Iterations >= 1 && a.Iterations <= maxArgon2Iterations &&
+		a.Parallelism >= 1 && a.Parallelism <= maxArgon2Parallelism &&
+		a.Memory >= 8*uint32(a.Parallelism) && a.Memory <= maxArgon2Memory
+}
+
+func (a *Argon2id) phc(salt, key []byte) *phc {
+	return &phc{
+		id:      Argon2idName,
+		version: strconv.Itoa(argon2.Version),
+		params: [][2]string{
+			{"m", strconv.FormatUint(uint64(a.Memory), 10)},
+			{"t", strconv.FormatUint(uint64(a.Iterations), 10)},
+			{"p", strconv.FormatUint(uint64(a.Parallelism), 10)},
+		},
+		salt: salt,
+		hash: key,
+	}
+}
+
+// parseArgon2id parses an argon2id PHC string and the parameters it was
+// hashed with.
+func parseArgon2id(hash string) (*phc, *Argon2id, error) {
+	p, err := parsePHC(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.id != Argon2idName || p.version != strconv.Itoa(argon2.Version) || len(p.salt) == 0 || len(p.hash) == 0 {
+		return nil, nil, errInvalidHash
+	}
+
+	memory, err := p.uintParam("m", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	iterations, err := p.uintParam("t", 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	parallelism, err := p.uintParam("p", 8)
+	if err != nil {
+		return nil, nil, err
+	}
+	params := &Argon2id{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+	}
+	if !params.validParams() {
+		return nil, nil, errHashParams
+	}
+	return p, params, nil
+}
@@ -0,0 +1,116 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+)
+
+// argon2idVector is the argon2id test of the reference implementation,
+// "password" hashed with "somesalt", m=65536, t=2 and p=1.
+const argon2idVector = "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+
+// cheapArgon2id keeps tests fast.
+var cheapArgon2id = &Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idVerify(t *testing.T) {
+	a := &DefaultArgon2id
+	tests := []struct {
+		password string
+		hash     string
+		want     bool
+	}{
+		{"password", argon2idVector, true},
+		{"Password", argon2idVector, false},
+		{"", argon2idVector, false},
+		// The key length is taken from the hash.
+		{"password", argon2idVector[:len(argon2idVector)-1], false},
+	}
+	for _, tt := range tests {
+		ok, err := a.Verify(tt.password, tt.hash)
+		if err != nil {
+			t.Fatalf("Verify(%q, %q) error = %v", tt.password, tt.hash, err)
+		}
+		if ok != tt.want {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tt.password, tt.hash, ok, tt.want)
+		}
+	}
+}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := cheapArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q", hash)
+	}
+	if ok, err := cheapArgon2id.Verify("password", hash); err != nil || !ok {
+		t.Errorf("Verify() of a new hash = %v, %v", ok, err)
+	}
+	if other, _ := cheapArgon2id.Hash("password"); other == hash {
+		t.Error("Hash() returned the same hash twice")
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash, err := cheapArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cheapArgon2id.NeedsRehash(hash) {
+		t.Error("NeedsRehash() of a hash with the same parameters")
+	}
+	for _, a := range []*Argon2id{
+		{Memory: 128, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32},
+		{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32},
+		{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64},
+	} {
+		if !a.NeedsRehash(hash) {
+			t.Errorf("%+v does not need to rehash %q", a, hash)
+		}
+	}
+	if !cheapArgon2id.NeedsRehash("$2a$10$invalid") {
+		t.Error("NeedsRehash() of another format")
+	}
+}
+
+func TestArgon2idCheck(t *testing.T) {
+	tests := []struct {
+		hash string
+		want error
+	}{
+		{argon2idVector, nil},
+		{"$argon2id$v=19$m=1048576,t=64,p=64$c29tZXNhbHQ$aGFzaA", nil},
+		{"$argon2id$v=19$m=8,t=1,p=1$c29tZXNhbHQ$aGFzaA", nil},
+		// Out of range for argon2id.
+		{"$argon2id$v=19$m=7,t=1,p=1$c29tZXNhbHQ$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=64,t=0,p=1$c29tZXNhbHQ$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=64,t=1,p=0$c29tZXNhbHQ$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=64,t=1,p=16$c29tZXNhbHQ$aGFzaA", errHashParams},
+		// Beyond the bounds of what a login may take.
+		{"$argon2id$v=19$m=1048577,t=1,p=1$c29tZXNhbHQ$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=4294967295,t=1,p=1$c29tZXNhbHQ$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=64,t=65,p=1$c29tZXNhbHQ$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=1024,t=1,p=65$c29tZXNhbHQ$aGFzaA", errHashParams},
+		// Malformed.
+		{"$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$aGFzaA", errInvalidHash},
+		{"$argon2id$m=64,t=1,p=1$c29tZXNhbHQ$aGFzaA", errInvalidHash},
+		{"$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$aGFzaA", errInvalidHash},
+		{"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ", errInvalidHash},
+		{"$argon2id$v=19$m=64,t=1$c29tZXNhbHQ$aGFzaA", errInvalidHash},
+		{"$argon2id$v=19$m=64,t=1,p=256$c29tZXNhbHQ$aGFzaA", errInvalidHash},
+		{"$argon2id$v=19$m=4294967296,t=1,p=1$c29tZXNhbHQ$aGFzaA", errInvalidHash},
+	}
+	for _, tt := range tests {
+		if err := cheapArgon2id.Check(tt.hash); err != tt.want {
+			t.Errorf("Check(%q) error = %v, want %v", tt.hash, err, tt.want)
+		}
+		if tt.want != nil {
+			if _, err := cheapArgon2id.Verify("password", tt.hash); err != tt.want {
+				t.Errorf("Verify(%q) error = %v, want %v", tt.hash, err, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package crypt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxBcryptPassword is the length bcrypt truncates passwords to.
+const maxBcryptPassword = 72
+
+// Bcrypt hashes passwords with bcrypt. Its hashes keep the modular crypt
+// format, $2a$<cost>$<salt and hash>, which names the algorithm much like a
+// PHC string does. Passwords longer than 72 bytes are refused rather than
+// truncated.
+type Bcrypt struct {
+	Cost int
+}
+
+func NewBcrypt(cost int) (*Bcrypt, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("invalid bcrypt cost %d", cost)
+	}
+	return &Bcrypt{Cost: cost}, nil
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b *Bcrypt) Verify(password, hash string) (bool, error) {
+	// A longer password would match the hash of its first 72 bytes.
+	if len(password) > maxBcryptPassword {
+		return false, nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bcrypt) Recognizes(hash string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bcrypt) Check(hash string) error {
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return errInvalidHash
+	}
+	return nil
+}
+
+func (b *Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
@@ -1,18 +1,131 @@
 package crypt
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/cvele/authentication-service/internal/config"
+)
+
+// Names of the hashers PASSWORD_HASHER selects from.
+const (
+	Argon2idName = "argon2id"
+	BcryptName   = "bcrypt"
 )
 
-func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+// ErrUnknownHash is returned for hashes none of the verifiers recognize.
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Verifier verifies passwords against hashes of one format. Hashes name
+// their algorithm and parameters, so they can be verified after the
+// parameters changed.
+type Verifier interface {
+	// Verify reports whether password matches hash, which must be one the
+	// verifier recognizes.
+	Verify(password, hash string) (bool, error)
+	// Recognizes reports whether hash is in the verifier's format.
+	Recognizes(hash string) bool
+	// Check returns an error if hash, which must be one the verifier
+	// recognizes, is malformed or its parameters are out of range.
+	Check(hash string) error
+}
+
+// Hasher hashes new passwords with one algorithm.
+type Hasher interface {
+	Verifier
+	// Hash returns the hash of password with a new random salt.
+	Hash(password string) (string, error)
+	// NeedsRehash reports whether hash was made with other parameters than
+	// Hash uses.
+	NeedsRehash(hash string) bool
+}
+
+// Passwords hashes new passwords with the preferred hasher, and verifies
+// passwords against hashes of any of its verifiers.
+type Passwords struct {
+	preferred Hasher
+	verifiers []Verifier
+}
+
+func NewPasswords(preferred Hasher, others ...Verifier) *Passwords {
+	return &Passwords{preferred: preferred, verifiers: append([]Verifier{preferred}, others...)}
+}
+
+// New returns the hashers configured by PASSWORD_HASHER and its parameters.
+// Hashes of the other algorithm, and of the formats users may have been
+// imported with, are still verified.
+func New(cfg *config.Config) (*Passwords, error) {
+	argon2id := &Argon2id{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+		SaltLength:  DefaultArgon2id.SaltLength,
+		KeyLength:   DefaultArgon2id.KeyLength,
+	}
+	if !argon2id.validParams() {
+		return nil, fmt.Errorf("invalid argon2id parameters m=%d,t=%d,p=%d",
+			argon2id.Memory, argon2id.Iterations, argon2id.Parallelism)
+	}
+	bcrypt, err := NewBcrypt(cfg.BcryptCost)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(hashedPassword), nil
+
+	firebase := &FirebaseScrypt{}
+	if cfg.FirebaseSignerKey != "" {
+		if firebase.SignerKey, err = base64.StdEncoding.DecodeString(cfg.FirebaseSignerKey); err != nil {
+			return nil, fmt.Errorf("invalid FIREBASE_SIGNER_KEY: %v", err)
+		}
+	}
+	imported := []Verifier{&PBKDF2{}, &Scrypt{}, firebase, &SaltedSHA{}}
+
+	switch cfg.PasswordHasher {
+	case Argon2idName:
+		return NewPasswords(argon2id, append([]Verifier{bcrypt}, imported...)...), nil
+	case BcryptName:
+		return NewPasswords(bcrypt, append([]Verifier{argon2id}, imported...)...), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", cfg.PasswordHasher)
+	}
+}
+
+// Hash hashes password with the preferred hasher.
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.preferred.Hash(password)
+}
+
+// Verify reports whether password matches hash and, if it does, whether hash
+// should be replaced by a new one because it was made with another algorithm
+// or other parameters than the preferred ones.
+func (p *Passwords) Verify(password, hash string) (ok bool, rehash bool, err error) {
+	v := p.verifier(hash)
+	if v == nil {
+		return false, false, ErrUnknownHash
+	}
+	ok, err = v.Verify(password, hash)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, v != Verifier(p.preferred) || p.preferred.NeedsRehash(hash), nil
 }
 
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// Check returns an error if hash is not in a format passwords can be
+// verified against, or is malformed, or asks for more work than verifying a
+// password is allowed to take.
+func (p *Passwords) Check(hash string) error {
+	v := p.verifier(hash)
+	if v == nil {
+		return ErrUnknownHash
+	}
+	return v.Check(hash)
+}
+
+func (p *Passwords) verifier(hash string) Verifier {
+	for _, v := range p.verifiers {
+		if v.Recognizes(hash) {
+			return v
+		}
+	}
+	return nil
 }
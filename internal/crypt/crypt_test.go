@@ -0,0 +1,149 @@
+package crypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cvele/authentication-service/internal/config"
+)
+
+func TestPasswordsVerify(t *testing.T) {
+	bcrypt, err := NewBcrypt(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cheapHash, err := cheapArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := NewPasswords(cheapArgon2id, bcrypt)
+
+	tests := []struct {
+		name       string
+		password   string
+		hash       string
+		wantOK     bool
+		wantRehash bool
+	}{
+		{"preferred parameters", "password", cheapHash, true, false},
+		{"other parameters", "password", argon2idVector, true, true},
+		{"other algorithm", "password", bcryptHash, true, true},
+		{"wrong password", "Password", cheapHash, false, false},
+		{"wrong password of another algorithm", "Password", bcryptHash, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := p.Verify(tt.password, tt.hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOK || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, rehash, tt.wantOK, tt.wantRehash)
+			}
+		})
+	}
+
+	if _, _, err := p.Verify("password", "plain"); err != ErrUnknownHash {
+		t.Errorf("Verify() of an unknown format error = %v, want %v", err, ErrUnknownHash)
+	}
+}
+
+func TestBcryptRefusesLongPasswords(t *testing.T) {
+	bcrypt, err := NewBcrypt(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := strings.Repeat("a", maxBcryptPassword)
+	hash, err := bcrypt.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := bcrypt.Verify(password, hash); err != nil || !ok {
+		t.Errorf("Verify() of %d bytes = %v, %v", len(password), ok, err)
+	}
+	// bcrypt itself would only look at the first 72 bytes.
+	if ok, err := bcrypt.Verify(password+"b", hash); err != nil || ok {
+		t.Errorf("Verify() of %d bytes = %v, %v, want false", len(password)+1, ok, err)
+	}
+	if !bcrypt.NeedsRehash(strings.Replace(hash, "$04$", "$05$", 1)) {
+		t.Error("NeedsRehash() of another cost")
+	}
+}
+
+func TestNew(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism = 64, 1, 1
+	cfg.BcryptCost = 4
+
+	for name, prefix := range map[string]string{Argon2idName: "$argon2id$", BcryptName: "$2a$"} {
+		cfg.PasswordHasher = name
+		p, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New() with %s error = %v", name, err)
+		}
+		hash, err := p.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hash, prefix) {
+			t.Errorf("hash of %s = %q", name, hash)
+		}
+	}
+
+	for _, change := range []func(cfg *config.Config){
+		func(cfg *config.Config) { cfg.PasswordHasher = "md5" },
+		func(cfg *config.Config) { cfg.Argon2Memory = maxArgon2Memory + 1 },
+		func(cfg *config.Config) { cfg.Argon2Iterations = 0 },
+		func(cfg *config.Config) { cfg.BcryptCost = 32 },
+		func(cfg *config.Config) { cfg.FirebaseSignerKey = "not base64!" },
+	} {
+		invalid := *cfg
+		invalid.PasswordHasher = Argon2idName
+		change(&invalid)
+		if _, err := New(&invalid); err == nil {
+			t.Errorf("New() with %+v did not fail", invalid)
+		}
+	}
+}
+
+func TestPasswordsCheck(t *testing.T) {
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BcryptCost = 4
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		hash string
+		want error
+	}{
+		{argon2idVector, nil},
+		{"$2a$04$2kOGwm6ldZxbMF1fZDpVde1d0XsPbpnTBaN0s4Qn7Cx3lqmg4oGSa", nil},
+		{"$pbkdf2-sha256$6400$0ZrzXitFSGltTQnBWOsdAw$Y11AchqV4b0sUisdZd0Xr97KWoymNE0LNNrnEgY4H9M", nil},
+		{"pbkdf2_sha256$260000$seasalt$ftMWvEdczZQK5azuap2CQYKRjHLa1wOuMrfMiYEswYQ=", nil},
+		{"$scrypt$ln=16,r=8,p=1$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E", nil},
+		{"$firebase-scrypt$m=14,r=8,ss=Bw$c2FsdA$aGFzaA", nil},
+		{"{SSHA}0P/h6qtqTu/os3vrvcSSEToFaHsSNFZ4mrze8A==", nil},
+		{"$scrypt$ln=21,r=8,p=1$c2FsdA$aGFzaA", errHashParams},
+		{"$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ", errInvalidHash},
+		{"$1$saltsalt$qjXMvbEw8oaL.CzflDugX/", ErrUnknownHash},
+		{"5f4dcc3b5aa765d61d8327deb882cf99", ErrUnknownHash},
+		{"", ErrUnknownHash},
+	}
+	for _, tt := range tests {
+		if err := p.Check(tt.hash); err != tt.want {
+			t.Errorf("Check(%q) error = %v, want %v", tt.hash, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package crypt
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// pbkdf2Digests are the hash functions of PBKDF2 hashes, by the name PHC
+// strings give them.
+var pbkdf2Digests = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// maxPBKDF2Iterations is the upper bound of the iteration count of PBKDF2
+// hashes to verify, a few times what is recommended for any digest.
+const maxPBKDF2Iterations = 5_000_000
+
+// PBKDF2 verifies PBKDF2 hashes, as PHC strings such as
+// $pbkdf2-sha256$i=27500$<salt>$<hash> with sha1, sha256 or sha512, in
+// passlib's format, $pbkdf2-sha256$<iterations>$<salt>$<hash> or
+// $pbkdf2$... for sha1, and in Django's format,
+// pbkdf2_sha256$<iterations>$<salt>$<hash>, whose salt is not encoded. It
+// only verifies, new hashes are made with argon2id or bcrypt.
+type PBKDF2 struct{}
+
+func (PBKDF2) Verify(password, hash string) (bool, error) {
+	digest, iterations, salt, key, err := parsePBKDF2(hash)
+	if err != nil {
+		return false, err
+	}
+	derived := pbkdf2.Key([]byte(password), salt, iterations, len(key), digest)
+	return subtle.ConstantTimeCompare(derived, key) == 1, nil
+}
+
+func (PBKDF2) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$pbkdf2-") || strings.HasPrefix(hash, "$pbkdf2$") || strings.HasPrefix(hash, "pbkdf2_sha256$")
+}
+
+func (PBKDF2) Check(hash string) error {
+	_, _, _, _, err := parsePBKDF2(hash)
+	return err
+}
+
+// EncodePBKDF2 returns the PHC string of key, derived with PBKDF2 from salt
+// in iterations using digest, one of sha1, sha256 and sha512.
+func EncodePBKDF2(digest string, iterations int, salt, key []byte) string {
+	return (&phc{
+		id:     "pbkdf2-" + digest,
+		params: [][2]string{{"i", strconv.Itoa(iterations)}},
+		salt:   salt,
+		hash:   key,
+	}).String()
+}
+
+func parsePBKDF2(hash string) (func() hash.Hash, int, []byte, []byte, error) {
+	if strings.HasPrefix(hash, "pbkdf2_sha256$") {
+		fields := strings.Split(hash, "$")
+		if len(fields) != 4 {
+			return nil, 0, nil, nil, errInvalidHash
+		}
+		iterations, err := strconv.Atoi(fields[1])
+		if err != nil || iterations < 1 {
+			return nil, 0, nil, nil, errInvalidHash
+		}
+		if iterations > maxPBKDF2Iterations {
+			return nil, 0, nil, nil, errHashParams
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil || len(key) == 0 {
+			return nil, 0, nil, nil, errInvalidHash
+		}
+		return sha256.New, iterations, []byte(fields[2]), key, nil
+	}
+
+	// passlib leaves out the name of the iteration count, and encodes with
+	// . in place of +.
+	if fields := strings.Split(hash, "$"); len(fields) == 5 && !strings.Contains(fields[2], "=") {
+		return parsePasslibPBKDF2(fields)
+	}
+
+	p, err := parsePHC(hash)
+	if err != nil {
+		return nil, 0, nil, nil, err
+	}
+	digest, ok := pbkdf2Digests[strings.TrimPrefix(p.id, "pbkdf2-")]
+	if !ok || len(p.hash) == 0 {
+		return nil, 0, nil, nil, errInvalidHash
+	}
+	iterations, err := p.uintParam("i", 31)
+	if err != nil || iterations < 1 {
+		return nil, 0, nil, nil, errInvalidHash
+	}
+	if iterations > maxPBKDF2Iterations {
+		return nil, 0, nil, nil, errHashParams
+	}
+	return digest, int(iterations), p.salt, p.hash, nil
+}
+
+func parsePasslibPBKDF2(fields []string) (func() hash.Hash, int, []byte, []byte, error) {
+	name := "sha1"
+	if fields[1] != "pbkdf2" {
+		name = strings.TrimPrefix(fields[1], "pbkdf2-")
+	}
+	digest, ok := pbkdf2Digests[name]
+	if fields[0] != "" || !ok {
+		return nil, 0, nil, nil, errInvalidHash
+	}
+	iterations, err := strconv.ParseUint(fields[2], 10, 31)
+	if err != nil || iterations < 1 {
+		return nil, 0, nil, nil, errInvalidHash
+	}
+	if iterations > maxPBKDF2Iterations {
+		return nil, 0, nil, nil, errHashParams
+	}
+	salt, err := b64.DecodeString(strings.ReplaceAll(fields[3], ".", "+"))
+	if err != nil {
+		return nil, 0, nil, nil, errInvalidHash
+	}
+	key, err := b64.DecodeString(strings.ReplaceAll(fields[4], ".", "+"))
+	if err != nil || len(key) == 0 {
+		return nil, 0, nil, nil, errInvalidHash
+	}
+	return digest, int(iterations), salt, key, nil
+}
@@ -0,0 +1,78 @@
+package crypt
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestPBKDF2Verify(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+	}{
+		// The sample of passlib's documentation.
+		{"passlib sha256", "password", "$pbkdf2-sha256$6400$0ZrzXitFSGltTQnBWOsdAw$Y11AchqV4b0sUisdZd0Xr97KWoymNE0LNNrnEgY4H9M"},
+		{"passlib sha1", "password", "$pbkdf2$1212$ABEiM0RVZnc$Of46/BxrEe3UlgQX9fwJiI6VWQQ"},
+		// The salt has a . where base64 has a +.
+		{"passlib sha512", "password", "$pbkdf2-sha512$25000$./H.f76vngABAgMEBQYHCA$5EKHoPN0n1o1ADt/wgOc7M8.QpmwBJ7Jlby7CAYOt3FsQfaN0daPg5BHY7xoo6BaVW0iBMUmMSLFTst9SqTZRw"},
+		{"Django", "password", "pbkdf2_sha256$260000$seasalt$ftMWvEdczZQK5azuap2CQYKRjHLa1wOuMrfMiYEswYQ="},
+		// RFC 6070, with 4096 iterations.
+		{"PHC sha1", "password", "$pbkdf2-sha1$i=4096$c2FsdA$SwB5AbdlSJq+rUnZJvch0GWkKcE"},
+		{"PHC sha256", "password", "$pbkdf2-sha256$i=27500$c2FsdHNhbHRzYWx0c2FsdA$FLAKrylANDgDgtPBQiUvp4/v52EpM6tnb3b2ZEP26EQ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !(PBKDF2{}).Recognizes(tt.hash) {
+				t.Fatalf("Recognizes(%q) = false", tt.hash)
+			}
+			if ok, err := (PBKDF2{}).Verify(tt.password, tt.hash); err != nil || !ok {
+				t.Errorf("Verify(%q, %q) = %v, %v, want true", tt.password, tt.hash, ok, err)
+			}
+			if ok, err := (PBKDF2{}).Verify("Password", tt.hash); err != nil || ok {
+				t.Errorf("Verify(%q, %q) = %v, %v, want false", "Password", tt.hash, ok, err)
+			}
+		})
+	}
+}
+
+func TestEncodePBKDF2(t *testing.T) {
+	key, _ := base64.RawStdEncoding.DecodeString("SwB5AbdlSJq+rUnZJvch0GWkKcE")
+	hash := EncodePBKDF2("sha1", 4096, []byte("salt"), key)
+	if want := "$pbkdf2-sha1$i=4096$c2FsdA$SwB5AbdlSJq+rUnZJvch0GWkKcE"; hash != want {
+		t.Errorf("EncodePBKDF2() = %q, want %q", hash, want)
+	}
+}
+
+func TestPBKDF2Check(t *testing.T) {
+	tests := []struct {
+		hash string
+		want error
+	}{
+		{"$pbkdf2-sha256$i=5000000$c2FsdA$aGFzaA", nil},
+		{"$pbkdf2-sha256$5000000$c2FsdA$aGFzaA", nil},
+		{"pbkdf2_sha256$5000000$salt$aGFzaA==", nil},
+		// Beyond the bounds of what a login may take.
+		{"$pbkdf2-sha256$i=5000001$c2FsdA$aGFzaA", errHashParams},
+		{"$pbkdf2-sha256$5000001$c2FsdA$aGFzaA", errHashParams},
+		{"pbkdf2_sha256$5000001$salt$aGFzaA==", errHashParams},
+		// Malformed.
+		{"$pbkdf2-sha256$i=0$c2FsdA$aGFzaA", errInvalidHash},
+		{"$pbkdf2-md5$i=1000$c2FsdA$aGFzaA", errInvalidHash},
+		{"$pbkdf2-sha256$i=1000$c2FsdA", errInvalidHash},
+		{"$pbkdf2-sha256$i=1000$c2FsdA$", errInvalidHash},
+		{"$pbkdf2-sha256$0$c2FsdA$aGFzaA", errInvalidHash},
+		{"$pbkdf2-sha256$-1$c2FsdA$aGFzaA", errInvalidHash},
+		{"$pbkdf2-md5$1000$c2FsdA$aGFzaA", errInvalidHash},
+		{"$pbkdf2-sha256$1000$c2Fsd!$aGFzaA", errInvalidHash},
+		{"pbkdf2_sha256$0$salt$aGFzaA==", errInvalidHash},
+		{"pbkdf2_sha256$1000$salt", errInvalidHash},
+		{"pbkdf2_sha256$1000$salt$", errInvalidHash},
+		{"pbkdf2_sha256$1000$salt$aGFzaA", errInvalidHash},
+	}
+	for _, tt := range tests {
+		if err := (PBKDF2{}).Check(tt.hash); err != tt.want {
+			t.Errorf("Check(%q) error = %v, want %v", tt.hash, err, tt.want)
+		}
+	}
+}
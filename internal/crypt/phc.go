@@ -0,0 +1,113 @@
+package crypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errInvalidHash = errors.New("invalid password hash")
+	// errHashParams is returned for hashes whose parameters are out of
+	// range. Verifying a hash with no upper bound on its cost would let a
+	// single login, or an imported hash, tie up the server.
+	errHashParams = errors.New("password hash parameters out of range")
+)
+
+// b64 is the base64 encoding of salts and hashes in PHC strings.
+var b64 = base64.RawStdEncoding
+
+// phc is a hash in the PHC string format,
+// $<id>[$v=<version>][$<param>=<value>(,<param>=<value>)*][$<salt>[$<hash>]].
+// Parameters keep their order, so that strings round trip.
+type phc struct {
+	id      string
+	version string
+	params  [][2]string
+	salt    []byte
+	hash    []byte
+}
+
+func parsePHC(s string) (*phc, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) < 2 || fields[0] != "" || fields[1] == "" {
+		return nil, errInvalidHash
+	}
+	p := &phc{id: fields[1]}
+	fields = fields[2:]
+
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "v=") {
+		p.version = strings.TrimPrefix(fields[0], "v=")
+		fields = fields[1:]
+	}
+	if len(fields) > 0 && strings.Contains(fields[0], "=") {
+		for _, param := range strings.Split(fields[0], ",") {
+			name, value, ok := strings.Cut(param, "=")
+			if !ok || name == "" {
+				return nil, errInvalidHash
+			}
+			p.params = append(p.params, [2]string{name, value})
+		}
+		fields = fields[1:]
+	}
+
+	var err error
+	switch len(fields) {
+	case 2:
+		if p.hash, err = b64.DecodeString(fields[1]); err != nil {
+			return nil, errInvalidHash
+		}
+		fallthrough
+	case 1:
+		if p.salt, err = b64.DecodeString(fields[0]); err != nil {
+			return nil, errInvalidHash
+		}
+	case 0:
+	default:
+		return nil, errInvalidHash
+	}
+	return p, nil
+}
+
+func (p *phc) String() string {
+	var b strings.Builder
+	b.WriteString("$" + p.id)
+	if p.version != "" {
+		b.WriteString("$v=" + p.version)
+	}
+	for i, param := range p.params {
+		if i == 0 {
+			b.WriteString("$")
+		} else {
+			b.WriteString(",")
+		}
+		b.WriteString(param[0] + "=" + param[1])
+	}
+	if p.salt != nil {
+		b.WriteString("$" + b64.EncodeToString(p.salt))
+		if p.hash != nil {
+			b.WriteString("$" + b64.EncodeToString(p.hash))
+		}
+	}
+	return b.String()
+}
+
+// param returns the value of the parameter name, or an empty string.
+func (p *phc) param(name string) string {
+	for _, param := range p.params {
+		if param[0] == name {
+			return param[1]
+		}
+	}
+	return ""
+}
+
+// uintParam parses the parameter name as an unsigned integer of bitSize bits.
+func (p *phc) uintParam(name string, bitSize int) (uint64, error) {
+	n, err := strconv.ParseUint(p.param(name), 10, bitSize)
+	if err != nil {
+		return 0, errInvalidHash
+	}
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package crypt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePHC(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *phc
+	}{
+		{"$argon2id", &phc{id: "argon2id"}},
+		{"$argon2id$v=19", &phc{id: "argon2id", version: "19"}},
+		{"$argon2id$v=19$m=65536,t=2,p=1", &phc{id: "argon2id", version: "19", params: [][2]string{{"m", "65536"}, {"t", "2"}, {"p", "1"}}}},
+		{"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ", &phc{
+			id: "argon2id", version: "19", params: [][2]string{{"m", "65536"}, {"t", "2"}, {"p", "1"}},
+			salt: []byte("somesalt"),
+		}},
+		{"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$aGFzaA", &phc{
+			id: "argon2id", version: "19", params: [][2]string{{"m", "65536"}, {"t", "2"}, {"p", "1"}},
+			salt: []byte("somesalt"), hash: []byte("hash"),
+		}},
+		// Parameters keep their order, and empty values.
+		{"$scrypt$r=8,ln=16,x=$c2FsdA$aGFzaA", &phc{
+			id: "scrypt", params: [][2]string{{"r", "8"}, {"ln", "16"}, {"x", ""}},
+			salt: []byte("salt"), hash: []byte("hash"),
+		}},
+		{"$pbkdf2-sha256$c2FsdA$aGFzaA", &phc{id: "pbkdf2-sha256", salt: []byte("salt"), hash: []byte("hash")}},
+		{"$scrypt$ln=4,r=1,p=1$$aGFzaA", &phc{id: "scrypt", params: [][2]string{{"ln", "4"}, {"r", "1"}, {"p", "1"}}, salt: []byte{}, hash: []byte("hash")}},
+	}
+	for _, tt := range tests {
+		got, err := parsePHC(tt.in)
+		if err != nil {
+			t.Errorf("parsePHC(%q) error = %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePHC(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.in {
+			t.Errorf("parsePHC(%q).String() = %q", tt.in, s)
+		}
+	}
+}
+
+func TestParsePHCRejectsInvalidStrings(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"argon2id",
+		"$",
+		"$$",
+		"x$argon2id",
+		"$argon2id$m=1,t$c2FsdA$aGFzaA",
+		"$argon2id$m=1,=2$c2FsdA$aGFzaA",
+		"$argon2id$m=1$c2FsdA$aGFzaA$aGFzaA",
+		"$argon2id$m=1$c2Fsd!$aGFzaA",
+		"$argon2id$m=1$c2FsdA$aGFza!",
+		// Padding is not part of the format.
+		"$argon2id$m=1$c2FsdA==$aGFzaA",
+	} {
+		if p, err := parsePHC(in); err != errInvalidHash {
+			t.Errorf("parsePHC(%q) = %+v, %v, want %v", in, p, err, errInvalidHash)
+		}
+	}
+}
+
+func TestPHCUintParam(t *testing.T) {
+	p, err := parsePHC("$x$a=255,b=256,c=-1,d=,e=1x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := p.uintParam("a", 8); err != nil || n != 255 {
+		t.Errorf("uintParam(a) = %d, %v, want 255", n, err)
+	}
+	for _, name := range []string{"b", "c", "d", "e", "missing"} {
+		if n, err := p.uintParam(name, 8); err != errInvalidHash {
+			t.Errorf("uintParam(%s) = %d, %v, want %v", name, n, err, errInvalidHash)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+	"strconv"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const firebaseScryptName = "firebase-scrypt"
+
+// firebaseScryptKeyLength is the length of the key Firebase derives with
+// scrypt, the first half of which is the AES key.
+const firebaseScryptKeyLength = 64
+
+// Upper bounds of the scrypt parameters of hashes to verify, N = 2^ln. The
+// memory scrypt takes, 128·r·N bytes, is bounded by maxScryptMemory as well.
+const (
+	maxScryptLogN   = 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptMemory = 1 << 30
+)
+
+var errNoFirebaseSignerKey = errors.New("FIREBASE_SIGNER_KEY is not set")
+
+// Scrypt verifies scrypt hashes as PHC strings,
+// $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash>. It only verifies, new
+// hashes are made with argon2id or bcrypt.
+type Scrypt struct{}
+
+func (Scrypt) Verify(password, hash string) (bool, error) {
+	p, ln, r, parallelism, err := parseScrypt(hash)
+	if err != nil {
+		return false, err
+	}
+	key, err := scrypt.Key([]byte(password), p.salt, 1<<ln, int(r), int(parallelism), len(p.hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (Scrypt) Recognizes(hash string) bool {
+	p, err := parsePHC(hash)
+	return err == nil && p.id == "scrypt"
+}
+
+func (Scrypt) Check(hash string) error {
+	_, _, _, _, err := parseScrypt(hash)
+	return err
+}
+
+func parseScrypt(hash string) (p *phc, ln, r, parallelism uint64, err error) {
+	p, err = parsePHC(hash)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if p.id != "scrypt" || len(p.hash) == 0 {
+		return nil, 0, 0, 0, errInvalidHash
+	}
+	if ln, err = p.uintParam("ln", 6); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if r, err = p.uintParam("r", 31); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if parallelism, err = p.uintParam("p", 31); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if !validScryptParams(ln, r, parallelism) {
+		return nil, 0, 0, 0, errHashParams
+	}
+	return p, ln, r, parallelism, nil
+}
+
+// validScryptParams reports whether scrypt with N = 2^ln, r and p is within
+// the upper bounds above.
+func validScryptParams(ln, r, p uint64) bool {
+	return ln >= 1 && ln <= maxScryptLogN && r >= 1 && r <= maxScryptR &&
+		p >= 1 && p <= maxScryptP && 128*r<<ln <= maxScryptMemory
+}
+
+// FirebaseScrypt verifies hashes exported from Firebase Authentication, made
+// with its modified scrypt, as PHC strings
+// $firebase-scrypt$m=<mem cost>,r=<rounds>,ss=<salt separator>$<salt>$<hash>.
+// The signer key of the project is not part of the hashes, it is secret.
+type FirebaseScrypt struct {
+	SignerKey []byte
+}
+
+func (f *FirebaseScrypt) Verify(password, hash string) (bool, error) {
+	if len(f.SignerKey) == 0 {
+		return false, errNoFirebaseSignerKey
+	}
+
+	p, memCost, rounds, saltSeparator, err := parseFirebaseScrypt(hash)
+	if err != nil {
+		return false, err
+	}
+	salt := append(append([]byte{}, p.salt...), saltSeparator...)
+	key, err := scrypt.Key([]byte(password), salt, 1<<memCost, int(rounds), 1, firebaseScryptKeyLength)
+	if err != nil {
+		return false, err
+	}
+	block, err := aes.NewCipher(key[:32])
+	if err != nil {
+		return false, err
+	}
+	signed := make([]byte, len(f.SignerKey))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(signed, f.SignerKey)
+	return subtle.ConstantTimeCompare(signed, p.hash) == 1, nil
+}
+
+func (f *FirebaseScrypt) Recognizes(hash string) bool {
+	p, err := parsePHC(hash)
+	return err == nil && p.id == firebaseScryptName
+}
+
+func (f *FirebaseScrypt) Check(hash string) error {
+	_, _, _, _, err := parseFirebaseScrypt(hash)
+	return err
+}
+
+func parseFirebaseScrypt(hash string) (p *phc, memCost, rounds uint64, saltSeparator []byte, err error) {
+	p, err = parsePHC(hash)
+	if err != nil {
+		return nil, 0, 0, nil, err
+	}
+	if p.id != firebaseScryptName || len(p.hash) == 0 {
+		return nil, 0, 0, nil, errInvalidHash
+	}
+	if memCost, err = p.uintParam("m", 6); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	if rounds, err = p.uintParam("r", 31); err != nil {
+		return nil, 0, 0, nil, err
+	}
+	if saltSeparator, err = b64.DecodeString(p.param("ss")); err != nil {
+		return nil, 0, 0, nil, errInvalidHash
+	}
+	// Firebase's memory cost and rounds are scrypt's log2 N and r, with a
+	// parallelism of 1.
+	if !validScryptParams(memCost, rounds, 1) {
+		return nil, 0, 0, nil, errHashParams
+	}
+	return p, memCost, rounds, saltSeparator, nil
+}
+
+// EncodeFirebaseScrypt returns the PHC string of a Firebase password hash,
+// with the hash parameters of the project it was exported from.
+func EncodeFirebaseScrypt(memCost, rounds int, saltSeparator, salt, hash []byte) string {
+	return (&phc{
+		id: firebaseScryptName,
+		params: [][2]string{
+			{"m", strconv.Itoa(memCost)},
+			{"r", strconv.Itoa(rounds)},
+			{"ss", b64.EncodeToString(saltSeparator)},
+		},
+		salt: salt,
+		hash: hash,
+	}).String()
+}
@@ -0,0 +1,134 @@
+package crypt
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestScryptVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+	}{
+		// The test vectors of RFC 7914 that are cheap enough to run.
+		{"RFC 7914 empty", "", "$scrypt$ln=4,r=1,p=1$$d9ZXYjhleyA7GcpCwYoEl/FrSETjB0ro39/6P+3iFEL80Aad7QlI+DJqdToPyB8X6NPg+y4NNijPNeIMONGJBg"},
+		{"RFC 7914", "password", "$scrypt$ln=10,r=8,p=16$TmFDbA$/bq+HJ00cgB4VucZDQHp/nxq18vII3gw53N2Y0s3MWIurzDZLiKjiG/xCSedmDDaxyevuUqD7m2DYMvfoswGQA"},
+		// The sample of passlib's documentation.
+		{"passlib", "password", "$scrypt$ln=16,r=8,p=1$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !(Scrypt{}).Recognizes(tt.hash) {
+				t.Fatalf("Recognizes(%q) = false", tt.hash)
+			}
+			if ok, err := (Scrypt{}).Verify(tt.password, tt.hash); err != nil || !ok {
+				t.Errorf("Verify(%q, %q) = %v, %v, want true", tt.password, tt.hash, ok, err)
+			}
+			if ok, err := (Scrypt{}).Verify("Password", tt.hash); err != nil || ok {
+				t.Errorf("Verify(%q, %q) = %v, %v, want false", "Password", tt.hash, ok, err)
+			}
+		})
+	}
+}
+
+func TestScryptCheck(t *testing.T) {
+	tests := []struct {
+		hash string
+		want error
+	}{
+		{"$scrypt$ln=20,r=8,p=16$c2FsdA$aGFzaA", nil},
+		{"$scrypt$ln=15,r=32,p=1$c2FsdA$aGFzaA", nil},
+		// Beyond the bounds of what a login may take.
+		{"$scrypt$ln=21,r=1,p=1$c2FsdA$aGFzaA", errHashParams},
+		{"$scrypt$ln=10,r=33,p=1$c2FsdA$aGFzaA", errHashParams},
+		{"$scrypt$ln=10,r=8,p=17$c2FsdA$aGFzaA", errHashParams},
+		// 128·r·N is 2 GiB.
+		{"$scrypt$ln=20,r=16,p=1$c2FsdA$aGFzaA", errHashParams},
+		{"$scrypt$ln=0,r=8,p=1$c2FsdA$aGFzaA", errHashParams},
+		{"$scrypt$ln=10,r=0,p=1$c2FsdA$aGFzaA", errHashParams},
+		{"$scrypt$ln=10,r=8,p=0$c2FsdA$aGFzaA", errHashParams},
+		// Malformed.
+		{"$scrypt$ln=64,r=8,p=1$c2FsdA$aGFzaA", errInvalidHash},
+		{"$scrypt$ln=10,r=8$c2FsdA$aGFzaA", errInvalidHash},
+		{"$scrypt$ln=10,r=8,p=1$c2FsdA", errInvalidHash},
+		{"$scrypt$ln=10,r=8,p=1$c2FsdA$", errInvalidHash},
+	}
+	for _, tt := range tests {
+		if err := (Scrypt{}).Check(tt.hash); err != tt.want {
+			t.Errorf("Check(%q) error = %v, want %v", tt.hash, err, tt.want)
+		}
+	}
+}
+
+// firebaseSample is the sample of Firebase's documentation of its scrypt,
+// "user1password" of a project with the hash parameters below.
+var firebaseSample = struct {
+	signerKey, saltSeparator, salt, hash string
+	rounds, memCost                      int
+}{
+	signerKey:     "jxspr8Ki0RYycVU8zykbdLGjFQ3McFUH0uiiTvC8pVMXAn210wjLNmdZJzxUECKbm0QsEmYUSDzZvpjeJ9WmXA==",
+	saltSeparator: "Bw==",
+	salt:          "42xEC+ixf3L2lw==",
+	hash:          "lSrfV15cpx95/sZS2W9c9Kp6i/LVgQNDNC/qzrCnh1SAyZvqmZqAjTdn3aoItz+VHjoZilo78198JAdRuid5lQ==",
+	rounds:        8,
+	memCost:       14,
+}
+
+func TestFirebaseScryptVerify(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	s := firebaseSample
+	hash := EncodeFirebaseScrypt(s.memCost, s.rounds, decode(s.saltSeparator), decode(s.salt), decode(s.hash))
+	f := &FirebaseScrypt{SignerKey: decode(s.signerKey)}
+
+	if !f.Recognizes(hash) {
+		t.Fatalf("Recognizes(%q) = false", hash)
+	}
+	if (Scrypt{}).Recognizes(hash) {
+		t.Errorf("Scrypt recognizes %q", hash)
+	}
+	if ok, err := f.Verify("user1password", hash); err != nil || !ok {
+		t.Errorf("Verify() = %v, %v, want true", ok, err)
+	}
+	if ok, err := f.Verify("user2password", hash); err != nil || ok {
+		t.Errorf("Verify() of another password = %v, %v, want false", ok, err)
+	}
+	other := &FirebaseScrypt{SignerKey: decode(s.hash)}
+	if ok, err := other.Verify("user1password", hash); err != nil || ok {
+		t.Errorf("Verify() with another signer key = %v, %v, want false", ok, err)
+	}
+	if ok, err := (&FirebaseScrypt{}).Verify("user1password", hash); err != errNoFirebaseSignerKey || ok {
+		t.Errorf("Verify() without a signer key = %v, %v, want %v", ok, err, errNoFirebaseSignerKey)
+	}
+	// A hash can be imported before the signer key is configured.
+	if err := (&FirebaseScrypt{}).Check(hash); err != nil {
+		t.Errorf("Check() without a signer key error = %v", err)
+	}
+}
+
+func TestFirebaseScryptCheck(t *testing.T) {
+	f := &FirebaseScrypt{}
+	tests := []struct {
+		hash string
+		want error
+	}{
+		{"$firebase-scrypt$m=14,r=8,ss=Bw$c2FsdA$aGFzaA", nil},
+		{"$firebase-scrypt$m=21,r=8,ss=Bw$c2FsdA$aGFzaA", errHashParams},
+		{"$firebase-scrypt$m=14,r=33,ss=Bw$c2FsdA$aGFzaA", errHashParams},
+		{"$firebase-scrypt$m=14,r=8,ss=B!$c2FsdA$aGFzaA", errInvalidHash},
+		{"$firebase-scrypt$m=14,ss=Bw$c2FsdA$aGFzaA", errInvalidHash},
+		{"$firebase-scrypt$m=14,r=8,ss=Bw$c2FsdA", errInvalidHash},
+		{"$scrypt$m=14,r=8,ss=Bw$c2FsdA$aGFzaA", errInvalidHash},
+	}
+	for _, tt := range tests {
+		if err := f.Check(tt.hash); err != tt.want {
+			t.Errorf("Check(%q) error = %v, want %v", tt.hash, err, tt.want)
+		}
+	}
+}
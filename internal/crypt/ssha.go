@@ -0,0 +1,71 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"hash"
+	"strings"
+)
+
+// saltedSHAPrefixes are the hash functions of salted SHA hashes, by the
+// prefix of the hash.
+var saltedSHAPrefixes = []struct {
+	prefix string
+	digest func() hash.Hash
+}{
+	{"{SSHA}", sha1.New},
+	{"{SSHA256}", sha256.New},
+	{"{SSHA512}", sha512.New},
+}
+
+// SaltedSHA verifies salted SHA hashes as LDAP directories store them,
+// {SSHA256}<base64 of the digest of password and salt, followed by the salt>.
+// It only verifies, new hashes are made with argon2id or bcrypt.
+type SaltedSHA struct{}
+
+func (SaltedSHA) Verify(password, hash string) (bool, error) {
+	h, digest, salt, err := parseSaltedSHA(hash)
+	if err != nil {
+		return false, err
+	}
+	h.Write(bytes.Join([][]byte{[]byte(password), salt}, nil))
+	return subtle.ConstantTimeCompare(h.Sum(nil), digest) == 1, nil
+}
+
+func (SaltedSHA) Check(hash string) error {
+	_, _, _, err := parseSaltedSHA(hash)
+	return err
+}
+
+func (SaltedSHA) Recognizes(hash string) bool {
+	for _, s := range saltedSHAPrefixes {
+		if strings.HasPrefix(hash, s.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSaltedSHA returns a new hash of the function hash was made with, and
+// the digest and salt hash consists of.
+func parseSaltedSHA(hash string) (hash.Hash, []byte, []byte, error) {
+	for _, s := range saltedSHAPrefixes {
+		if !strings.HasPrefix(hash, s.prefix) {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, s.prefix))
+		if err != nil {
+			return nil, nil, nil, errInvalidHash
+		}
+		h := s.digest()
+		if len(decoded) <= h.Size() {
+			return nil, nil, nil, errInvalidHash
+		}
+		return h, decoded[:h.Size()], decoded[h.Size():], nil
+	}
+	return nil, nil, nil, ErrUnknownHash
+}
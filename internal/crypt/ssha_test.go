@@ -0,0 +1,41 @@
+package crypt
+
+import "testing"
+
+func TestSaltedSHAVerify(t *testing.T) {
+	// "password" with the salt 123456789abcdef0, as slappasswd makes them.
+	for _, hash := range []string{
+		"{SSHA}0P/h6qtqTu/os3vrvcSSEToFaHsSNFZ4mrze8A==",
+		"{SSHA256}xTAyX3xytRy88E6n7nN5PN69oI4xIecG4qAbVK1v3KESNFZ4mrze8A==",
+		"{SSHA512}GZJnqjMiIzuulzbTYo1E/v0uEbZvrG4mkpzw2eupMKd4fxHU///l0J2Vt/basAONFpsa72GvDk65VFaf5NsP1hI0VniavN7w",
+	} {
+		if !(SaltedSHA{}).Recognizes(hash) {
+			t.Errorf("Recognizes(%q) = false", hash)
+		}
+		if ok, err := (SaltedSHA{}).Verify("password", hash); err != nil || !ok {
+			t.Errorf("Verify(%q) = %v, %v, want true", hash, ok, err)
+		}
+		if ok, err := (SaltedSHA{}).Verify("Password", hash); err != nil || ok {
+			t.Errorf("Verify(%q) of another password = %v, %v, want false", hash, ok, err)
+		}
+	}
+}
+
+func TestSaltedSHACheck(t *testing.T) {
+	tests := []struct {
+		hash string
+		want error
+	}{
+		{"{SSHA}0P/h6qtqTu/os3vrvcSSEToFaHsSNFZ4mrze8A==", nil},
+		// A digest without a salt.
+		{"{SSHA}0P/h6qtqTu/os3vrvcSSEToFaHs=", errInvalidHash},
+		{"{SSHA256}0P/h6qtqTu/os3vrvcSSEToFaHsSNFZ4mrze8A==", errInvalidHash},
+		{"{SSHA}0P/h6qtqTu/os3vrvcSSEToFaHsSNFZ4mrze8A", errInvalidHash},
+		{"{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", ErrUnknownHash},
+	}
+	for _, tt := range tests {
+		if err := (SaltedSHA{}).Check(tt.hash); err != tt.want {
+			t.Errorf("Check(%q) error = %v, want %v", tt.hash, err, tt.want)
+		}
+	}
+}
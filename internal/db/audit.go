@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEvent is a security relevant event. ActorID is whoever caused it, a
+// user or a client, and TargetID the user it happened to; either is empty
+// when unknown. ClientID is the OAuth client it went through. Username is
+// the username given, for events about users that may not exist. Outcome is
+// whether the attempt succeeded and Reason why it did not, or what is left
+// to be done. Detail names what else the event was about, such as the role
+// assigned or the client registered.
+//
+// Events form a chain ordered by Seq, starting at 1: each carries the Hash
+// of the one before it in PrevHash, and its own Hash covers PrevHash and
+// every other field.
+type AuditEvent struct {
+	Seq        int64
+	OccurredAt time.Time
+	Type       string
+	ActorID    string
+	TargetID   string
+	ClientID   string
+	Username   string
+	IP         string
+	UserAgent  string
+	Outcome    string
+	Reason     string
+	Detail     string
+	PrevHash   string
+	Hash       string
+}
+
+// ChainHash returns the hash e should have: the hex encoded SHA-256 of its
+// fields, each prefixed with its length so that none can run into the next.
+func (e *AuditEvent) ChainHash() string {
+	h := sha256.New()
+	for _, field := range []string{
+		e.PrevHash,
+		strconv.FormatInt(e.Seq, 10),
+		e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		e.Type,
+		e.ActorID,
+		e.TargetID,
+		e.ClientID,
+		e.Username,
+		e.IP,
+		e.UserAgent,
+		e.Outcome,
+		e.Reason,
+		e.Detail,
+	} {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// AuditFilter selects the events ListAuditEvents returns. Empty fields
+// select every event, the others the events with that exact value, or for
+// Since and Until those that occurred within. Events are returned newest
+// first, from the one before Before if it is not zero, and at most Limit of
+// them.
+type AuditFilter struct {
+	Type     string
+	ActorID  string
+	TargetID string
+	ClientID string
+	Username string
+	IP       string
+	Outcome  string
+	Since    time.Time
+	Until    time.Time
+	Before   int64
+	Limit    int
+}
+
+// auditEventColumns are the columns scanAuditEvent reads, in order.
+const auditEventColumns = "seq, occurred_at, type, actor_id, target_id, client_id, username, ip, user_agent, outcome, reason, detail, prev_hash, hash"
+
+func scanAuditEvent(row rowScanner) (*AuditEvent, error) {
+	e := &AuditEvent{}
+	err := row.Scan(&e.Seq, &e.OccurredAt, &e.Type, &e.ActorID, &e.TargetID, &e.ClientID, &e.Username, &e.IP, &e.UserAgent,
+		&e.Outcome, &e.Reason, &e.Detail, &e.PrevHash, &e.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// AppendAuditEvent appends e to the chain, setting its Seq, PrevHash and
+// Hash. OccurredAt is kept to the microsecond, as the database does.
+func (db *DB) AppendAuditEvent(ctx context.Context, e *AuditEvent) error {
+	e.OccurredAt = e.OccurredAt.Truncate(time.Microsecond)
+	return db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT seq, hash FROM audit_chain WHERE id = 1"+db.forUpdate).Scan(&e.Seq, &e.PrevHash)
+		if err != nil {
+			return err
+		}
+		e.Seq++
+		e.Hash = e.ChainHash()
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO audit_events ("+auditEventColumns+`)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+			e.Seq, e.OccurredAt, e.Type, e.ActorID, e.TargetID, e.ClientID, e.Username, e.IP, e.UserAgent,
+			e.Outcome, e.Reason, e.Detail, e.PrevHash, e.Hash)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE audit_chain SET seq = $1, hash = $2 WHERE id = 1", e.Seq, e.Hash)
+		return err
+	})
+}
+
+// GetAuditChainHead returns the Seq and Hash of the last event appended, or
+// 0 and an empty hash if there is none.
+func (db *DB) GetAuditChainHead(ctx context.Context) (int64, string, error) {
+	var seq int64
+	var hash string
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, "SELECT seq, hash FROM audit_chain WHERE id = 1").Scan(&seq, &hash)
+	})
+	return seq, hash, err
+}
+
+// ListAuditEvents returns the events selected by filter.
+func (db *DB) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	var conditions []string
+	var args []interface{}
+	for _, field := range []struct{ column, value string }{
+		{"type", filter.Type},
+		{"actor_id", filter.ActorID},
+		{"target_id", filter.TargetID},
+		{"client_id", filter.ClientID},
+		{"username", filter.Username},
+		{"ip", filter.IP},
+		{"outcome", filter.Outcome},
+	} {
+		if field.value != "" {
+			args = append(args, field.value)
+			conditions = append(conditions, fmt.Sprintf("%s = $%d", field.column, len(args)))
+		}
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		conditions = append(conditions, fmt.Sprintf("occurred_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until)
+		conditions = append(conditions, fmt.Sprintf("occurred_at < $%d", len(args)))
+	}
+	if filter.Before != 0 {
+		args = append(args, filter.Before)
+		conditions = append(conditions, fmt.Sprintf("seq < $%d", len(args)))
+	}
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY seq DESC LIMIT $%d", len(args))
+
+	var events []AuditEvent
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		events = nil
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			e, err := scanAuditEvent(rows)
+			if err != nil {
+				return err
+			}
+			events = append(events, *e)
+		}
+		return rows.Err()
+	})
+	return events, err
+}
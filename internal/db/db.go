@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,26 +13,134 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// DB keeps the data in CockroachDB or PostgreSQL, or in SQLite when opened
+// with NewSQLite.
 type DB struct {
 	db *sql.DB
+	// forUpdate locks the rows selected to be updated, where transactions
+	// are not run one at a time anyway.
+	forUpdate string
+	// queryTimeout bounds every transaction, retries included, when it is
+	// not zero.
+	queryTimeout time.Duration
 }
 
-type Tx interface {
-	InsertUser(id, username, passwordHash string) error
-	GetUserByUsername(username string) (*User, error)
-	GetUserByID(id string) (*User, error)
+// ErrNotFound is returned by the Get methods of Store when what they look up
+// does not exist.
+var ErrNotFound = errors.New("not found")
+
+// Store is where the service keeps its data. DB and Memory implement it.
+// Every method taking a context gives up once it is done.
+type Store interface {
+	InsertUser(ctx context.Context, id, username, email, passwordHash string) error
+	ImportUser(ctx context.Context, user *User) (bool, error)
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByID(ctx context.Context, id string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	MarkEmailVerified(ctx context.Context, id, email string) (bool, error)
 	NewUUID() (string, error)
-	UpdatePassword(id, password string) error
+	UpdatePassword(ctx context.Context, id, password string, changedAt time.Time, keep int) error
+	GetPasswordHistory(ctx context.Context, userID string) ([]string, error)
+	RehashPassword(ctx context.Context, id, oldHash, newHash string) error
+	FlagPasswordBreached(ctx context.Context, id string, at time.Time) error
+	InsertRefreshToken(ctx context.Context, rt *RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, usedID string, next *RefreshToken) error
+	ListSessions(ctx context.Context, userID string) ([]RefreshToken, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error
+	RevokeAllUserTokens(ctx context.Context, userID string, before, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
+	DeleteExpired(ctx context.Context) (int64, error)
+	ListSigningKeys(ctx context.Context) ([]SigningKey, error)
+	RotateSigningKey(ctx context.Context, next *SigningKey, previousID string, expiresAt time.Time) error
+	ListRoles(ctx context.Context) ([]Role, error)
+	GetRoleByName(ctx context.Context, name string) (*Role, error)
+	SaveRole(ctx context.Context, role *Role) error
+	GetUserRoles(ctx context.Context, userID string) ([]string, []string, error)
+	AssignRole(ctx context.Context, userID, roleID string) error
+	UnassignRole(ctx context.Context, userID, roleID string) error
+	InsertClient(ctx context.Context, client *Client) error
+	GetClientByID(ctx context.Context, id string) (*Client, error)
+	ListClients(ctx context.Context) ([]Client, error)
+	DeleteClient(ctx context.Context, id string) error
+	InsertAuthorizationCode(ctx context.Context, code *AuthorizationCode) error
+	ConsumeAuthorizationCode(ctx context.Context, hash, familyID string) (*AuthorizationCode, error)
+	UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error
+	GetTOTP(ctx context.Context, userID string) (*TOTP, error)
+	SaveTOTPSecret(ctx context.Context, userID, secret string) (bool, error)
+	EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	UseRecoveryCode(ctx context.Context, userID, hash string) (bool, error)
+	DisableTOTP(ctx context.Context, userID string) error
+	InsertMFAChallenge(ctx context.Context, challenge *MFAChallenge) error
+	AttemptMFAChallenge(ctx context.Context, hash string) (*MFAChallenge, error)
+	DeleteMFAChallenge(ctx context.Context, hash string) error
+	InsertWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error
+	ConsumeWebAuthnChallenge(ctx context.Context, hash string) (*WebAuthnChallenge, error)
+	InsertWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+	GetWebAuthnCredential(ctx context.Context, id string) (*WebAuthnCredential, error)
+	ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(ctx context.Context, id string, previous, next int64) (bool, error)
+	DeleteWebAuthnCredential(ctx context.Context, userID, id string) (bool, error)
+	InsertPasswordResetToken(ctx context.Context, rt *PasswordResetToken) error
+	GetPasswordResetToken(ctx context.Context, hash string) (*PasswordResetToken, error)
+	ConsumePasswordResetToken(ctx context.Context, hash string) (*PasswordResetToken, error)
+	ResetPassword(ctx context.Context, userID, passwordHash string, changedAt time.Time, keep int) error
+	GetLoginFailures(ctx context.Context, scope, subject string) (*LoginFailures, error)
+	RecordLoginFailure(ctx context.Context, scope, subject string, at, expiresAt time.Time) (int, error)
+	LockLogin(ctx context.Context, scope, subject string, until time.Time) error
+	ClearLoginFailures(ctx context.Context, scope, subject string) (bool, error)
+	UpdateRateLimitBucket(ctx context.Context, key string, update func(b *RateLimitBucket)) error
+	ListUsers(ctx context.Context, filter UserFilter) ([]User, error)
+	DisableUser(ctx context.Context, id string, at time.Time) (bool, error)
+	EnableUser(ctx context.Context, id string) (bool, error)
+	RequirePasswordChange(ctx context.Context, id string, at time.Time) (bool, error)
+	DeleteUser(ctx context.Context, id string) (bool, error)
+	AppendAuditEvent(ctx context.Context, e *AuditEvent) error
+	GetAuditChainHead(ctx context.Context) (int64, string, error)
+	ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error)
 	Close() error
-	Get() *sql.DB
 }
 
+// User is an account. Email is empty for users registered before addresses
+// were collected, and EmailVerifiedAt is set once the user proved they
+// receive mail sent to it. PasswordBreachedAt is set once the user logged in
+// with a breached password, until it is changed. PasswordChangedAt is nil for
+// users created before it was recorded, until they change their password.
+// DisabledAt is set while an admin keeps the user from logging in, and
+// PasswordChangeRequiredAt once an admin required a new password, until it
+// is changed.
 type User struct {
-	ID       string
-	Username string
-	Password string
+	ID                       string
+	Username                 string
+	Email                    string
+	EmailVerifiedAt          *time.Time
+	Password                 string
+	PasswordBreachedAt       *time.Time
+	PasswordChangedAt        *time.Time
+	DisabledAt               *time.Time
+	PasswordChangeRequiredAt *time.Time
+}
+
+const userColumns = "id, username, email, email_verified_at, password, password_breached_at, password_changed_at, disabled_at, password_change_required_at"
+
+// Open returns the store selected by DB_BACKEND.
+func Open(cfg *config.Config) (Store, error) {
+	switch cfg.DBBackend {
+	case "cockroachdb":
+		return New(cfg)
+	case "sqlite":
+		return NewSQLite(cfg.SQLitePath, cfg.DBQueryTimeout)
+	case "memory":
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", cfg.DBBackend)
+	}
 }
 
+// New connects to CockroachDB or PostgreSQL, whose schema is created by the
+// migrations.
 func New(cfg *config.Config) (*DB, error) {
 
 	// Connect to the database
@@ -54,7 +163,20 @@ func New(cfg *config.Config) (*DB, error) {
 	db.SetMaxOpenConns(100)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	return &DB{db}, nil
+	return &DB{db: db, forUpdate: " FOR UPDATE", queryTimeout: cfg.DBQueryTimeout}, nil
+}
+
+// executeTx runs fn in a transaction, retried while the database asks to,
+// and gives up once ctx is done or DB_QUERY_TIMEOUT has passed.
+func (db *DB) executeTx(ctx context.Context, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if db.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.queryTimeout)
+		defer cancel()
+	}
+	return crdb.ExecuteTx(ctx, db.db, nil, func(tx *sql.Tx) error {
+		return fn(ctx, tx)
+	})
 }
 
 func (db *DB) Close() error {
@@ -65,55 +187,161 @@ func (db *DB) Get() *sql.DB {
 	return db.db
 }
 
-func (db *DB) GetUserByUsername(username string) (*User, error) {
-	user := &User{}
-	err := crdb.ExecuteTx(context.Background(), db.db, nil, func(tx *sql.Tx) error {
-		row := tx.QueryRow("SELECT id, username, password FROM users WHERE username = $1", username)
-		return row.Scan(&user.ID, &user.Username, &user.Password)
+// GetUserByUsername returns the user named username, or ErrNotFound if there
+// is no such user.
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	var user *User
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username)
+		user, err = scanUser(row)
+		return err
 	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (db *DB) InsertUser(id string, username string, password string) error {
-	err := crdb.ExecuteTx(context.Background(), db.db, nil, func(tx *sql.Tx) error {
-		_, err := tx.Exec("INSERT INTO users (id, username, password) VALUES ($1, $2, $3)", id, username, password)
+func (db *DB) InsertUser(ctx context.Context, id string, username string, email string, password string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO users (id, username, email, password, password_changed_at)
+			VALUES ($1, $2, $3, $4, $5)`, id, username, nullString(email), password, time.Now())
 		return err
 	})
 
 	return err
 }
 
+// ImportUser inserts user as it was exported from another system, keeping
+// whether its email address was verified and whether a new password is
+// required. It reports false, and leaves the users as they are, if the
+// username or address is taken.
+func (db *DB) ImportUser(ctx context.Context, user *User) (bool, error) {
+	var inserted bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO users (id, username, email, email_verified_at, password, password_changed_at,
+				password_change_required_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT DO NOTHING`,
+			user.ID, user.Username, nullString(user.Email), user.EmailVerifiedAt, user.Password, user.PasswordChangedAt,
+			user.PasswordChangeRequiredAt)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		inserted = n == 1
+		return err
+	})
+	return inserted, err
+}
+
 func (db *DB) NewUUID() (string, error) {
-	var id string
-	err := crdb.ExecuteTx(context.Background(), db.db, nil, func(tx *sql.Tx) error {
-		id = uuid.New().String()
-		return nil
+	return uuid.New().String(), nil
+}
+
+// GetUserByID returns the user with the id, or ErrNotFound if there is no
+// such user.
+func (db *DB) GetUserByID(ctx context.Context, id string) (*User, error) {
+	var user *User
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
+		user, err = scanUser(row)
+		return err
 	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return id, nil
+	return user, nil
 }
 
-func (db *DB) GetUserByID(id string) (*User, error) {
-	user := &User{}
-	err := crdb.ExecuteTx(context.Background(), db.db, nil, func(tx *sql.Tx) error {
-		row := tx.QueryRow("SELECT id, username, password FROM users WHERE id = $1", id)
-		return row.Scan(&user.ID, &user.Username, &user.Password)
+// GetUserByEmail returns the user with the address email, or ErrNotFound if
+// there is no such user.
+func (db *DB) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	var user *User
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1", email)
+		user, err = scanUser(row)
+		return err
 	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (tx *DB) UpdatePassword(id, password string) error {
-	err := crdb.ExecuteTx(context.Background(), tx.db, nil, func(tx *sql.Tx) error {
-		_, err := tx.Exec("UPDATE users SET password = $1 WHERE id = $2", password, id)
+// MarkEmailVerified records that user id proved ownership of email. It
+// reports false if that is no longer the user's address. Verifying an
+// address again keeps the time it was first verified.
+func (db *DB) MarkEmailVerified(ctx context.Context, id, email string) (bool, error) {
+	var verified bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE users SET email_verified_at = COALESCE(email_verified_at, $1)
+			WHERE id = $2 AND email = $3`, time.Now(), id, email)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		verified = n == 1
+		return err
+	})
+	return verified, err
+}
+
+// UpdatePassword replaces the password of user id with password, set at
+// changedAt, keeping the keep most recent previous hashes in its history.
+func (db *DB) UpdatePassword(ctx context.Context, id, password string, changedAt time.Time, keep int) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return changePassword(ctx, tx, id, password, changedAt, keep)
+	})
+	return err
+}
+
+// RehashPassword replaces the password hash of user id with newHash, a hash
+// of the same password made with other parameters. It does nothing if the
+// hash is no longer oldHash, as when the password was changed meanwhile.
+func (db *DB) RehashPassword(ctx context.Context, id, oldHash, newHash string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2 AND password = $3", newHash, id, oldHash)
+		return err
+	})
+	return err
+}
+
+// FlagPasswordBreached records that user id logged in at at with a password
+// found among the breached passwords. A flag set before is kept.
+func (db *DB) FlagPasswordBreached(ctx context.Context, id string, at time.Time) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE users SET password_breached_at = COALESCE(password_breached_at, $1) WHERE id = $2", at, id)
 		return err
 	})
 	return err
 }
+
+func scanUser(row rowScanner) (*User, error) {
+	user := &User{}
+	var email sql.NullString
+	var emailVerifiedAt, passwordBreachedAt, passwordChangedAt, disabledAt, passwordChangeRequiredAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &email, &emailVerifiedAt, &user.Password, &passwordBreachedAt, &passwordChangedAt,
+		&disabledAt, &passwordChangeRequiredAt)
+	if err != nil {
+		return nil, err
+	}
+	user.Email = email.String
+	user.EmailVerifiedAt = nullTime(emailVerifiedAt)
+	user.PasswordBreachedAt = nullTime(passwordBreachedAt)
+	user.PasswordChangedAt = nullTime(passwordChangedAt)
+	user.DisabledAt = nullTime(disabledAt)
+	user.PasswordChangeRequiredAt = nullTime(passwordChangeRequiredAt)
+	return user, nil
+}
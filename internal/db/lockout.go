@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Scopes failed logins are counted in.
+const (
+	LoginScopeUser = "user"
+	LoginScopeIP   = "ip"
+)
+
+// LoginFailures counts the failed logins for a subject, a username or source
+// address depending on the scope. Logins are refused until LockedUntil, if it
+// is set.
+type LoginFailures struct {
+	Scope        string
+	Subject      string
+	Failures     int
+	LastFailedAt time.Time
+	LockedUntil  *time.Time
+	ExpiresAt    time.Time
+}
+
+// GetLoginFailures returns the failed logins counted for subject in scope, or
+// ErrNotFound if there are none that have not expired.
+func (db *DB) GetLoginFailures(ctx context.Context, scope, subject string) (*LoginFailures, error) {
+	lf := &LoginFailures{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var lockedUntil sql.NullTime
+		row := tx.QueryRowContext(ctx, `SELECT scope, subject, failures, last_failed_at, locked_until, expires_at
+			FROM login_failures WHERE scope = $1 AND subject = $2 AND expires_at >= $3`, scope, subject, time.Now())
+		err := row.Scan(&lf.Scope, &lf.Subject, &lf.Failures, &lf.LastFailedAt, &lockedUntil, &lf.ExpiresAt)
+		lf.LockedUntil = nullTime(lockedUntil)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+// RecordLoginFailure counts a failed login at time at for subject in scope,
+// to be remembered until expiresAt, and returns the number of failures so
+// far. Failures that expired before are not counted.
+func (db *DB) RecordLoginFailure(ctx context.Context, scope, subject string, at, expiresAt time.Time) (int, error) {
+	var failures int
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `INSERT INTO login_failures (scope, subject, failures, last_failed_at, expires_at)
+			VALUES ($1, $2, 1, $3, $4)
+			ON CONFLICT (scope, subject) DO UPDATE SET
+				failures = CASE WHEN login_failures.expires_at < excluded.last_failed_at THEN 1 ELSE login_failures.failures + 1 END,
+				locked_until = CASE WHEN login_failures.expires_at < excluded.last_failed_at THEN NULL ELSE login_failures.locked_until END,
+				last_failed_at = excluded.last_failed_at,
+				expires_at = excluded.expires_at
+			RETURNING failures`, scope, subject, at, expiresAt)
+		return row.Scan(&failures)
+	})
+	return failures, err
+}
+
+// LockLogin refuses logins for subject in scope until until.
+func (db *DB) LockLogin(ctx context.Context, scope, subject string, until time.Time) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `UPDATE login_failures SET locked_until = $1,
+			expires_at = CASE WHEN expires_at < $1 THEN $1 ELSE expires_at END
+			WHERE scope = $2 AND subject = $3`, until, scope, subject)
+		return err
+	})
+	return err
+}
+
+// ClearLoginFailures forgets the failed logins for subject in scope, lifting
+// any lock. It reports false if there were none.
+func (db *DB) ClearLoginFailures(ctx context.Context, scope, subject string) (bool, error) {
+	var cleared bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM login_failures WHERE scope = $1 AND subject = $2", scope, subject)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		cleared = n == 1
+		return err
+	})
+	return cleared, err
+}
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errDuplicateKey is returned by Memory where the database would refuse a
+// row because of a unique constraint.
+var errDuplicateKey = errors.New("duplicate key value violates unique constraint")
+
+// adminRoleID is the id of the admin role created with the schema.
+const adminRoleID = "00000000-0000-0000-0000-000000000001"
+
+// Memory keeps the data in memory, for tests and local development. It
+// behaves like DB, but everything is lost when the process exits and every
+// replica has its own data. Its methods are documented on DB.
+type Memory struct {
+	mu sync.Mutex
+
+	users               map[string]*User
+	passwordHistory     map[string][]passwordHistoryEntry
+	refreshTokens       map[string]*RefreshToken
+	revokedTokens       map[string]time.Time
+	userRevocations     map[string]userRevocation
+	signingKeys         []SigningKey
+	roles               map[string]*Role
+	userRoles           map[string]map[string]bool
+	clients             map[string]*Client
+	authorizationCodes  map[string]*AuthorizationCode
+	clientAssertions    map[clientAssertion]time.Time
+	totp                map[string]*TOTP
+	recoveryCodes       map[string]map[string]bool
+	mfaChallenges       map[string]*MFAChallenge
+	webAuthnChallenges  map[string]*WebAuthnChallenge
+	webAuthnCredentials map[string]*WebAuthnCredential
+	passwordResetTokens map[string]*PasswordResetToken
+	loginFailures       map[loginSubject]*LoginFailures
+	rateLimitBuckets    map[string]*RateLimitBucket
+	auditEvents         []AuditEvent
+}
+
+type passwordHistoryEntry struct {
+	hash      string
+	createdAt time.Time
+}
+
+// NewMemory returns an empty store holding only the admin role.
+func NewMemory() *Memory {
+	return &Memory{
+		users:           make(map[string]*User),
+		passwordHistory: make(map[string][]passwordHistoryEntry),
+		refreshTokens:   make(map[string]*RefreshToken),
+		revokedTokens:   make(map[string]time.Time),
+		userRevocations: make(map[string]userRevocation),
+		roles: map[string]*Role{
+			adminRoleID: {ID: adminRoleID, Name: "admin", Description: "Manages users, roles and clients", Permissions: []string{"admin"}},
+		},
+		userRoles:           make(map[string]map[string]bool),
+		clients:             make(map[string]*Client),
+		authorizationCodes:  make(map[string]*AuthorizationCode),
+		clientAssertions:    make(map[clientAssertion]time.Time),
+		totp:                make(map[string]*TOTP),
+		recoveryCodes:       make(map[string]map[string]bool),
+		mfaChallenges:       make(map[string]*MFAChallenge),
+		webAuthnChallenges:  make(map[string]*WebAuthnChallenge),
+		webAuthnCredentials: make(map[string]*WebAuthnCredential),
+		passwordResetTokens: make(map[string]*PasswordResetToken),
+		loginFailures:       make(map[loginSubject]*LoginFailures),
+		rateLimitBuckets:    make(map[string]*RateLimitBucket),
+	}
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
+
+func (m *Memory) NewUUID() (string, error) {
+	return uuid.New().String(), nil
+}
+
+// userTaken reports whether a user with the id, username or address of user
+// exists.
+func (m *Memory) userTaken(user *User) bool {
+	if _, ok := m.users[user.ID]; ok {
+		return true
+	}
+	for _, u := range m.users {
+		if u.Username == user.Username || (user.Email != "" && u.Email == user.Email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) InsertUser(ctx context.Context, id, username, email, passwordHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	user := &User{ID: id, Username: username, Email: email, Password: passwordHash, PasswordChangedAt: &now}
+	if m.userTaken(user) {
+		return errDuplicateKey
+	}
+	m.users[id] = user
+	return nil
+}
+
+func (m *Memory) ImportUser(ctx context.Context, user *User) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userTaken(user) {
+		return false, nil
+	}
+	stored := *user
+	stored.PasswordBreachedAt, stored.DisabledAt = nil, nil
+	m.users[user.ID] = &stored
+	return true, nil
+}
+
+func (m *Memory) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Username == username {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) GetUserByID(ctx context.Context, id string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := *u
+	return &user, nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if email != "" && u.Email == email {
+			user := *u
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) MarkEmailVerified(ctx context.Context, id, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || email == "" || u.Email != email {
+		return false, nil
+	}
+	if u.EmailVerifiedAt == nil {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	return true, nil
+}
+
+func (m *Memory) UpdatePassword(ctx context.Context, id, password string, changedAt time.Time, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.changePassword(id, password, changedAt, keep)
+	return nil
+}
+
+// changePassword is changePassword of DB.
+func (m *Memory) changePassword(userID, passwordHash string, changedAt time.Time, keep int) {
+	u, ok := m.users[userID]
+	if !ok {
+		return
+	}
+
+	history := m.passwordHistory[userID]
+	if keep > 0 {
+		history = append(history, passwordHistoryEntry{hash: u.Password, createdAt: changedAt})
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].createdAt.After(history[j].createdAt) })
+	if len(history) > keep {
+		history = history[:keep]
+	}
+	m.passwordHistory[userID] = history
+
+	u.Password = passwordHash
+	u.PasswordChangedAt = &changedAt
+	u.PasswordBreachedAt = nil
+	u.PasswordChangeRequiredAt = nil
+}
+
+func (m *Memory) GetPasswordHistory(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var hashes []string
+	for _, entry := range m.passwordHistory[userID] {
+		hashes = append(hashes, entry.hash)
+	}
+	return hashes, nil
+}
+
+func (m *Memory) RehashPassword(ctx context.Context, id, oldHash, newHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok && u.Password == oldHash {
+		u.Password = newHash
+	}
+	return nil
+}
+
+func (m *Memory) FlagPasswordBreached(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; ok && u.PasswordBreachedAt == nil {
+		u.PasswordBreachedAt = &at
+	}
+	return nil
+}
+
+func (m *Memory) ListUsers(ctx context.Context, filter UserFilter) ([]User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	var users []User
+	for _, u := range m.users {
+		if query != "" && !strings.Contains(strings.ToLower(u.Username), query) && !strings.Contains(strings.ToLower(u.Email), query) {
+			continue
+		}
+		if filter.Disabled != nil && *filter.Disabled != (u.DisabledAt != nil) {
+			continue
+		}
+		if u.Username <= filter.After {
+			continue
+		}
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+	}
+	return users, nil
+}
+
+func (m *Memory) DisableUser(ctx context.Context, id string, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if ok && u.DisabledAt == nil {
+		u.DisabledAt = &at
+	}
+	return ok, nil
+}
+
+func (m *Memory) EnableUser(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if ok {
+		u.DisabledAt = nil
+	}
+	return ok, nil
+}
+
+func (m *Memory) RequirePasswordChange(ctx context.Context, id string, at time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if ok && u.PasswordChangeRequiredAt == nil {
+		u.PasswordChangeRequiredAt = &at
+	}
+	return ok, nil
+}
+
+func (m *Memory) DeleteUser(ctx context.Context, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return false, nil
+	}
+	delete(m.users, id)
+	delete(m.passwordHistory, id)
+	delete(m.userRevocations, id)
+	delete(m.userRoles, id)
+	delete(m.totp, id)
+	delete(m.recoveryCodes, id)
+	for key, rt := range m.refreshTokens {
+		if rt.UserID == id {
+			delete(m.refreshTokens, key)
+		}
+	}
+	for key, code := range m.authorizationCodes {
+		if code.UserID == id {
+			delete(m.authorizationCodes, key)
+		}
+	}
+	for key, challenge := range m.mfaChallenges {
+		if challenge.UserID == id {
+			delete(m.mfaChallenges, key)
+		}
+	}
+	for key, challenge := range m.webAuthnChallenges {
+		if challenge.UserID == id {
+			delete(m.webAuthnChallenges, key)
+		}
+	}
+	for key, credential := range m.webAuthnCredentials {
+		if credential.UserID == id {
+			delete(m.webAuthnCredentials, key)
+		}
+	}
+	for key, rt := range m.passwordResetTokens {
+		if rt.UserID == id {
+			delete(m.passwordResetTokens, key)
+		}
+	}
+	return true, nil
+}
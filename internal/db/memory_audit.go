@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+func (m *Memory) AppendAuditEvent(ctx context.Context, e *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.OccurredAt = e.OccurredAt.Truncate(time.Microsecond)
+	e.Seq, e.PrevHash = 1, ""
+	if n := len(m.auditEvents); n > 0 {
+		e.Seq, e.PrevHash = m.auditEvents[n-1].Seq+1, m.auditEvents[n-1].Hash
+	}
+	e.Hash = e.ChainHash()
+	m.auditEvents = append(m.auditEvents, *e)
+	return nil
+}
+
+func (m *Memory) GetAuditChainHead(ctx context.Context) (int64, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if n := len(m.auditEvents); n > 0 {
+		return m.auditEvents[n-1].Seq, m.auditEvents[n-1].Hash, nil
+	}
+	return 0, "", nil
+}
+
+func (m *Memory) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []AuditEvent
+	for i := len(m.auditEvents) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		e := m.auditEvents[i]
+		switch {
+		case filter.Type != "" && e.Type != filter.Type,
+			filter.ActorID != "" && e.ActorID != filter.ActorID,
+			filter.TargetID != "" && e.TargetID != filter.TargetID,
+			filter.ClientID != "" && e.ClientID != filter.ClientID,
+			filter.Username != "" && e.Username != filter.Username,
+			filter.IP != "" && e.IP != filter.IP,
+			filter.Outcome != "" && e.Outcome != filter.Outcome,
+			!filter.Since.IsZero() && e.OccurredAt.Before(filter.Since),
+			!filter.Until.IsZero() && !e.OccurredAt.Before(filter.Until),
+			filter.Before != 0 && e.Seq >= filter.Before:
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
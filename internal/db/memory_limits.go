@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type loginSubject struct {
+	scope   string
+	subject string
+}
+
+func (m *Memory) GetLoginFailures(ctx context.Context, scope, subject string) (*LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.loginFailures[loginSubject{scope, subject}]
+	if !ok || stored.ExpiresAt.Before(time.Now()) {
+		return nil, ErrNotFound
+	}
+	lf := *stored
+	return &lf, nil
+}
+
+func (m *Memory) RecordLoginFailure(ctx context.Context, scope, subject string, at, expiresAt time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginSubject{scope, subject}
+	lf, ok := m.loginFailures[key]
+	switch {
+	case !ok:
+		lf = &LoginFailures{Scope: scope, Subject: subject}
+		m.loginFailures[key] = lf
+	case lf.ExpiresAt.Before(at):
+		lf.Failures = 0
+		lf.LockedUntil = nil
+	}
+	lf.Failures++
+	lf.LastFailedAt = at
+	lf.ExpiresAt = expiresAt
+	return lf.Failures, nil
+}
+
+func (m *Memory) LockLogin(ctx context.Context, scope, subject string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lf, ok := m.loginFailures[loginSubject{scope, subject}]
+	if !ok {
+		return nil
+	}
+	lf.LockedUntil = &until
+	if lf.ExpiresAt.Before(until) {
+		lf.ExpiresAt = until
+	}
+	return nil
+}
+
+func (m *Memory) ClearLoginFailures(ctx context.Context, scope, subject string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := loginSubject{scope, subject}
+	_, ok := m.loginFailures[key]
+	delete(m.loginFailures, key)
+	return ok, nil
+}
+
+func (m *Memory) UpdateRateLimitBucket(ctx context.Context, key string, update func(b *RateLimitBucket)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b := &RateLimitBucket{}
+	if stored, ok := m.rateLimitBuckets[key]; ok && !stored.ExpiresAt.Before(time.Now()) {
+		*b = *stored
+	}
+	b.Key = key
+	update(b)
+	m.rateLimitBuckets[key] = b
+	return nil
+}
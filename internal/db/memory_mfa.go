@@ -0,0 +1,208 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+func (m *Memory) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.totp[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	totp := *stored
+	return &totp, nil
+}
+
+func (m *Memory) SaveTOTPSecret(ctx context.Context, userID, secret string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.totp[userID]
+	if !ok {
+		stored = &TOTP{UserID: userID}
+		m.totp[userID] = stored
+	} else if stored.EnabledAt != nil {
+		return false, nil
+	}
+	stored.Secret = secret
+	stored.CreatedAt = time.Now()
+	return true, nil
+}
+
+func (m *Memory) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.totp[userID]; ok {
+		now := time.Now()
+		stored.EnabledAt = &now
+		stored.LastUsedStep = step
+	}
+	codes := make(map[string]bool, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		codes[hash] = false
+	}
+	m.recoveryCodes[userID] = codes
+	return nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.totp[userID]
+	if !ok || stored.LastUsedStep >= step {
+		return ErrTOTPCodeReused
+	}
+	stored.LastUsedStep = step
+	return nil
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, userID, hash string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.recoveryCodes[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	m.recoveryCodes[userID][hash] = true
+	return true, nil
+}
+
+func (m *Memory) DisableTOTP(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.recoveryCodes, userID)
+	delete(m.totp, userID)
+	return nil
+}
+
+func (m *Memory) InsertMFAChallenge(ctx context.Context, challenge *MFAChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.mfaChallenges[challenge.TokenHash]; ok {
+		return errDuplicateKey
+	}
+	stored := *challenge
+	stored.Attempts = 0
+	m.mfaChallenges[challenge.TokenHash] = &stored
+	return nil
+}
+
+func (m *Memory) AttemptMFAChallenge(ctx context.Context, hash string) (*MFAChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.mfaChallenges[hash]
+	if !ok {
+		return nil, nil
+	}
+	stored.Attempts++
+	challenge := *stored
+	return &challenge, nil
+}
+
+func (m *Memory) DeleteMFAChallenge(ctx context.Context, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mfaChallenges, hash)
+	return nil
+}
+
+func (m *Memory) InsertWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webAuthnChallenges[challenge.ChallengeHash]; ok {
+		return errDuplicateKey
+	}
+	stored := *challenge
+	m.webAuthnChallenges[challenge.ChallengeHash] = &stored
+	return nil
+}
+
+func (m *Memory) ConsumeWebAuthnChallenge(ctx context.Context, hash string) (*WebAuthnChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.webAuthnChallenges[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.webAuthnChallenges, hash)
+	return stored, nil
+}
+
+func (m *Memory) InsertWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webAuthnCredentials[credential.ID]; ok {
+		return ErrWebAuthnCredentialExists
+	}
+	stored := *credential
+	stored.LastUsedAt = nil
+	m.webAuthnCredentials[credential.ID] = &stored
+	return nil
+}
+
+func (m *Memory) GetWebAuthnCredential(ctx context.Context, id string) (*WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.webAuthnCredentials[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	credential := *stored
+	return &credential, nil
+}
+
+func (m *Memory) ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var credentials []WebAuthnCredential
+	for _, credential := range m.webAuthnCredentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, *credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].CreatedAt.Before(credentials[j].CreatedAt) })
+	return credentials, nil
+}
+
+func (m *Memory) UpdateWebAuthnSignCount(ctx context.Context, id string, previous, next int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.webAuthnCredentials[id]
+	if !ok || stored.SignCount != previous {
+		return false, nil
+	}
+	now := time.Now()
+	stored.SignCount = next
+	stored.LastUsedAt = &now
+	return true, nil
+}
+
+func (m *Memory) DeleteWebAuthnCredential(ctx context.Context, userID, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.webAuthnCredentials[id]
+	if !ok || stored.UserID != userID {
+		return false, nil
+	}
+	delete(m.webAuthnCredentials, id)
+	return true, nil
+}
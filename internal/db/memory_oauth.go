@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type clientAssertion struct {
+	clientID string
+	jti      string
+}
+
+// copyRole returns a copy of role that does not share its permissions.
+func copyRole(role *Role) Role {
+	c := *role
+	c.Permissions = append([]string(nil), role.Permissions...)
+	return c
+}
+
+func (m *Memory) ListRoles(ctx context.Context) ([]Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var roles []Role
+	for _, role := range m.roles {
+		roles = append(roles, copyRole(role))
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (m *Memory) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.roles {
+		if stored.Name == name {
+			role := copyRole(stored)
+			return &role, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) SaveRole(ctx context.Context, role *Role) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	role.ID = ""
+	for _, stored := range m.roles {
+		if stored.Name == role.Name {
+			role.ID = stored.ID
+		}
+	}
+	if role.ID == "" {
+		role.ID = uuid.New().String()
+	}
+
+	seen := map[string]bool{}
+	var permissions []string
+	for _, name := range role.Permissions {
+		if !seen[name] {
+			seen[name] = true
+			permissions = append(permissions, name)
+		}
+	}
+	sort.Strings(permissions)
+	m.roles[role.ID] = &Role{ID: role.ID, Name: role.Name, Description: role.Description, Permissions: permissions}
+	return nil
+}
+
+func (m *Memory) GetUserRoles(ctx context.Context, userID string) ([]string, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var roles, permissions []string
+	seenPermissions := map[string]bool{}
+	for roleID := range m.userRoles[userID] {
+		role, ok := m.roles[roleID]
+		if !ok {
+			continue
+		}
+		roles = append(roles, role.Name)
+		for _, permission := range role.Permissions {
+			if !seenPermissions[permission] {
+				seenPermissions[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	sort.Strings(roles)
+	sort.Strings(permissions)
+	return roles, permissions, nil
+}
+
+func (m *Memory) AssignRole(ctx context.Context, userID, roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.userRoles[userID] == nil {
+		m.userRoles[userID] = make(map[string]bool)
+	}
+	m.userRoles[userID][roleID] = true
+	return nil
+}
+
+func (m *Memory) UnassignRole(ctx context.Context, userID, roleID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.userRoles[userID], roleID)
+	return nil
+}
+
+// copyClient returns a copy of client that does not share its lists.
+func copyClient(client *Client) *Client {
+	c := *client
+	c.RedirectURIs = append([]string(nil), client.RedirectURIs...)
+	c.Scopes = append([]string(nil), client.Scopes...)
+	c.GrantTypes = append([]string(nil), client.GrantTypes...)
+	return &c
+}
+
+func (m *Memory) InsertClient(ctx context.Context, client *Client) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.clients[client.ID]; ok {
+		return errDuplicateKey
+	}
+	m.clients[client.ID] = copyClient(client)
+	return nil
+}
+
+func (m *Memory) GetClientByID(ctx context.Context, id string) (*Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client, ok := m.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyClient(client), nil
+}
+
+func (m *Memory) ListClients(ctx context.Context) ([]Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var clients []Client
+	for _, client := range m.clients {
+		clients = append(clients, *copyClient(client))
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+	return clients, nil
+}
+
+func (m *Memory) DeleteClient(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.clients, id)
+	for hash, code := range m.authorizationCodes {
+		if code.ClientID == id {
+			delete(m.authorizationCodes, hash)
+		}
+	}
+	for assertion := range m.clientAssertions {
+		if assertion.clientID == id {
+			delete(m.clientAssertions, assertion)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) InsertAuthorizationCode(ctx context.Context, code *AuthorizationCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.authorizationCodes[code.CodeHash]; ok {
+		return errDuplicateKey
+	}
+	stored := *code
+	stored.UsedAt = nil
+	m.authorizationCodes[code.CodeHash] = &stored
+	return nil
+}
+
+func (m *Memory) ConsumeAuthorizationCode(ctx context.Context, hash, familyID string) (*AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.authorizationCodes[hash]
+	if !ok {
+		return nil, nil
+	}
+	if stored.UsedAt != nil {
+		code := *stored
+		return &code, ErrAuthorizationCodeUsed
+	}
+	now := time.Now()
+	stored.UsedAt, stored.FamilyID = &now, familyID
+	code := *stored
+	if code.AuthTime.IsZero() {
+		code.AuthTime = code.CreatedAt
+	}
+	return &code, nil
+}
+
+func (m *Memory) UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	assertion := clientAssertion{clientID: clientID, jti: jti}
+	if _, ok := m.clientAssertions[assertion]; ok {
+		return ErrClientAssertionReused
+	}
+	m.clientAssertions[assertion] = expiresAt
+	return nil
+}
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type userRevocation struct {
+	revokedBefore time.Time
+	expiresAt     time.Time
+}
+
+func (m *Memory) InsertRefreshToken(ctx context.Context, rt *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertRefreshToken(rt)
+}
+
+func (m *Memory) insertRefreshToken(rt *RefreshToken) error {
+	if _, ok := m.refreshTokens[rt.ID]; ok {
+		return errDuplicateKey
+	}
+	for _, stored := range m.refreshTokens {
+		if stored.TokenHash == rt.TokenHash {
+			return errDuplicateKey
+		}
+	}
+	stored := *rt
+	stored.UsedAt, stored.RevokedAt = nil, nil
+	m.refreshTokens[rt.ID] = &stored
+	return nil
+}
+
+func (m *Memory) GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.refreshTokens {
+		if stored.TokenHash == hash {
+			rt := *stored
+			return &rt, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *Memory) RotateRefreshToken(ctx context.Context, usedID string, next *RefreshToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	used, ok := m.refreshTokens[usedID]
+	if !ok || used.UsedAt != nil {
+		return ErrRefreshTokenReused
+	}
+	if err := m.insertRefreshToken(next); err != nil {
+		return err
+	}
+	usedAt := next.CreatedAt
+	used.UsedAt = &usedAt
+	return nil
+}
+
+func (m *Memory) ListSessions(ctx context.Context, userID string) ([]RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []RefreshToken
+	now := time.Now()
+	for _, rt := range m.refreshTokens {
+		if rt.UserID == userID && rt.UsedAt == nil && rt.RevokedAt == nil && rt.ExpiresAt.After(now) {
+			sessions = append(sessions, *rt)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.After(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+func (m *Memory) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, rt := range m.refreshTokens {
+		if rt.FamilyID == familyID && rt.RevokedAt == nil {
+			rt.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *Memory) RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.revokedTokens[jti]; !ok {
+		m.revokedTokens[jti] = expiresAt
+	}
+	return nil
+}
+
+func (m *Memory) RevokeAllUserTokens(ctx context.Context, userID string, before, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.userRevocations[userID] = userRevocation{revokedBefore: before, expiresAt: expiresAt}
+	for _, rt := range m.refreshTokens {
+		if rt.UserID == userID && rt.RevokedAt == nil {
+			revokedAt := before
+			rt.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+func (m *Memory) IsTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.revokedTokens[jti]; ok {
+		return true, nil
+	}
+	if userID == "" {
+		return false, nil
+	}
+	if _, ok := m.users[userID]; !ok {
+		return true, nil
+	}
+	revocation, ok := m.userRevocations[userID]
+	return ok && revocation.revokedBefore.After(issuedAt), nil
+}
+
+func (m *Memory) DeleteExpired(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for jti, expiresAt := range m.revokedTokens {
+		if expiresAt.Before(now) {
+			delete(m.revokedTokens, jti)
+			deleted++
+		}
+	}
+	for userID, revocation := range m.userRevocations {
+		if revocation.expiresAt.Before(now) {
+			delete(m.userRevocations, userID)
+			deleted++
+		}
+	}
+	for assertion, expiresAt := range m.clientAssertions {
+		if expiresAt.Before(now) {
+			delete(m.clientAssertions, assertion)
+			deleted++
+		}
+	}
+	for hash, challenge := range m.mfaChallenges {
+		if challenge.ExpiresAt.Before(now) {
+			delete(m.mfaChallenges, hash)
+			deleted++
+		}
+	}
+	for hash, challenge := range m.webAuthnChallenges {
+		if challenge.ExpiresAt.Before(now) {
+			delete(m.webAuthnChallenges, hash)
+			deleted++
+		}
+	}
+	for hash, rt := range m.passwordResetTokens {
+		if rt.ExpiresAt.Before(now) {
+			delete(m.passwordResetTokens, hash)
+			deleted++
+		}
+	}
+	for subject, lf := range m.loginFailures {
+		if lf.ExpiresAt.Before(now) {
+			delete(m.loginFailures, subject)
+			deleted++
+		}
+	}
+	for key, b := range m.rateLimitBuckets {
+		if b.ExpiresAt.Before(now) {
+			delete(m.rateLimitBuckets, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memory) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []SigningKey
+	now := time.Now()
+	for _, key := range m.signingKeys {
+		if key.ExpiresAt == nil || key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (m *Memory) RotateSigningKey(ctx context.Context, next *SigningKey, previousID string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var active *SigningKey
+	for i := range m.signingKeys {
+		key := &m.signingKeys[i]
+		if key.RetiredAt == nil && (active == nil || key.CreatedAt.After(active.CreatedAt)) {
+			active = key
+		}
+	}
+	activeID := ""
+	if active != nil {
+		activeID = active.ID
+	}
+	if activeID != previousID {
+		return ErrSigningKeyConflict
+	}
+
+	for i := range m.signingKeys {
+		key := &m.signingKeys[i]
+		if key.RetiredAt == nil {
+			retiredAt, keyExpiresAt := next.CreatedAt, expiresAt
+			key.RetiredAt, key.ExpiresAt = &retiredAt, &keyExpiresAt
+		}
+	}
+	m.signingKeys = append(m.signingKeys, SigningKey{
+		ID:         next.ID,
+		Algorithm:  next.Algorithm,
+		PrivateKey: next.PrivateKey,
+		CreatedAt:  next.CreatedAt,
+	})
+	return nil
+}
+
+func (m *Memory) InsertPasswordResetToken(ctx context.Context, rt *PasswordResetToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwordResetTokens[rt.TokenHash]; ok {
+		return errDuplicateKey
+	}
+	stored := *rt
+	m.passwordResetTokens[rt.TokenHash] = &stored
+	return nil
+}
+
+func (m *Memory) GetPasswordResetToken(ctx context.Context, hash string) (*PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.passwordResetTokens[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rt := *stored
+	return &rt, nil
+}
+
+func (m *Memory) ConsumePasswordResetToken(ctx context.Context, hash string) (*PasswordResetToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.passwordResetTokens[hash]
+	if !ok {
+		return nil, nil
+	}
+	delete(m.passwordResetTokens, hash)
+	return stored, nil
+}
+
+func (m *Memory) ResetPassword(ctx context.Context, userID, passwordHash string, changedAt time.Time, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.changePassword(userID, passwordHash, changedAt, keep)
+	for hash, rt := range m.passwordResetTokens {
+		if rt.UserID == userID {
+			delete(m.passwordResetTokens, hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrTOTPCodeReused is returned when a TOTP code, or one older than the last
+// code accepted, is presented again.
+var ErrTOTPCodeReused = errors.New("TOTP code has already been used")
+
+// TOTP is the authenticator app secret of a user. It only protects logins
+// once EnabledAt is set, after the user confirmed it with a first code.
+type TOTP struct {
+	UserID       string
+	Secret       string
+	CreatedAt    time.Time
+	EnabledAt    *time.Time
+	LastUsedStep int64
+}
+
+type MFAChallenge struct {
+	TokenHash string
+	UserID    string
+	Audience  string
+	Attempts  int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// GetTOTP returns the TOTP secret of userID, or ErrNotFound if the user has
+// none.
+func (db *DB) GetTOTP(ctx context.Context, userID string) (*TOTP, error) {
+	totp := &TOTP{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var enabledAt sql.NullTime
+		row := tx.QueryRowContext(ctx, `SELECT user_id, secret, created_at, enabled_at, last_used_step
+			FROM user_totp WHERE user_id = $1`, userID)
+		err := row.Scan(&totp.UserID, &totp.Secret, &totp.CreatedAt, &enabledAt, &totp.LastUsedStep)
+		if err != nil {
+			return err
+		}
+		totp.EnabledAt = nullTime(enabledAt)
+		return nil
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+// SaveTOTPSecret stores a new, not yet enabled, secret for userID, replacing
+// any earlier one that was never confirmed. It reports false if the user
+// already has TOTP enabled.
+func (db *DB) SaveTOTPSecret(ctx context.Context, userID, secret string) (bool, error) {
+	var saved bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO user_totp (user_id, secret, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at
+			WHERE user_totp.enabled_at IS NULL`, userID, secret, time.Now())
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		saved = n == 1
+		return err
+	})
+	return saved, err
+}
+
+// EnableTOTP turns on the confirmed secret of userID, recording step as the
+// last code used, and replaces the user's recovery codes.
+func (db *DB) EnableTOTP(ctx context.Context, userID string, step int64, recoveryCodeHashes []string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE user_totp SET enabled_at = $1, last_used_step = $2 WHERE user_id = $3",
+			time.Now(), step, userID)
+		if err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+	return err
+}
+
+// UseTOTPStep records that the code of time step was used by userID. Codes
+// of that step or earlier ones are not accepted afterwards, and
+// ErrTOTPCodeReused is returned if one already was.
+func (db *DB) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE user_totp SET last_used_step = $1 WHERE user_id = $2 AND last_used_step < $1",
+			step, userID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrTOTPCodeReused
+		}
+		return nil
+	})
+	return err
+}
+
+// UseRecoveryCode consumes the recovery code of userID stored under hash. It
+// reports false if there is no such code or it was used before.
+func (db *DB) UseRecoveryCode(ctx context.Context, userID, hash string) (bool, error) {
+	var used bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `UPDATE mfa_recovery_codes SET used_at = $1
+			WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL`, time.Now(), userID, hash)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		used = n == 1
+		return err
+	})
+	return used, err
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of userID.
+func (db *DB) DisableTOTP(ctx context.Context, userID string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", userID)
+		return err
+	})
+	return err
+}
+
+func (db *DB) InsertMFAChallenge(ctx context.Context, challenge *MFAChallenge) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO mfa_challenges (token_hash, user_id, audience, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			challenge.TokenHash, challenge.UserID, challenge.Audience, challenge.CreatedAt, challenge.ExpiresAt)
+		return err
+	})
+	return err
+}
+
+// AttemptMFAChallenge counts an attempt to answer the challenge stored under
+// hash and returns it, including this attempt, or nil if there is no such
+// challenge.
+func (db *DB) AttemptMFAChallenge(ctx context.Context, hash string) (*MFAChallenge, error) {
+	challenge := &MFAChallenge{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `UPDATE mfa_challenges SET attempts = attempts + 1 WHERE token_hash = $1
+			RETURNING token_hash, user_id, audience, attempts, created_at, expires_at`, hash)
+		return row.Scan(&challenge.TokenHash, &challenge.UserID, &challenge.Audience, &challenge.Attempts,
+			&challenge.CreatedAt, &challenge.ExpiresAt)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (db *DB) DeleteMFAChallenge(ctx context.Context, hash string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE token_hash = $1", hash)
+		return err
+	})
+	return err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)", userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrAuthorizationCodeUsed is returned when an authorization code that has
+// already been exchanged is presented again.
+var ErrAuthorizationCodeUsed = errors.New("authorization code has already been used")
+
+// ErrClientAssertionReused is returned when a client assertion is presented a
+// second time.
+var ErrClientAssertionReused = errors.New("client assertion has already been used")
+
+// Client is a registered OAuth client. Confidential clients authenticate with
+// a secret, of which only SecretHash is stored, or by signing assertions with
+// one of the keys in JWKS. Public clients have neither.
+type Client struct {
+	ID           string
+	Name         string
+	SecretHash   string
+	JWKS         string
+	RedirectURIs []string
+	Scopes       []string
+	GrantTypes   []string
+	CreatedAt    time.Time
+}
+
+type AuthorizationCode struct {
+	CodeHash    string
+	ClientID    string
+	UserID      string
+	RedirectURI string
+	// RedirectURIGiven is set when the authorization request named
+	// RedirectURI rather than leaving it to the only one of the client.
+	RedirectURIGiven    bool
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	AuthTime            time.Time
+	CreatedAt           time.Time
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+	// FamilyID is the family of the refresh tokens issued for the code, set
+	// when it is consumed.
+	FamilyID string
+}
+
+const clientColumns = "id, name, secret_hash, jwks, redirect_uris, scopes, grant_types, created_at"
+
+func (db *DB) InsertClient(ctx context.Context, client *Client) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO oauth_clients (`+clientColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			client.ID, client.Name, nullString(client.SecretHash), nullString(client.JWKS),
+			strings.Join(client.RedirectURIs, " "), strings.Join(client.Scopes, " "),
+			strings.Join(client.GrantTypes, " "), client.CreatedAt)
+		return err
+	})
+	return err
+}
+
+// GetClientByID returns the client registered under id, or ErrNotFound if
+// there is no such client.
+func (db *DB) GetClientByID(ctx context.Context, id string) (*Client, error) {
+	var client *Client
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		client, err = scanClient(tx.QueryRowContext(ctx, "SELECT "+clientColumns+" FROM oauth_clients WHERE id = $1", id))
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// ListClients returns every registered client, oldest first.
+func (db *DB) ListClients(ctx context.Context) ([]Client, error) {
+	var clients []Client
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		clients = nil
+		rows, err := tx.QueryContext(ctx, "SELECT "+clientColumns+" FROM oauth_clients ORDER BY created_at")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			client, err := scanClient(rows)
+			if err != nil {
+				return err
+			}
+			clients = append(clients, *client)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (db *DB) DeleteClient(ctx context.Context, id string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM oauth_clients WHERE id = $1", id)
+		return err
+	})
+	return err
+}
+
+func (db *DB) InsertAuthorizationCode(ctx context.Context, code *AuthorizationCode) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO oauth_authorization_codes
+			(code_hash, client_id, user_id, redirect_uri, redirect_uri_given, scope, code_challenge, code_challenge_method, nonce,
+			auth_time, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+			code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.RedirectURIGiven, code.Scope,
+			code.CodeChallenge, code.CodeChallengeMethod, code.Nonce, code.AuthTime, code.CreatedAt, code.ExpiresAt)
+		return err
+	})
+	return err
+}
+
+// ConsumeAuthorizationCode marks the authorization code stored under hash as
+// used by the refresh token family familyID and returns it, or nil if there
+// is no such code. Codes can be consumed once, ErrAuthorizationCodeUsed is
+// returned on every further attempt, along with the code and the family it
+// was used by, so that the tokens issued for it can be revoked.
+func (db *DB) ConsumeAuthorizationCode(ctx context.Context, hash, familyID string) (*AuthorizationCode, error) {
+	code := &AuthorizationCode{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var authTime, usedAt sql.NullTime
+		var usedBy sql.NullString
+		row := tx.QueryRowContext(ctx, `SELECT code_hash, client_id, user_id, redirect_uri, redirect_uri_given, scope, code_challenge,
+			code_challenge_method, nonce, auth_time, created_at, expires_at, used_at, family_id
+			FROM oauth_authorization_codes WHERE code_hash = $1`, hash)
+		err := row.Scan(&code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI, &code.RedirectURIGiven, &code.Scope,
+			&code.CodeChallenge, &code.CodeChallengeMethod, &code.Nonce, &authTime, &code.CreatedAt, &code.ExpiresAt, &usedAt, &usedBy)
+		if err != nil {
+			return err
+		}
+		// Codes issued before OpenID Connect support have no auth_time.
+		code.AuthTime = authTime.Time
+		if !authTime.Valid {
+			code.AuthTime = code.CreatedAt
+		}
+		if usedAt.Valid {
+			code.UsedAt, code.FamilyID = &usedAt.Time, usedBy.String
+			return ErrAuthorizationCodeUsed
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE oauth_authorization_codes SET used_at = $1, family_id = $2 WHERE code_hash = $3",
+			time.Now(), familyID, hash)
+		code.FamilyID = familyID
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if errors.Is(err, ErrAuthorizationCodeUsed) {
+		return code, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return code, nil
+}
+
+// UseClientAssertion records that the assertion jti of clientID has been
+// used, returning ErrClientAssertionReused if it already was. The record is
+// kept until expiresAt, when the assertion is no longer accepted anyway.
+func (db *DB) UseClientAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO oauth_client_assertions (client_id, jti, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (client_id, jti) DO NOTHING`, clientID, jti, expiresAt)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrClientAssertionReused
+		}
+		return nil
+	})
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClient(row rowScanner) (*Client, error) {
+	client := &Client{}
+	var secretHash, jwks sql.NullString
+	var redirectURIs, scopes, grantTypes string
+	err := row.Scan(&client.ID, &client.Name, &secretHash, &jwks, &redirectURIs, &scopes, &grantTypes, &client.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	client.SecretHash = secretHash.String
+	client.JWKS = jwks.String
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Scopes = strings.Fields(scopes)
+	client.GrantTypes = strings.Fields(grantTypes)
+	return client, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// GetPasswordHistory returns the hashes of the passwords userID had before
+// the current one, the most recent first.
+func (db *DB) GetPasswordHistory(ctx context.Context, userID string) ([]string, error) {
+	var hashes []string
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		hashes = nil
+		rows, err := tx.QueryContext(ctx, `SELECT password_hash FROM password_history
+			WHERE user_id = $1 ORDER BY created_at DESC`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				return err
+			}
+			hashes = append(hashes, hash)
+		}
+		return rows.Err()
+	})
+	return hashes, err
+}
+
+// changePassword replaces the password of userID with passwordHash, set at
+// changedAt. The replaced hash joins the history, of which the keep most
+// recent entries are kept.
+func changePassword(ctx context.Context, tx *sql.Tx, userID, passwordHash string, changedAt time.Time, keep int) error {
+	if keep > 0 {
+		_, err := tx.ExecContext(ctx, `INSERT INTO password_history (user_id, password_hash, created_at)
+			SELECT id, password, $2 FROM users WHERE id = $1`, userID, changedAt)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.ExecContext(ctx, `DELETE FROM password_history WHERE user_id = $1 AND created_at <= (
+			SELECT created_at FROM password_history WHERE user_id = $1
+			ORDER BY created_at DESC LIMIT 1 OFFSET $2
+		)`, userID, keep)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET password = $1, password_changed_at = $2, password_breached_at = NULL,
+			password_change_required_at = NULL
+		WHERE id = $3`, passwordHash, changedAt, userID)
+	return err
+}
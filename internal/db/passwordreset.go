@@ -0,0 +1,73 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type PasswordResetToken struct {
+	TokenHash string
+	UserID    string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+func (db *DB) InsertPasswordResetToken(ctx context.Context, rt *PasswordResetToken) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO password_reset_tokens (token_hash, user_id, created_at, expires_at)
+			VALUES ($1, $2, $3, $4)`, rt.TokenHash, rt.UserID, rt.CreatedAt, rt.ExpiresAt)
+		return err
+	})
+	return err
+}
+
+// GetPasswordResetToken returns the reset token stored under hash without
+// using it up, or ErrNotFound if there is no such token.
+func (db *DB) GetPasswordResetToken(ctx context.Context, hash string) (*PasswordResetToken, error) {
+	rt := &PasswordResetToken{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `SELECT token_hash, user_id, created_at, expires_at
+			FROM password_reset_tokens WHERE token_hash = $1`, hash)
+		return row.Scan(&rt.TokenHash, &rt.UserID, &rt.CreatedAt, &rt.ExpiresAt)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// ConsumePasswordResetToken deletes and returns the reset token stored under
+// hash, or nil if there is no such token.
+func (db *DB) ConsumePasswordResetToken(ctx context.Context, hash string) (*PasswordResetToken, error) {
+	rt := &PasswordResetToken{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `DELETE FROM password_reset_tokens WHERE token_hash = $1
+			RETURNING token_hash, user_id, created_at, expires_at`, hash)
+		return row.Scan(&rt.TokenHash, &rt.UserID, &rt.CreatedAt, &rt.ExpiresAt)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// ResetPassword replaces the password of userID like UpdatePassword and
+// deletes any other reset tokens the user asked for.
+func (db *DB) ResetPassword(ctx context.Context, userID, passwordHash string, changedAt time.Time, keep int) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if err := changePassword(ctx, tx, userID, passwordHash, changedAt, keep); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM password_reset_tokens WHERE user_id = $1", userID)
+		return err
+	})
+	return err
+}
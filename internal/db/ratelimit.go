@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// RateLimitBucket is the token bucket of a rate limit key. A bucket is full
+// again by ExpiresAt, and is then no different from one that does not exist.
+type RateLimitBucket struct {
+	Key       string
+	Tokens    float64
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// UpdateRateLimitBucket lets update change the bucket for key and saves it,
+// in one transaction so that concurrent requests are counted one after the
+// other. Missing and expired buckets are passed to update with a zero
+// UpdatedAt. update may be called more than once when the transaction is
+// retried.
+func (db *DB) UpdateRateLimitBucket(ctx context.Context, key string, update func(b *RateLimitBucket)) error {
+	return db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		b := &RateLimitBucket{}
+		row := tx.QueryRowContext(ctx, `SELECT id, tokens, updated_at, expires_at FROM rate_limit_buckets
+			WHERE id = $1 AND expires_at >= $2`+db.forUpdate, key, time.Now())
+		err := row.Scan(&b.Key, &b.Tokens, &b.UpdatedAt, &b.ExpiresAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			b = &RateLimitBucket{}
+		} else if err != nil {
+			return err
+		}
+
+		b.Key = key
+		update(b)
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO rate_limit_buckets (id, tokens, updated_at, expires_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (id) DO UPDATE SET tokens = excluded.tokens, updated_at = excluded.updated_at,
+				expires_at = excluded.expires_at`, b.Key, b.Tokens, b.UpdatedAt, b.ExpiresAt)
+		return err
+	})
+}
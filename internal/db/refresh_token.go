@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that has already
+// been exchanged is presented again.
+var ErrRefreshTokenReused = errors.New("refresh token has already been used")
+
+type RefreshToken struct {
+	ID        string
+	UserID    string
+	FamilyID  string
+	TokenHash string
+	Audience  string
+	ClientID  string
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+func (db *DB) InsertRefreshToken(ctx context.Context, rt *RefreshToken) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		return insertRefreshToken(ctx, tx, rt)
+	})
+	return err
+}
+
+// GetRefreshTokenByHash returns the refresh token stored under hash, or
+// ErrNotFound if there is no such token.
+func (db *DB) GetRefreshTokenByHash(ctx context.Context, hash string) (*RefreshToken, error) {
+	rt := &RefreshToken{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var usedAt, revokedAt sql.NullTime
+		row := tx.QueryRowContext(ctx, `SELECT id, user_id, family_id, token_hash, audience, client_id, scope, created_at, expires_at, used_at, revoked_at
+			FROM refresh_tokens WHERE token_hash = $1`, hash)
+		err := row.Scan(&rt.ID, &rt.UserID, &rt.FamilyID, &rt.TokenHash, &rt.Audience, &rt.ClientID, &rt.Scope,
+			&rt.CreatedAt, &rt.ExpiresAt, &usedAt, &revokedAt)
+		if err != nil {
+			return err
+		}
+		rt.UsedAt = nullTime(usedAt)
+		rt.RevokedAt = nullTime(revokedAt)
+		return nil
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// RotateRefreshToken marks the refresh token identified by usedID as used and
+// stores next in its place. Both happen in a single transaction, and
+// ErrRefreshTokenReused is returned if usedID had already been consumed.
+func (db *DB) RotateRefreshToken(ctx context.Context, usedID string, next *RefreshToken) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL", next.CreatedAt, usedID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrRefreshTokenReused
+		}
+		return insertRefreshToken(ctx, tx, next)
+	})
+	return err
+}
+
+// ListSessions returns, for each login of userID that can still be
+// refreshed, the refresh token it is to be refreshed with, most recently
+// refreshed first.
+func (db *DB) ListSessions(ctx context.Context, userID string) ([]RefreshToken, error) {
+	var sessions []RefreshToken
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		sessions = nil
+		rows, err := tx.QueryContext(ctx, `SELECT id, user_id, family_id, token_hash, audience, client_id, scope, created_at, expires_at
+			FROM refresh_tokens
+			WHERE user_id = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2
+			ORDER BY created_at DESC`, userID, time.Now())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var rt RefreshToken
+			err := rows.Scan(&rt.ID, &rt.UserID, &rt.FamilyID, &rt.TokenHash, &rt.Audience, &rt.ClientID, &rt.Scope,
+				&rt.CreatedAt, &rt.ExpiresAt)
+			if err != nil {
+				return err
+			}
+			sessions = append(sessions, rt)
+		}
+		return rows.Err()
+	})
+	return sessions, err
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descending from the
+// same login.
+func (db *DB) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL", time.Now(), familyID)
+		return err
+	})
+	return err
+}
+
+func insertRefreshToken(ctx context.Context, tx *sql.Tx, rt *RefreshToken) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO refresh_tokens (id, user_id, family_id, token_hash, audience, client_id, scope, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		rt.ID, rt.UserID, rt.FamilyID, rt.TokenHash, rt.Audience, rt.ClientID, rt.Scope, rt.CreatedAt, rt.ExpiresAt)
+	return err
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
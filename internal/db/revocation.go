@@ -0,0 +1,95 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// RevokeToken adds the access token identified by jti to the denylist until
+// it expires. userID is empty for tokens issued to clients.
+func (db *DB) RevokeToken(ctx context.Context, jti, userID string, expiresAt time.Time) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO revoked_tokens (jti, user_id, revoked_at, expires_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (jti) DO NOTHING`, jti, nullString(userID), time.Now(), expiresAt)
+		return err
+	})
+	return err
+}
+
+// RevokeAllUserTokens revokes every access token of userID issued before
+// before, along with all of the user's refresh tokens. expiresAt must be no
+// earlier than the expiry of the last access token covered.
+func (db *DB) RevokeAllUserTokens(ctx context.Context, userID string, before, expiresAt time.Time) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_token_revocations (user_id, revoked_before, expires_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET revoked_before = excluded.revoked_before, expires_at = excluded.expires_at`,
+			userID, before, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", before, userID)
+		return err
+	})
+	return err
+}
+
+// IsTokenRevoked implements token.Denylist. Tokens of users that have been
+// deleted count as revoked. Tokens issued to clients have no userID and can
+// only be revoked individually.
+func (db *DB) IsTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		if userID == "" {
+			return tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)", jti).Scan(&revoked)
+		}
+		row := tx.QueryRowContext(ctx, `SELECT
+			EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = $2 AND revoked_before > $3)
+			OR NOT EXISTS (SELECT 1 FROM users WHERE id = $2)`,
+			jti, userID, issuedAt)
+		return row.Scan(&revoked)
+	})
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
+
+// DeleteExpired removes the rows that no longer serve a purpose: denylist
+// entries, including used client assertions, for tokens that have expired
+// anyway, expired MFA and WebAuthn challenges, password reset tokens, failed
+// login counts and rate limit buckets. It returns how many were deleted.
+func (db *DB) DeleteExpired(ctx context.Context) (int64, error) {
+	var deleted int64
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		deleted = 0
+		now := time.Now()
+		for _, query := range []string{
+			"DELETE FROM revoked_tokens WHERE expires_at < $1",
+			"DELETE FROM user_token_revocations WHERE expires_at < $1",
+			"DELETE FROM oauth_client_assertions WHERE expires_at < $1",
+			"DELETE FROM mfa_challenges WHERE expires_at < $1",
+			"DELETE FROM webauthn_challenges WHERE expires_at < $1",
+			"DELETE FROM password_reset_tokens WHERE expires_at < $1",
+			"DELETE FROM login_failures WHERE expires_at < $1",
+			"DELETE FROM rate_limit_buckets WHERE expires_at < $1",
+		} {
+			res, err := tx.ExecContext(ctx, query, now)
+			if err != nil {
+				return err
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			deleted += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+type Role struct {
+	ID          string
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// ListRoles returns every role along with its permissions, ordered by name.
+func (db *DB) ListRoles(ctx context.Context) ([]Role, error) {
+	var roles []Role
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		roles = nil
+		rows, err := tx.QueryContext(ctx, `SELECT r.id, r.name, r.description, p.name
+			FROM roles r
+			LEFT JOIN role_permissions rp ON rp.role_id = r.id
+			LEFT JOIN permissions p ON p.id = rp.permission_id
+			ORDER BY r.name, p.name`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var role Role
+			var permission sql.NullString
+			if err := rows.Scan(&role.ID, &role.Name, &role.Description, &permission); err != nil {
+				return err
+			}
+			if len(roles) == 0 || roles[len(roles)-1].ID != role.ID {
+				roles = append(roles, role)
+			}
+			if permission.Valid {
+				last := &roles[len(roles)-1]
+				last.Permissions = append(last.Permissions, permission.String)
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// GetRoleByName returns the role called name, or ErrNotFound if there is
+// none.
+func (db *DB) GetRoleByName(ctx context.Context, name string) (*Role, error) {
+	roles, err := db.ListRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range roles {
+		if roles[i].Name == name {
+			return &roles[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// SaveRole creates the role or updates the description of the existing role
+// with the same name, and replaces its permissions with role.Permissions.
+// Permissions that do not exist yet are created.
+func (db *DB) SaveRole(ctx context.Context, role *Role) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT id FROM roles WHERE name = $1", role.Name).Scan(&role.ID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			role.ID = uuid.New().String()
+			_, err = tx.ExecContext(ctx, "INSERT INTO roles (id, name, description) VALUES ($1, $2, $3)", role.ID, role.Name, role.Description)
+		case err == nil:
+			_, err = tx.ExecContext(ctx, "UPDATE roles SET description = $1 WHERE id = $2", role.Description, role.ID)
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE role_id = $1", role.ID); err != nil {
+			return err
+		}
+
+		for _, name := range role.Permissions {
+			_, err := tx.ExecContext(ctx, "INSERT INTO permissions (id, name) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING", uuid.New().String(), name)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO role_permissions (role_id, permission_id)
+				SELECT $1, id FROM permissions WHERE name = $2 ON CONFLICT DO NOTHING`, role.ID, name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// GetUserRoles returns the names of the roles assigned to userID and the
+// union of their permissions, both sorted.
+func (db *DB) GetUserRoles(ctx context.Context, userID string) ([]string, []string, error) {
+	var roles, permissions []string
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		roles, permissions = nil, nil
+		rows, err := tx.QueryContext(ctx, `SELECT r.name, p.name
+			FROM user_roles ur
+			JOIN roles r ON r.id = ur.role_id
+			LEFT JOIN role_permissions rp ON rp.role_id = r.id
+			LEFT JOIN permissions p ON p.id = rp.permission_id
+			WHERE ur.user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		seenRoles := map[string]bool{}
+		seenPermissions := map[string]bool{}
+		for rows.Next() {
+			var role string
+			var permission sql.NullString
+			if err := rows.Scan(&role, &permission); err != nil {
+				return err
+			}
+			if !seenRoles[role] {
+				seenRoles[role] = true
+				roles = append(roles, role)
+			}
+			if permission.Valid && !seenPermissions[permission.String] {
+				seenPermissions[permission.String] = true
+				permissions = append(permissions, permission.String)
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(roles)
+	sort.Strings(permissions)
+	return roles, permissions, nil
+}
+
+func (db *DB) AssignRole(ctx context.Context, userID, roleID string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO user_roles (user_id, role_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, roleID)
+		return err
+	})
+	return err
+}
+
+func (db *DB) UnassignRole(ctx context.Context, userID, roleID string) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2", userID, roleID)
+		return err
+	})
+	return err
+}
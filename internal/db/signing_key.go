@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrSigningKeyConflict is returned by RotateSigningKey when the active key
+// changed in the meantime, typically because another replica rotated first.
+var ErrSigningKeyConflict = errors.New("active signing key has changed")
+
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+	CreatedAt  time.Time
+	RetiredAt  *time.Time
+	ExpiresAt  *time.Time
+}
+
+// ListSigningKeys returns every signing key that can still verify tokens,
+// newest first.
+func (db *DB) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		keys = nil
+		rows, err := tx.QueryContext(ctx, `SELECT id, algorithm, private_key, created_at, retired_at, expires_at
+			FROM signing_keys WHERE expires_at IS NULL OR expires_at > $1 ORDER BY created_at DESC`, time.Now())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key SigningKey
+			var retiredAt, expiresAt sql.NullTime
+			if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.CreatedAt, &retiredAt, &expiresAt); err != nil {
+				return err
+			}
+			key.RetiredAt = nullTime(retiredAt)
+			key.ExpiresAt = nullTime(expiresAt)
+			keys = append(keys, key)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// RotateSigningKey retires the active key, which must be previousID (or none
+// when previousID is empty), so that it only verifies tokens until expiresAt,
+// and makes next the active key.
+func (db *DB) RotateSigningKey(ctx context.Context, next *SigningKey, previousID string, expiresAt time.Time) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var activeID string
+		row := tx.QueryRowContext(ctx, "SELECT id FROM signing_keys WHERE retired_at IS NULL ORDER BY created_at DESC LIMIT 1")
+		if err := row.Scan(&activeID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if activeID != previousID {
+			return ErrSigningKeyConflict
+		}
+
+		_, err := tx.ExecContext(ctx, "UPDATE signing_keys SET retired_at = $1, expires_at = $2 WHERE retired_at IS NULL", next.CreatedAt, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO signing_keys (id, algorithm, private_key, created_at) VALUES ($1, $2, $3, $4)`,
+			next.ID, next.Algorithm, next.PrivateKey, next.CreatedAt)
+		return err
+	})
+	return err
+}
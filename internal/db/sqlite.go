@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+//go:embed sqlite.sql
+var sqliteSchema string
+
+// sqliteTimeFormat writes times in UTC with a fixed number of digits, so
+// that SQLite, which compares them as text, puts them in the right order.
+// The driver reads it back for TIMESTAMP columns.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// NewSQLite opens the SQLite database in the file at path, creating it and
+// its schema if needed. Transactions are run one at a time, over a single
+// connection, each given up after queryTimeout unless it is zero.
+func NewSQLite(path string, queryTimeout time.Duration) (*DB, error) {
+	db := sql.OpenDB(sqliteConnector{dsn: "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"})
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+
+	return &DB{db: db, queryTimeout: queryTimeout}, nil
+}
+
+// sqliteConnector opens connections of the SQLite driver that store times in
+// sqliteTimeFormat.
+type sqliteConnector struct {
+	dsn string
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.Driver().Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteConn{conn.(sqliteDriverConn)}, nil
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return &sqlite.Driver{}
+}
+
+// sqliteDriverConn is what the SQLite driver implements of a connection.
+type sqliteDriverConn interface {
+	driver.Conn
+	driver.Execer
+	driver.Queryer
+}
+
+type sqliteConn struct {
+	sqliteDriverConn
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, converting times to
+// text after the default conversion, which also dereferences pointers.
+func (c sqliteConn) CheckNamedValue(v *driver.NamedValue) error {
+	value, err := driver.DefaultParameterConverter.ConvertValue(v.Value)
+	if err != nil {
+		return err
+	}
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC().Format(sqliteTimeFormat)
+	}
+	v.Value = value
+	return nil
+}
@@ -0,0 +1,221 @@
+-- Schema of the sqlite backend, matching the one the migrations create in
+-- CockroachDB. Tables are only created when missing, so changes to existing
+-- tables need statements of their own here.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE,
+	email_verified_at TIMESTAMP,
+	password_breached_at TIMESTAMP,
+	password_changed_at TIMESTAMP,
+	disabled_at TIMESTAMP,
+	password_change_required_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS password_history (
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	password_hash TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, created_at)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	family_id TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	audience TEXT NOT NULL DEFAULT '',
+	client_id TEXT NOT NULL DEFAULT '',
+	scope TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	revoked_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	user_id TEXT,
+	revoked_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+	user_id TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	revoked_before TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS signing_keys (
+	id TEXT PRIMARY KEY,
+	algorithm TEXT NOT NULL,
+	private_key TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	retired_at TIMESTAMP,
+	expires_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role_id TEXT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+	permission_id TEXT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+	PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	role_id TEXT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+	PRIMARY KEY (user_id, role_id)
+);
+
+INSERT INTO roles (id, name, description)
+VALUES ('00000000-0000-0000-0000-000000000001', 'admin', 'Manages users, roles and clients')
+ON CONFLICT DO NOTHING;
+INSERT INTO permissions (id, name) VALUES ('00000000-0000-0000-0000-000000000001', 'admin') ON CONFLICT DO NOTHING;
+INSERT INTO role_permissions (role_id, permission_id)
+VALUES ('00000000-0000-0000-0000-000000000001', '00000000-0000-0000-0000-000000000001')
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	secret_hash TEXT,
+	jwks TEXT,
+	redirect_uris TEXT NOT NULL,
+	scopes TEXT NOT NULL DEFAULT '',
+	grant_types TEXT NOT NULL DEFAULT 'authorization_code',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+	code_hash TEXT PRIMARY KEY,
+	client_id TEXT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	redirect_uri TEXT NOT NULL,
+	redirect_uri_given BOOLEAN NOT NULL DEFAULT FALSE,
+	scope TEXT NOT NULL,
+	code_challenge TEXT NOT NULL,
+	code_challenge_method TEXT NOT NULL,
+	nonce TEXT NOT NULL DEFAULT '',
+	auth_time TIMESTAMP,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	family_id TEXT
+);
+
+CREATE TABLE IF NOT EXISTS oauth_client_assertions (
+	client_id TEXT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+	jti TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (client_id, jti)
+);
+
+CREATE TABLE IF NOT EXISTS user_totp (
+	user_id TEXT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+	secret TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	enabled_at TIMESTAMP,
+	last_used_step INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMP,
+	PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	audience TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	sign_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges (
+	challenge_hash TEXT PRIMARY KEY,
+	ceremony TEXT NOT NULL,
+	user_id TEXT REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
+
+CREATE TABLE IF NOT EXISTS login_failures (
+	scope TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	failures INTEGER NOT NULL,
+	last_failed_at TIMESTAMP NOT NULL,
+	locked_until TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (scope, subject)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+	id TEXT PRIMARY KEY,
+	tokens REAL NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+	seq INTEGER PRIMARY KEY,
+	occurred_at TIMESTAMP NOT NULL,
+	type TEXT NOT NULL,
+	actor_id TEXT NOT NULL DEFAULT '',
+	target_id TEXT NOT NULL DEFAULT '',
+	client_id TEXT NOT NULL DEFAULT '',
+	username TEXT NOT NULL DEFAULT '',
+	ip TEXT NOT NULL DEFAULT '',
+	user_agent TEXT NOT NULL DEFAULT '',
+	outcome TEXT NOT NULL,
+	reason TEXT NOT NULL DEFAULT '',
+	detail TEXT NOT NULL DEFAULT '',
+	prev_hash TEXT NOT NULL,
+	hash TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+CREATE INDEX IF NOT EXISTS audit_events_target_id_idx ON audit_events (target_id);
+
+CREATE TABLE IF NOT EXISTS audit_chain (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	seq INTEGER NOT NULL,
+	hash TEXT NOT NULL
+);
+INSERT OR IGNORE INTO audit_chain (id, seq, hash) VALUES (1, 0, '');
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UserFilter selects the users ListUsers returns. Query matches part of the
+// username or email address, ignoring case. Disabled, when not nil, selects
+// the disabled or the enabled users. Users are ordered by username, starting
+// after After, and at most Limit of them are returned.
+type UserFilter struct {
+	Query    string
+	Disabled *bool
+	After    string
+	Limit    int
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ListUsers returns the users selected by filter.
+func (db *DB) ListUsers(ctx context.Context, filter UserFilter) ([]User, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Query != "" {
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(filter.Query))+"%")
+		conditions = append(conditions, fmt.Sprintf(`(lower(username) LIKE $%d ESCAPE '\' OR lower(email) LIKE $%[1]d ESCAPE '\')`, len(args)))
+	}
+	if filter.Disabled != nil {
+		if *filter.Disabled {
+			conditions = append(conditions, "disabled_at IS NOT NULL")
+		} else {
+			conditions = append(conditions, "disabled_at IS NULL")
+		}
+	}
+	if filter.After != "" {
+		args = append(args, filter.After)
+		conditions = append(conditions, fmt.Sprintf("username > $%d", len(args)))
+	}
+	query := "SELECT " + userColumns + " FROM users"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY username LIMIT $%d", len(args))
+
+	var users []User
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		users = nil
+		rows, err := tx.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			user, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			users = append(users, *user)
+		}
+		return rows.Err()
+	})
+	return users, err
+}
+
+// DisableUser keeps user id from logging in from at on. Disabling a user
+// again keeps the time it was first disabled. It reports false if there is
+// no such user.
+func (db *DB) DisableUser(ctx context.Context, id string, at time.Time) (bool, error) {
+	return db.updateUser(ctx, "UPDATE users SET disabled_at = COALESCE(disabled_at, $2) WHERE id = $1", id, at)
+}
+
+// EnableUser lets user id log in again. It reports false if there is no such
+// user.
+func (db *DB) EnableUser(ctx context.Context, id string) (bool, error) {
+	return db.updateUser(ctx, "UPDATE users SET disabled_at = NULL WHERE id = $1", id)
+}
+
+// RequirePasswordChange requires user id to choose a new password before
+// logging in again. It reports false if there is no such user.
+func (db *DB) RequirePasswordChange(ctx context.Context, id string, at time.Time) (bool, error) {
+	return db.updateUser(ctx, "UPDATE users SET password_change_required_at = COALESCE(password_change_required_at, $2) WHERE id = $1", id, at)
+}
+
+// DeleteUser deletes user id along with everything stored for them. It
+// reports false if there is no such user.
+func (db *DB) DeleteUser(ctx context.Context, id string) (bool, error) {
+	return db.updateUser(ctx, "DELETE FROM users WHERE id = $1", id)
+}
+
+// updateUser runs query, which changes the user whose id is its first
+// argument, and reports whether there is such a user.
+func (db *DB) updateUser(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	var found bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		found = n == 1
+		return err
+	})
+	return found, err
+}
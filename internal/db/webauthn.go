@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrWebAuthnCredentialExists is returned when a credential that is already
+// registered is registered again.
+var ErrWebAuthnCredentialExists = errors.New("webauthn credential is already registered")
+
+// WebAuthnCredential is a passkey or security key registered by a user. ID
+// and PublicKey, the COSE encoded key, are base64url encoded.
+type WebAuthnCredential struct {
+	ID         string
+	UserID     string
+	Name       string
+	PublicKey  string
+	SignCount  int64
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// WebAuthnChallenge is the state of a ceremony in progress. UserID is empty
+// for passwordless logins, where the user is only known from the credential.
+type WebAuthnChallenge struct {
+	ChallengeHash string
+	Ceremony      string
+	UserID        string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+}
+
+const webAuthnCredentialColumns = "id, user_id, name, public_key, sign_count, created_at, last_used_at"
+
+func (db *DB) InsertWebAuthnChallenge(ctx context.Context, challenge *WebAuthnChallenge) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO webauthn_challenges (challenge_hash, ceremony, user_id, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)`,
+			challenge.ChallengeHash, challenge.Ceremony, nullString(challenge.UserID), challenge.CreatedAt, challenge.ExpiresAt)
+		return err
+	})
+	return err
+}
+
+// ConsumeWebAuthnChallenge deletes and returns the challenge stored under
+// hash, or nil if there is no such challenge.
+func (db *DB) ConsumeWebAuthnChallenge(ctx context.Context, hash string) (*WebAuthnChallenge, error) {
+	challenge := &WebAuthnChallenge{}
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var userID sql.NullString
+		row := tx.QueryRowContext(ctx, `DELETE FROM webauthn_challenges WHERE challenge_hash = $1
+			RETURNING challenge_hash, ceremony, user_id, created_at, expires_at`, hash)
+		err := row.Scan(&challenge.ChallengeHash, &challenge.Ceremony, &userID, &challenge.CreatedAt, &challenge.ExpiresAt)
+		challenge.UserID = userID.String
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func (db *DB) InsertWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `INSERT INTO webauthn_credentials (id, user_id, name, public_key, sign_count, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING`,
+			credential.ID, credential.UserID, credential.Name, credential.PublicKey, credential.SignCount, credential.CreatedAt)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrWebAuthnCredentialExists
+		}
+		return nil
+	})
+	return err
+}
+
+// GetWebAuthnCredential returns the credential registered under id, or
+// ErrNotFound if there is no such credential.
+func (db *DB) GetWebAuthnCredential(ctx context.Context, id string) (*WebAuthnCredential, error) {
+	var credential *WebAuthnCredential
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, "SELECT "+webAuthnCredentialColumns+" FROM webauthn_credentials WHERE id = $1", id)
+		credential, err = scanWebAuthnCredential(row)
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// ListWebAuthnCredentials returns the credentials of userID, oldest first.
+func (db *DB) ListWebAuthnCredentials(ctx context.Context, userID string) ([]WebAuthnCredential, error) {
+	var credentials []WebAuthnCredential
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		credentials = nil
+		rows, err := tx.QueryContext(ctx, "SELECT "+webAuthnCredentialColumns+" FROM webauthn_credentials WHERE user_id = $1 ORDER BY created_at", userID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			credential, err := scanWebAuthnCredential(rows)
+			if err != nil {
+				return err
+			}
+			credentials = append(credentials, *credential)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// UpdateWebAuthnSignCount records a use of the credential id, moving its
+// signature counter from previous to next. It reports false if the counter
+// was changed concurrently.
+func (db *DB) UpdateWebAuthnSignCount(ctx context.Context, id string, previous, next int64) (bool, error) {
+	var updated bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE webauthn_credentials SET sign_count = $1, last_used_at = $2 WHERE id = $3 AND sign_count = $4",
+			next, time.Now(), id, previous)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		updated = n == 1
+		return err
+	})
+	return updated, err
+}
+
+// DeleteWebAuthnCredential removes the credential id of userID. It reports
+// false if the user has no such credential.
+func (db *DB) DeleteWebAuthnCredential(ctx context.Context, userID, id string) (bool, error) {
+	var deleted bool
+	err := db.executeTx(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2", id, userID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		deleted = n == 1
+		return err
+	})
+	return deleted, err
+}
+
+func scanWebAuthnCredential(row rowScanner) (*WebAuthnCredential, error) {
+	credential := &WebAuthnCredential{}
+	var lastUsedAt sql.NullTime
+	err := row.Scan(&credential.ID, &credential.UserID, &credential.Name, &credential.PublicKey,
+		&credential.SignCount, &credential.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	credential.LastUsedAt = nullTime(lastUsedAt)
+	return credential, nil
+}
@@ -0,0 +1,93 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email.
+type Sender interface {
+	Send(msg *Message) error
+}
+
+// SMTPSender delivers email through an SMTP relay. Authentication is only
+// attempted when Username is set, and requires the relay to offer TLS.
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg *Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errors.New("invalid header value")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{msg.To}, s.format(msg))
+}
+
+func (s *SMTPSender) format(msg *Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// LogSender drops messages instead of delivering them, logging whom they were
+// for, so that the service can run without an SMTP relay. Bodies are never
+// logged, as they carry tokens.
+type LogSender struct{}
+
+func (LogSender) Send(msg *Message) error {
+	log.Warn().Str("to", msg.To).Str("subject", msg.Subject).Msg("email not delivered, no SMTP relay is configured")
+	return nil
+}
+
+// MemorySender keeps every message in memory instead of delivering it, for
+// tests. It never forgets one, so it has no place in a running service.
+type MemorySender struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (s *MemorySender) Send(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, *msg)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (s *MemorySender) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.messages...)
+}
@@ -0,0 +1,182 @@
+// Package policy checks new passwords against the password policy.
+package policy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/cvele/authentication-service/internal/breach"
+	"github.com/cvele/authentication-service/internal/config"
+)
+
+// Rules a password can violate.
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleCharacterClasses = "character_classes"
+	RuleStrength         = "strength"
+	RuleUsername         = "username"
+	RuleCommon           = "common"
+	RuleBreached         = "breached"
+	RuleHistory          = "history"
+)
+
+// minUsernameLength is the length from which a password must not contain
+// the username, shorter ones turn up in too many passwords by chance.
+const minUsernameLength = 3
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords ranks the embedded list, the most common password first.
+var commonPasswords = rankWords(commonPasswordList)
+
+// Violation is a rule a password does not meet, with a message that can be
+// shown to the user.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Policy is what new passwords must meet. Zero values disable the rule.
+type Policy struct {
+	MinLength        int
+	MaxLength        int
+	MinClasses       int
+	MinStrength      int
+	DisallowUsername bool
+	DisallowCommon   bool
+	Breached         breach.Checker
+}
+
+// New returns the policy configured by the PASSWORD_* settings.
+func New(cfg *config.Config) (*Policy, error) {
+	if cfg.PasswordMinClasses < 0 || cfg.PasswordMinClasses > 4 {
+		return nil, fmt.Errorf("invalid PASSWORD_MIN_CHARACTER_CLASSES %d, expected 0 to 4", cfg.PasswordMinClasses)
+	}
+	if cfg.PasswordMinStrength < 0 || cfg.PasswordMinStrength > 4 {
+		return nil, fmt.Errorf("invalid PASSWORD_MIN_STRENGTH %d, expected 0 to 4", cfg.PasswordMinStrength)
+	}
+	if cfg.PasswordMaxLength > 0 && cfg.PasswordMaxLength < cfg.PasswordMinLength {
+		return nil, fmt.Errorf("PASSWORD_MAX_LENGTH %d is below PASSWORD_MIN_LENGTH %d", cfg.PasswordMaxLength, cfg.PasswordMinLength)
+	}
+	p := &Policy{
+		MinLength:        cfg.PasswordMinLength,
+		MaxLength:        cfg.PasswordMaxLength,
+		MinClasses:       cfg.PasswordMinClasses,
+		MinStrength:      cfg.PasswordMinStrength,
+		DisallowUsername: cfg.PasswordDisallowUsername,
+		DisallowCommon:   cfg.PasswordDisallowCommon,
+	}
+	if cfg.BreachedPasswordsPath != "" {
+		breached, err := breach.Open(cfg.BreachedPasswordsPath, cfg.BreachedPasswordsMinCount)
+		if err != nil {
+			return nil, fmt.Errorf("error opening breached passwords: %v", err)
+		}
+		p.Breached = breached
+	}
+	return p, nil
+}
+
+// Check returns the rules password violates, or nil if it meets the policy.
+// username and email are those of the user the password is for.
+func (p *Policy) Check(password, username, email string) ([]Violation, error) {
+	var violations []Violation
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, Violation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := len([]rune(password))
+	if length < p.MinLength {
+		violate(RuleMinLength, "must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violate(RuleMaxLength, "must be at most %d characters long", p.MaxLength)
+	}
+	if p.MinClasses > 0 && characterClasses(password) < p.MinClasses {
+		violate(RuleCharacterClasses, "must contain at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)
+	}
+
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(email, "@")
+	userInputs := []string{username, localPart}
+	if p.DisallowUsername {
+		for _, input := range userInputs {
+			if len([]rune(input)) >= minUsernameLength && strings.Contains(lower, strings.ToLower(input)) {
+				violate(RuleUsername, "must not contain your username or email address")
+				break
+			}
+		}
+	}
+	if p.DisallowCommon {
+		if _, ok := commonPasswords[lower]; ok {
+			violate(RuleCommon, "is too common")
+		}
+	}
+
+	// Passwords that are too long are not estimated, they could only be
+	// refused for being weak as well.
+	if p.MinStrength > 0 && (p.MaxLength == 0 || length <= p.MaxLength) {
+		if Strength(password, commonPasswords, userInputs) < p.MinStrength {
+			violate(RuleStrength, "is too easy to guess")
+		}
+	}
+
+	breached, err := p.IsBreached(password)
+	if err != nil {
+		return nil, err
+	}
+	if breached {
+		violate(RuleBreached, "has appeared in a data breach")
+	}
+	return violations, nil
+}
+
+// IsBreached reports whether password appears in the breached passwords, if
+// they are configured.
+func (p *Policy) IsBreached(password string) (bool, error) {
+	if p.Breached == nil {
+		return false, nil
+	}
+	return p.Breached.Contains(password)
+}
+
+// characterClasses counts the classes of lowercase letters, uppercase
+// letters, digits and symbols password has characters of.
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// rankWords ranks the lines of list from 1, keeping the first rank of
+// words listed twice.
+func rankWords(list string) map[string]int {
+	words := make(map[string]int)
+	scanner := bufio.NewScanner(strings.NewReader(list))
+	for rank := 1; scanner.Scan(); {
+		word := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if word == "" {
+			continue
+		}
+		if _, ok := words[word]; !ok {
+			words[word] = rank
+		}
+		rank++
+	}
+	return words
+}
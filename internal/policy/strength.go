@@ -0,0 +1,269 @@
+package policy
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// maxEstimatedLength bounds the part of a password the strength is estimated
+// of, longer passwords only get stronger.
+const maxEstimatedLength = 64
+
+// Guesses above which a password gets each score, as in zxcvbn.
+var scoreThresholds = []float64{1e3, 1e6, 1e8, 1e10}
+
+// bruteforceCardinality is how many guesses each character takes when no
+// pattern covers it.
+const bruteforceCardinality = 10
+
+// minGuessesBeforeGrowingSequence penalizes splitting a password into more
+// patterns, as an attacker has to try the ways of combining them.
+const minGuessesBeforeGrowingSequence = 10000
+
+// referenceYear is the year passwords containing a year are likely to be
+// near.
+const referenceYear = 2026
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "qwertzuiop", "azertyuiop", "qsdfghjklm", "wxcvbn"}
+
+var leetSubstitutions = map[rune]rune{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z'}
+
+// match is a pattern covering password[i:j] that takes guesses to find.
+type match struct {
+	i, j    int
+	guesses float64
+}
+
+// Strength estimates how hard password is to guess, in the manner of zxcvbn:
+// it finds the common passwords, words of userInputs, keyboard walks,
+// sequences, repeats and years it is made of, and scores the combination
+// that is the easiest to guess from 0, guessable within a thousand tries, to
+// 4, taking more than ten billion.
+func Strength(password string, dictionary map[string]int, userInputs []string) int {
+	guesses := estimateGuesses(password, dictionary, userInputs)
+	for score, threshold := range scoreThresholds {
+		if guesses < threshold {
+			return score
+		}
+	}
+	return len(scoreThresholds)
+}
+
+func estimateGuesses(password string, dictionary map[string]int, userInputs []string) float64 {
+	runes := []rune(password)
+	if len(runes) > maxEstimatedLength {
+		runes = runes[:maxEstimatedLength]
+	}
+	n := len(runes)
+	if n == 0 {
+		return 1
+	}
+
+	byEnd := make([][]match, n+1)
+	for _, m := range findMatches(runes, dictionary, userInputs) {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	// best[j][l] is the fewest guesses to find runes[:j] as l patterns.
+	best := make([][]float64, n+1)
+	for j := range best {
+		best[j] = make([]float64, n+1)
+		for l := range best[j] {
+			best[j][l] = math.Inf(1)
+		}
+	}
+	best[0][0] = 1
+	for j := 1; j <= n; j++ {
+		for _, m := range byEnd[j] {
+			for l := 0; l < n; l++ {
+				if g := best[m.i][l] * m.guesses; g < best[j][l+1] {
+					best[j][l+1] = g
+				}
+			}
+		}
+	}
+
+	guesses := math.Inf(1)
+	factorial := 1.0
+	for l := 1; l <= n; l++ {
+		factorial *= float64(l)
+		g := factorial*best[n][l] + math.Pow(minGuessesBeforeGrowingSequence, float64(l-1))
+		if g < guesses {
+			guesses = g
+		}
+	}
+	return guesses
+}
+
+// findMatches returns the patterns in runes, and brute force matches for
+// every part of it, so that there is always a way to cover all of it.
+func findMatches(runes []rune, dictionary map[string]int, userInputs []string) []match {
+	n := len(runes)
+	lower := []rune(strings.ToLower(string(runes)))
+	unleet := make([]rune, n)
+	for k, r := range lower {
+		if s, ok := leetSubstitutions[r]; ok {
+			unleet[k] = s
+		} else {
+			unleet[k] = r
+		}
+	}
+
+	inputs := make(map[string]int, len(userInputs))
+	for rank, input := range userInputs {
+		if input = strings.ToLower(input); input != "" {
+			inputs[input] = rank + 1
+		}
+	}
+
+	var matches []match
+	for i := 0; i < n; i++ {
+		for j := i + 1; j <= n; j++ {
+			matches = append(matches, match{i, j, math.Pow(bruteforceCardinality, float64(j-i))})
+
+			word := string(lower[i:j])
+			variations := caseVariations(runes[i:j])
+			if rank, ok := lookup(word, dictionary, inputs); ok {
+				matches = append(matches, match{i, j, rank * variations})
+			}
+			if rank, ok := lookup(reverse(word), dictionary, inputs); ok && j-i > 1 {
+				matches = append(matches, match{i, j, 2 * rank * variations})
+			}
+			if leet := string(unleet[i:j]); leet != word {
+				if rank, ok := lookup(leet, dictionary, inputs); ok {
+					matches = append(matches, match{i, j, 2 * rank * variations})
+				}
+			}
+
+			if j-i < 3 {
+				continue
+			}
+			if g, ok := repeatGuesses(lower[i:j]); ok {
+				matches = append(matches, match{i, j, g})
+			}
+			if g, ok := sequenceGuesses(lower[i:j]); ok {
+				matches = append(matches, match{i, j, g})
+			}
+			if isKeyboardWalk(word) {
+				matches = append(matches, match{i, j, float64(10 * (j - i))})
+			}
+			if j-i == 4 {
+				if g, ok := yearGuesses(word); ok {
+					matches = append(matches, match{i, j, g})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// lookup returns the rank of word in dictionary or among the user inputs,
+// which are guessed first.
+func lookup(word string, dictionary map[string]int, inputs map[string]int) (float64, bool) {
+	if rank, ok := inputs[word]; ok {
+		return float64(rank), true
+	}
+	if rank, ok := dictionary[word]; ok {
+		return float64(rank), true
+	}
+	return 0, false
+}
+
+// caseVariations is how many guesses the capitalization of word adds.
+// Lower case, a capital first letter and all capitals are tried first.
+func caseVariations(word []rune) float64 {
+	upper, letters := 0, 0
+	for _, r := range word {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	switch {
+	case upper == 0:
+		return 1
+	case upper == letters, upper == 1 && unicode.IsUpper(word[0]):
+		return 2
+	default:
+		return math.Pow(2, float64(upper))
+	}
+}
+
+// repeatGuesses returns the guesses for s if it repeats a shorter part.
+func repeatGuesses(s []rune) (float64, bool) {
+	for size := 1; size <= len(s)/2; size++ {
+		if len(s)%size != 0 {
+			continue
+		}
+		repeated := true
+		for k := size; k < len(s) && repeated; k++ {
+			repeated = s[k] == s[k-size]
+		}
+		if repeated {
+			return math.Pow(bruteforceCardinality, float64(size)) * float64(len(s)/size), true
+		}
+	}
+	return 0, false
+}
+
+// sequenceGuesses returns the guesses for s if its characters go up or down
+// in steps of one, as in abcd or 9876.
+func sequenceGuesses(s []rune) (float64, bool) {
+	delta := s[1] - s[0]
+	if delta != 1 && delta != -1 {
+		return 0, false
+	}
+	for k := 2; k < len(s); k++ {
+		if s[k]-s[k-1] != delta {
+			return 0, false
+		}
+	}
+
+	start := 26.0
+	switch {
+	case strings.ContainsRune("az019", s[0]):
+		start = 4
+	case unicode.IsDigit(s[0]):
+		start = 10
+	}
+	guesses := start * float64(len(s))
+	if delta < 0 {
+		guesses *= 2
+	}
+	return guesses, true
+}
+
+func isKeyboardWalk(s string) bool {
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(row, reverse(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// yearGuesses returns the guesses for s if it is a recent year.
+func yearGuesses(s string) (float64, bool) {
+	year := 0
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+		year = year*10 + int(r-'0')
+	}
+	if year < 1900 || year > 2099 {
+		return 0, false
+	}
+	return math.Max(math.Abs(float64(year-referenceYear)), 20), true
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for a, b := 0, len(runes)-1; a < b; a, b = a+1, b-1 {
+		runes[a], runes[b] = runes[b], runes[a]
+	}
+	return string(runes)
+}
@@ -0,0 +1,347 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/db"
+)
+
+// maxKeyBodySize bounds how much of a request body is read to find the key
+// of a request.
+const maxKeyBodySize = 1 << 20
+
+// sweepInterval is how often MemoryStore drops buckets that are full again.
+const sweepInterval = time.Minute
+
+// Limit allows Requests per Per on average, in bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseLimit parses limits written as requests/period, such as 10/1m. The
+// period may be a bare unit, as in 10/s.
+func ParseLimit(s string) (Limit, error) {
+	requests, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected requests/period", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("invalid number of requests in rate limit %q", s)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in rate limit %q", s)
+	}
+	return Limit{Requests: n, Per: per}, nil
+}
+
+// Result is the outcome of taking a token from a bucket. Reset is how long
+// the bucket takes to fill up again, RetryAfter how long a request that was
+// not allowed has to wait.
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store keeps the token buckets of a rate limit.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// take takes a token from a bucket holding tokens, elapsed after it was last
+// updated, and returns the tokens left.
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	capacity := float64(limit.Requests)
+	rate := capacity / limit.Per.Seconds()
+
+	tokens = math.Min(capacity, tokens+elapsed.Seconds()*rate)
+	result := Result{}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((capacity - tokens) / rate)
+	return tokens, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	expiresAt time.Time
+}
+
+// MemoryStore keeps token buckets in memory. Every replica of the service
+// counts on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		// A full bucket is no different from one that does not exist.
+		for k, b := range s.buckets {
+			if now.After(b.expiresAt) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		s.buckets[key] = b
+	}
+	var result Result
+	b.tokens, result = take(b.tokens, now.Sub(b.updatedAt), limit)
+	b.updatedAt = now
+	b.expiresAt = now.Add(result.Reset)
+	return result, nil
+}
+
+// Buckets persists token buckets, see db.Store.
+type Buckets interface {
+	UpdateRateLimitBucket(ctx context.Context, key string, update func(b *db.RateLimitBucket)) error
+}
+
+// DBStore keeps token buckets in the database, so that all replicas of the
+// service share them.
+type DBStore struct {
+	buckets Buckets
+}
+
+func NewDBStore(buckets Buckets) *DBStore {
+	return &DBStore{buckets: buckets}
+}
+
+func (s *DBStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	var result Result
+	err := s.buckets.UpdateRateLimitBucket(ctx, key, func(b *db.RateLimitBucket) {
+		now := time.Now()
+		if b.UpdatedAt.IsZero() {
+			b.Tokens = float64(limit.Requests)
+			b.UpdatedAt = now
+		}
+		b.Tokens, result = take(b.Tokens, now.Sub(b.UpdatedAt), limit)
+		b.UpdatedAt = now
+		b.ExpiresAt = now.Add(result.Reset)
+	})
+	return result, err
+}
+
+// KeyFunc returns what a request is counted against, or an empty string if
+// it is not counted.
+type KeyFunc func(r *http.Request) string
+
+// ByIP counts requests per client address. Behind proxies it relies on
+// RealIP.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ByUsername counts requests per username field of a JSON or form encoded
+// body. Requests without one are not counted.
+func ByUsername(r *http.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxKeyBodySize))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return ""
+	}
+
+	var username string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return ""
+		}
+		username = values.Get("username")
+	} else {
+		var data struct {
+			Username string `json:"username"`
+		}
+		if json.Unmarshal(body, &data) != nil {
+			return ""
+		}
+		username = data.Username
+	}
+
+	if username == "" {
+		return ""
+	}
+	return "user:" + username
+}
+
+// RateLimit allows limit requests for each key of a request, as returned by
+// keys, counted under name. A request is refused with 429 Too Many Requests
+// once any of its keys runs out. The RateLimit-* headers describe the limit
+// of the last key; the limits of routes run after the global ones, so a
+// route's own limit is the one reported. The store failing lets requests
+// through.
+func RateLimit(store Store, name string, limit Limit, keys ...KeyFunc) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, keyFunc := range keys {
+				key := keyFunc(r)
+				if key == "" {
+					continue
+				}
+
+				result, err := store.Take(r.Context(), name+"|"+key, limit)
+				if err != nil {
+					log.Error().Err(err).Str("limit", name).Msg("Error checking rate limit")
+					continue
+				}
+
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+				w.Header().Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+				if !result.Allowed {
+					w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+					http.Error(w, "too many requests", http.StatusTooManyRequests)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	s := int64(math.Ceil(d.Seconds()))
+	if s < 1 {
+		return 1
+	}
+	return s
+}
+
+// ParseTrustedProxies parses the addresses and CIDR ranges of trusted
+// proxies.
+func ParseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// RealIP replaces the RemoteAddr of requests that come through trusted
+// proxies with the client address from X-Forwarded-For. The header is read
+// from the right, each trusted proxy vouching for the address before it, so
+// a client cannot pass off an address of its choosing.
+func RealIP(trusted []*net.IPNet) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(trusted) > 0 {
+				if ip := forwardedFor(r, trusted); ip != "" {
+					r.RemoteAddr = net.JoinHostPort(ip, "0")
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func forwardedFor(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrusted(net.ParseIP(host), trusted) {
+		return ""
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !isTrusted(ip, trusted) {
+			break
+		}
+	}
+	return client
+}
+
+func isTrusted(ip net.IP, trusted []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
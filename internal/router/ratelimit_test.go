@@ -0,0 +1,391 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/db"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{in: "10/1m", want: Limit{Requests: 10, Per: time.Minute}},
+		{in: "600/1m", want: Limit{Requests: 600, Per: time.Minute}},
+		{in: " 5/30s ", want: Limit{Requests: 5, Per: 30 * time.Second}},
+		{in: "10/s", want: Limit{Requests: 10, Per: time.Second}},
+		{in: "10/h", want: Limit{Requests: 10, Per: time.Hour}},
+		{in: "10", wantErr: true},
+		{in: "0/1m", wantErr: true},
+		{in: "-1/1m", wantErr: true},
+		{in: "x/1m", wantErr: true},
+		{in: "10/", wantErr: true},
+		{in: "10/0s", wantErr: true},
+		{in: "10/-1m", wantErr: true},
+		{in: "10/fortnight", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	// 20 requests a minute refill a token every 3 seconds.
+	limit := Limit{Requests: 20, Per: time.Minute}
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		want       Result
+	}{
+		{
+			name:       "full bucket",
+			tokens:     20,
+			wantTokens: 19,
+			want:       Result{Allowed: true, Remaining: 19, Reset: 3 * time.Second},
+		},
+		{
+			name:       "refill is capped at the capacity",
+			tokens:     20,
+			elapsed:    time.Hour,
+			wantTokens: 19,
+			want:       Result{Allowed: true, Remaining: 19, Reset: 3 * time.Second},
+		},
+		{
+			name:       "last token",
+			tokens:     1,
+			wantTokens: 0,
+			want:       Result{Allowed: true, Remaining: 0, Reset: time.Minute},
+		},
+		{
+			name:       "empty bucket",
+			tokens:     0,
+			wantTokens: 0,
+			want:       Result{Allowed: false, Remaining: 0, Reset: time.Minute, RetryAfter: 3 * time.Second},
+		},
+		{
+			name:       "partly refilled",
+			tokens:     0,
+			elapsed:    time.Second,
+			wantTokens: 1.0 / 3,
+			want:       Result{Allowed: false, Remaining: 0, Reset: 59 * time.Second, RetryAfter: 2 * time.Second},
+		},
+		{
+			name:       "refilled a token",
+			tokens:     0,
+			elapsed:    3 * time.Second,
+			wantTokens: 0,
+			want:       Result{Allowed: true, Remaining: 0, Reset: time.Minute},
+		},
+		{
+			name:       "refilled several tokens",
+			tokens:     2.5,
+			elapsed:    9 * time.Second,
+			wantTokens: 4.5,
+			want:       Result{Allowed: true, Remaining: 4, Reset: 46500 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, got := take(tt.tokens, tt.elapsed, limit)
+			if !near(tokens, tt.wantTokens) {
+				t.Errorf("tokens = %v, want %v", tokens, tt.wantTokens)
+			}
+			if got.Allowed != tt.want.Allowed || got.Remaining != tt.want.Remaining ||
+				!nearDuration(got.Reset, tt.want.Reset) || !nearDuration(got.RetryAfter, tt.want.RetryAfter) {
+				t.Errorf("result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreTake(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 3, Per: time.Hour}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "a", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != i {
+			t.Fatalf("request %d: %+v, want allowed with %d remaining", 3-i, result, i)
+		}
+	}
+	result, err := store.Take(ctx, "a", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Fatal("fourth request allowed")
+	}
+	if result.RetryAfter <= 19*time.Minute || result.RetryAfter > 20*time.Minute {
+		t.Errorf("RetryAfter = %v, want about 20m", result.RetryAfter)
+	}
+
+	// Other keys have buckets of their own.
+	result, err = store.Take(ctx, "b", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("other key: %+v, want allowed with 2 remaining", result)
+	}
+}
+
+func TestDBStoreTake(t *testing.T) {
+	store := NewDBStore(db.NewMemory())
+	limit := Limit{Requests: 2, Per: time.Hour}
+	ctx := context.Background()
+
+	for i, want := range []bool{true, true, false} {
+		result, err := store.Take(ctx, "a", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Allowed != want {
+			t.Fatalf("request %d: allowed = %v, want %v", i+1, result.Allowed, want)
+		}
+	}
+}
+
+func TestForwardedFor(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    []string
+		want       string
+	}{
+		{
+			name:       "untrusted peer",
+			remoteAddr: "203.0.113.7:1234",
+			headers:    []string{"198.51.100.1"},
+			want:       "",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "address spoofed by the client is skipped",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    []string{"6.6.6.6, 198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    []string{"198.51.100.1, 192.168.1.1, 10.1.2.3"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "several headers",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    []string{"6.6.6.6", "198.51.100.1, 10.1.2.3"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "garbage stops the walk",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    []string{"198.51.100.1, unknown, 10.1.2.3"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "every hop trusted",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    []string{"10.1.1.1, 10.2.2.2"},
+			want:       "10.1.1.1",
+		},
+		{
+			name:       "no header",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "",
+		},
+		{
+			name:       "IPv6",
+			remoteAddr: "[fd00::1]:1234",
+			headers:    []string{"2001:db8::1"},
+			want:       "2001:db8::1",
+		},
+		{
+			name:       "peer without a port",
+			remoteAddr: "10.0.0.1",
+			headers:    []string{"198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, h := range tt.headers {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if got := forwardedFor(r, trusted); got != tt.want {
+				t.Errorf("forwardedFor() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := ParseTrustedProxies([]string{" 10.0.0.1 ", "", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(networks) != 2 || networks[0].String() != "10.0.0.1/32" || networks[1].String() != "2001:db8::/32" {
+		t.Errorf("ParseTrustedProxies() = %v", networks)
+	}
+	for _, proxy := range []string{"proxy.local", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies([]string{proxy}); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) did not fail", proxy)
+		}
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ByIP(r)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "ip:198.51.100.1" {
+		t.Errorf("key behind a trusted proxy = %q", got)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "ip:203.0.113.7" {
+		t.Errorf("key of an untrusted peer = %q", got)
+	}
+}
+
+func TestByUsername(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        string
+	}{
+		{"JSON", "application/json", `{"username":"jane","password":"x"}`, "user:jane"},
+		{"form", "application/x-www-form-urlencoded", "username=jane&password=x", "user:jane"},
+		{"form with charset", "application/x-www-form-urlencoded; charset=utf-8", "username=jane", "user:jane"},
+		{"no username", "application/json", `{"password":"x"}`, ""},
+		{"invalid JSON", "application/json", `{"username":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			if got := ByUsername(r); got != tt.want {
+				t.Errorf("ByUsername() = %q, want %q", got, tt.want)
+			}
+			// The handler still gets the whole body.
+			rest := new(bytes.Buffer)
+			if _, err := rest.ReadFrom(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			if rest.String() != tt.body {
+				t.Errorf("body after ByUsername() = %q, want %q", rest.String(), tt.body)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limit := Limit{Requests: 2, Per: time.Minute}
+	handler := RateLimit(NewMemoryStore(), "login", limit, ByIP, ByUsername)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(ip, username string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"`+username+`"}`))
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("198.51.100.1", "jane"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	w := request("198.51.100.1", "jane")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "30" || w.Header().Get("RateLimit-Limit") != "2" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("headers = %v", w.Header())
+	}
+
+	// Another address is still refused for the same username, and the
+	// address for another username.
+	if w := request("198.51.100.2", "jane"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("same username from another address: status %d, want 429", w.Code)
+	}
+	if w := request("198.51.100.1", "joe"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("another username from the same address: status %d, want 429", w.Code)
+	}
+	if w := request("198.51.100.3", "joe"); w.Code != http.StatusOK {
+		t.Errorf("another username from another address: status %d, want 200", w.Code)
+	}
+}
+
+func TestRateLimitLetsRequestsThroughWhenTheStoreFails(t *testing.T) {
+	handler := RateLimit(failingStore{}, "default", Limit{Requests: 1, Per: time.Minute}, ByIP)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	return Result{}, errors.New("unavailable")
+}
+
+func near(a, b float64) bool {
+	return a-b < 1e-9 && b-a < 1e-9
+}
+
+func nearDuration(a, b time.Duration) bool {
+	return a-b < time.Millisecond && b-a < time.Millisecond
+}
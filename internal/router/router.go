@@ -6,6 +6,9 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// Middleware wraps a handler, to run code before or after it.
+type Middleware func(http.Handler) http.Handler
+
 type router struct {
 	muxRouter *mux.Router
 }
@@ -14,8 +17,21 @@ func New() *router {
 	return &router{muxRouter: mux.NewRouter()}
 }
 
-func (r *router) HandleFunc(path string, f func(http.ResponseWriter, *http.Request)) *mux.Route {
-	return r.muxRouter.HandleFunc(path, f)
+// HandleFunc registers f for path, wrapped in middlewares, the first of which
+// runs first. They run after the middlewares added with Use.
+func (r *router) HandleFunc(path string, f func(http.ResponseWriter, *http.Request), middlewares ...Middleware) *mux.Route {
+	var handler http.Handler = http.HandlerFunc(f)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return r.muxRouter.Handle(path, handler)
+}
+
+// Use adds middlewares that run for every route, in the order they are added.
+func (r *router) Use(middlewares ...Middleware) {
+	for _, middleware := range middlewares {
+		r.muxRouter.Use(mux.MiddlewareFunc(middleware))
+	}
 }
 
 func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
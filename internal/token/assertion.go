@@ -0,0 +1,69 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// ClientAssertionType is the client_assertion_type of private_key_jwt client
+// authentication (RFC 7523).
+const ClientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// maxAssertionLifetime bounds how far in the future a client assertion may
+// expire, which is also how long its jti has to be remembered.
+const maxAssertionLifetime = 10 * time.Minute
+
+// AssertionIssuer returns the client an assertion claims to be from, without
+// verifying it. It is only good for looking up the keys to verify it with.
+func AssertionIssuer(assertion string) (string, error) {
+	claims := &jwt.StandardClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(assertion, claims); err != nil {
+		return "", err
+	}
+	return claims.Issuer, nil
+}
+
+// ValidateClientAssertion verifies a private_key_jwt assertion of clientID
+// against the client's registered keys. Its iss and sub must be the client,
+// its aud one of audiences, and it must carry a jti and expire shortly. The
+// caller is responsible for rejecting replayed jti values.
+func ValidateClientAssertion(assertion, clientID string, keys []*Key, audiences []string, cfg *config.Config) (*jwt.StandardClaims, error) {
+	token, err := jwt.ParseWithClaims(assertion, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range keys {
+			if (kid == "" || kid == key.ID) && token.Method.Alg() == key.Algorithm {
+				return key.public, nil
+			}
+		}
+		return nil, errors.New("no matching client key")
+	}, jwt.WithLeeway(cfg.JWTLeeway), jwt.WithoutAudienceValidation())
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*jwt.StandardClaims)
+	if !ok {
+		return nil, errors.New("invalid assertion claims")
+	}
+	if claims.Issuer != clientID || claims.Subject != clientID {
+		return nil, errors.New("assertion was not issued by the client")
+	}
+	if claims.ID == "" {
+		return nil, errors.New("assertion has no jti")
+	}
+	if claims.ExpiresAt == nil || claims.ExpiresAt.After(time.Now().Add(maxAssertionLifetime)) {
+		return nil, errors.New("assertion must expire within 10 minutes")
+	}
+
+	for _, aud := range claims.Audience {
+		for _, audience := range audiences {
+			if aud == audience {
+				return claims, nil
+			}
+		}
+	}
+	return nil, &jwt.InvalidAudienceError{Message: "assertion is not intended for this server"}
+}
@@ -0,0 +1,49 @@
+package token
+
+import (
+	"crypto/ed25519"
+
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// SigningMethodEdDSA implements the EdDSA signing method from RFC 8037 for
+// Ed25519 keys, which jwt-go does not ship with.
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.NewInvalidKeyTypeError("ed25519.PublicKey", key)
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return new(jwt.InvalidSignatureError)
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.NewInvalidKeyTypeError("ed25519.PrivateKey", key)
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
@@ -0,0 +1,66 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// EmailVerificationAudience is the audience of email verification tokens. It
+// is never one of JWT_AUDIENCES, so they cannot pass as access tokens.
+const EmailVerificationAudience = "urn:authentication-service:email-verification"
+
+// EmailVerificationClaims are the claims of the token in an email
+// verification link. The subject is the user the address belongs to.
+type EmailVerificationClaims struct {
+	Email string `json:"email"`
+	jwt.StandardClaims
+}
+
+// NewEmailVerification mints the token proving that whoever holds it
+// received mail sent to email, the address of userID.
+func NewEmailVerification(userID, email string, keys *Keyring, cfg *config.Config) (string, error) {
+	now := time.Now()
+	claims := &EmailVerificationClaims{
+		Email: email,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    cfg.JWTIssuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{EmailVerificationAudience},
+			IssuedAt:  jwt.At(now),
+			ExpiresAt: jwt.At(now.Add(cfg.EmailVerificationTTL)),
+		},
+	}
+
+	key := keys.Active()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// ValidateEmailVerification checks the signature, issuer and lifetime of an
+// email verification token and returns its claims.
+func ValidateEmailVerification(tokenString string, keys *Keyring, cfg *config.Config) (*EmailVerificationClaims, error) {
+	options := []jwt.ParserOption{jwt.WithLeeway(cfg.JWTLeeway), jwt.WithoutAudienceValidation()}
+	if cfg.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &EmailVerificationClaims{}, keys.verificationKey, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*EmailVerificationClaims)
+	if !ok || claims.Subject == "" || claims.Email == "" {
+		return nil, errors.New("invalid token claims")
+	}
+	// Unlike the parser's own check, this rejects tokens without audience.
+	if len(claims.Audience) != 1 || claims.Audience[0] != EmailVerificationAudience {
+		return nil, &jwt.InvalidAudienceError{Message: "token is not an email verification token"}
+	}
+	return claims, nil
+}
@@ -0,0 +1,73 @@
+package token
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// ErrSymmetricIDToken is returned by NewIDToken while the active key is
+// symmetric.
+var ErrSymmetricIDToken = errors.New("ID tokens require an asymmetric signing key")
+
+// IDClaims are the claims of an OpenID Connect ID token.
+type IDClaims struct {
+	Nonce           string    `json:"nonce,omitempty"`
+	AuthTime        *jwt.Time `json:"auth_time,omitempty"`
+	AccessTokenHash string    `json:"at_hash,omitempty"`
+	jwt.StandardClaims
+}
+
+// NewIDToken mints an ID token telling clientID that userID signed in at
+// authTime. When accessToken is set its at_hash is included, so the client
+// can check the access token it received alongside. The active key has to
+// be asymmetric: clients could not verify ID tokens signed with the secret
+// otherwise, and those holding it could forge them.
+func NewIDToken(userID, clientID, nonce string, authTime time.Time, accessToken string, keys *Keyring, cfg *config.Config) (string, error) {
+	key := keys.Active()
+	if key.Symmetric() {
+		return "", ErrSymmetricIDToken
+	}
+
+	// Many OpenID Connect libraries only accept whole seconds.
+	now := time.Now().Truncate(time.Second)
+	claims := &IDClaims{
+		Nonce:    nonce,
+		AuthTime: jwt.At(authTime.Truncate(time.Second)),
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    cfg.JWTIssuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.At(now),
+			ExpiresAt: jwt.At(now.Add(cfg.TokenTTL)),
+		},
+	}
+	if accessToken != "" {
+		claims.AccessTokenHash = accessTokenHash(accessToken, key.Algorithm)
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// accessTokenHash computes the at_hash claim, the left half of the hash of
+// the access token using the hash function of the ID token's algorithm.
+func accessTokenHash(accessToken, algorithm string) string {
+	var h hash.Hash
+	if algorithm == EdDSA {
+		h = sha512.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
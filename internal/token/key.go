@@ -0,0 +1,427 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Key is a JWT signing key together with the identifier it is published
+// under in the kid header.
+type Key struct {
+	ID        string
+	Algorithm string
+	method    jwt.SigningMethod
+	private   interface{}
+	public    interface{}
+}
+
+// JWK is the JSON Web Key representation of a public key (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// LoadKey builds the signing key described by the configuration: the PEM
+// encoded private key at JWTPrivateKeyPath for asymmetric algorithms, or
+// JWTSecretKey for HS256.
+func LoadKey(cfg *config.Config) (*Key, error) {
+	if cfg.JWTSigningAlgorithm == HS256 {
+		return NewKey(HS256, cfg.JWTKeyID, []byte(cfg.JWTSecretKey))
+	}
+
+	if cfg.JWTPrivateKeyPath == "" {
+		return nil, fmt.Errorf("JWT_PRIVATE_KEY_PATH is required for %s", cfg.JWTSigningAlgorithm)
+	}
+	data, err := os.ReadFile(cfg.JWTPrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %v", err)
+	}
+	private, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(cfg.JWTSigningAlgorithm, cfg.JWTKeyID, private)
+}
+
+// NewKey pairs a private key (or HMAC secret) with algorithm. When id is
+// empty the RFC 7638 thumbprint of the key is used.
+func NewKey(algorithm, id string, private interface{}) (*Key, error) {
+	key := &Key{Algorithm: algorithm, private: private}
+
+	switch algorithm {
+	case HS256:
+		secret, ok := private.([]byte)
+		if !ok || len(secret) == 0 {
+			return nil, errors.New("HS256 requires a non-empty secret")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.public = secret
+	case RS256:
+		rsaKey, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("RS256 requires an RSA private key, got %T", private)
+		}
+		if rsaKey.N.BitLen() < 2048 {
+			return nil, errors.New("RS256 requires an RSA key of at least 2048 bits")
+		}
+		key.method = jwt.SigningMethodRS256
+		key.public = &rsaKey.PublicKey
+	case ES256:
+		ecKey, ok := private.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 requires a P-256 ECDSA private key, got %T", private)
+		}
+		key.method = jwt.SigningMethodES256
+		key.public = &ecKey.PublicKey
+	case EdDSA:
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("EdDSA requires an Ed25519 private key, got %T", private)
+		}
+		key.method = SigningMethodEdDSA
+		key.public = edKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	key.ID = id
+	if key.ID == "" {
+		thumbprint, err := key.thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+
+	return key, nil
+}
+
+// NewVerificationKey returns a key that can only verify tokens, for keys that
+// have been rotated out but may still have live tokens. The algorithm is
+// inferred from the type of public.
+func NewVerificationKey(id string, public crypto.PublicKey) (*Key, error) {
+	algorithm, err := algorithmFor(public)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: id, Algorithm: algorithm, method: jwt.GetSigningMethod(algorithm), public: public}
+	if key.ID == "" {
+		thumbprint, err := key.thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+	return key, nil
+}
+
+// GenerateKey creates a fresh random key for algorithm.
+func GenerateKey(algorithm string) (*Key, error) {
+	var private interface{}
+	var err error
+
+	switch algorithm {
+	case HS256:
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		private = secret
+	case RS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case ES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKey(algorithm, "", private)
+}
+
+// CanSign reports whether the private half of the key is available.
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// Symmetric reports whether the key is a shared secret. Signatures made with
+// it can only be verified by those who could forge them as well.
+func (k *Key) Symmetric() bool {
+	return k.Algorithm == HS256
+}
+
+// MarshalPEM encodes the private half of the key so that it can be parsed
+// again with ParsePrivateKeyPEM.
+func (k *Key) MarshalPEM() ([]byte, error) {
+	if secret, ok := k.private.([]byte); ok {
+		return pem.EncodeToMemory(&pem.Block{Type: "HMAC KEY", Bytes: secret}), nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParsePrivateKeyPEM parses the first private key found in PEM data. PKCS #8,
+// PKCS #1 (RSA) and SEC 1 (EC) encodings are accepted, as well as the raw
+// HMAC secrets written by MarshalPEM.
+func ParsePrivateKeyPEM(data []byte) (crypto.PrivateKey, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no private key found in PEM data")
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			return x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(block.Bytes)
+		case "HMAC KEY":
+			return block.Bytes, nil
+		}
+	}
+}
+
+// ParsePublicKeyPEM parses the first public key found in PEM data. When the
+// data holds a private key instead, its public half is returned.
+func ParsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type == "PUBLIC KEY" {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	private, err := ParsePrivateKeyPEM(data)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", private)
+	}
+	return signer.Public(), nil
+}
+
+// algorithmFor returns the signing algorithm used with public.
+func algorithmFor(public crypto.PublicKey) (string, error) {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return ES256, nil
+		}
+	case ed25519.PublicKey:
+		return EdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported public key type %T", public)
+}
+
+// JWK returns the public half of the key as a JWK. Symmetric keys have no
+// public half and report false.
+func (k *Key) JWK() (JWK, bool) {
+	jwk := JWK{Use: "sig", Algorithm: k.Algorithm, KeyID: k.ID}
+
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeBigInt(public.N)
+		jwk.E = encodeBigInt(big.NewInt(int64(public.E)))
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = "EC"
+		jwk.Curve = public.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// PublicKey converts the JWK into a key that verifies tokens. Keys must be
+// meant for signatures and of a type and curve that is supported.
+func (j JWK) PublicKey() (*Key, error) {
+	if j.Use != "" && j.Use != "sig" {
+		return nil, fmt.Errorf("key %q is not a signing key", j.KeyID)
+	}
+
+	var public crypto.PublicKey
+	switch {
+	case j.KeyType == "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		public = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case j.KeyType == "EC" && j.Curve == "P-256":
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC point")
+		}
+		public = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	case j.KeyType == "OKP" && j.Curve == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		public = ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("unsupported key type %s %s", j.KeyType, j.Curve)
+	}
+
+	key, err := NewVerificationKey(j.KeyID, public)
+	if err != nil {
+		return nil, err
+	}
+	if j.Algorithm != "" && j.Algorithm != key.Algorithm {
+		return nil, fmt.Errorf("unsupported algorithm %s for key %q", j.Algorithm, j.KeyID)
+	}
+	return key, nil
+}
+
+// ParseJWKS parses a JSON Web Key Set of public keys.
+func ParseJWKS(data []byte) ([]*Key, error) {
+	var jwks JWKS
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+	if len(jwks.Keys) == 0 {
+		return nil, errors.New("key set is empty")
+	}
+
+	keys := make([]*Key, 0, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint of the key.
+func (k *Key) thumbprint() (string, error) {
+	var members interface{}
+	if secret, ok := k.public.([]byte); ok {
+		members = struct {
+			K   string `json:"k"`
+			Kty string `json:"kty"`
+		}{base64.RawURLEncoding.EncodeToString(secret), "oct"}
+	} else {
+		jwk, _ := k.JWK()
+		switch jwk.KeyType {
+		case "RSA":
+			members = struct {
+				E   string `json:"e"`
+				Kty string `json:"kty"`
+				N   string `json:"n"`
+			}{jwk.E, jwk.KeyType, jwk.N}
+		case "EC":
+			members = struct {
+				Crv string `json:"crv"`
+				Kty string `json:"kty"`
+				X   string `json:"x"`
+				Y   string `json:"y"`
+			}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+		default:
+			members = struct {
+				Crv string `json:"crv"`
+				Kty string `json:"kty"`
+				X   string `json:"x"`
+			}{jwk.Curve, jwk.KeyType, jwk.X}
+		}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("missing key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
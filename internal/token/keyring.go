@@ -0,0 +1,318 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/cvele/authentication-service/internal/audit"
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/cvele/authentication-service/internal/db"
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// minResyncInterval limits how often a lookup for an unknown kid may force a
+// sync with the key store.
+const minResyncInterval = 5 * time.Second
+
+// KeyStore persists the keys managed by a Keyring, so that every replica
+// signs with the same key and rotations survive restarts.
+type KeyStore interface {
+	ListSigningKeys(ctx context.Context) ([]db.SigningKey, error)
+	RotateSigningKey(ctx context.Context, next *db.SigningKey, previousID string, expiresAt time.Time) error
+	AppendAuditEvent(ctx context.Context, e *db.AuditEvent) error
+}
+
+// Keyring holds the key new tokens are signed with and any number of
+// verify-only keys, selected by kid.
+//
+// Keys come from two places. Static keys are read from the configuration
+// once: the signing key, JWT_VERIFICATION_KEY_PATHS and
+// JWT_PREVIOUS_SECRET_KEYS. Rotated keys live in the KeyStore; the newest one
+// that has not been retired takes over signing from the static key, and
+// retired ones keep verifying tokens until their overlap window ends.
+type Keyring struct {
+	cfg   *config.Config
+	store KeyStore
+
+	mu          sync.RWMutex
+	static      []*Key
+	keys        map[string]ringKey
+	active      *Key
+	activeID    string // id of the active stored key, empty while a static key signs
+	activeSince time.Time
+	lastSync    time.Time
+}
+
+type ringKey struct {
+	key       *Key
+	expiresAt *time.Time
+}
+
+// LoadKeyring builds a keyring from the configured keys. If store is not nil
+// the rotated keys are loaded from it as well.
+func LoadKeyring(ctx context.Context, cfg *config.Config, store KeyStore) (*Keyring, error) {
+	signing, err := LoadKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	static := []*Key{signing}
+
+	for _, path := range cfg.JWTVerificationKeyPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading verification key: %v", err)
+		}
+		public, err := ParsePublicKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing verification key %s: %v", path, err)
+		}
+		key, err := NewVerificationKey("", public)
+		if err != nil {
+			return nil, err
+		}
+		static = append(static, key)
+	}
+
+	for _, secret := range cfg.JWTPreviousSecretKeys {
+		key, err := NewKey(HS256, "", []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		static = append(static, key)
+	}
+
+	r := &Keyring{cfg: cfg, store: store, static: static}
+	if err := r.rebuild(nil); err != nil {
+		return nil, err
+	}
+	if store != nil {
+		if err := r.Sync(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (r *Keyring) Active() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.active
+}
+
+// Lookup returns the key published under kid, provided it may still verify
+// tokens. Unknown ids trigger a sync, as another replica may have rotated.
+func (r *Keyring) Lookup(kid string) (*Key, bool) {
+	key, ok, stale := r.lookup(kid)
+	if ok || !stale {
+		return key, ok
+	}
+
+	if err := r.Sync(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to sync signing keys")
+		return nil, false
+	}
+	key, ok, _ = r.lookup(kid)
+	return key, ok
+}
+
+func (r *Keyring) lookup(kid string) (key *Key, ok bool, stale bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rk, ok := r.keys[kid]
+	if !ok {
+		return nil, false, r.store != nil && time.Since(r.lastSync) > minResyncInterval
+	}
+	if rk.expiresAt != nil && time.Now().After(*rk.expiresAt) {
+		return nil, false, false
+	}
+	return rk.key, true, false
+}
+
+// JWKS returns the public halves of every key that may still verify tokens,
+// starting with the active one.
+func (r *Keyring) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]string, 0, len(r.keys))
+	for id := range r.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	if jwk, ok := r.active.JWK(); ok {
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	now := time.Now()
+	for _, id := range ids {
+		rk := r.keys[id]
+		if rk.key == r.active || (rk.expiresAt != nil && now.After(*rk.expiresAt)) {
+			continue
+		}
+		if jwk, ok := rk.key.JWK(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
+
+// Sync reloads the rotated keys from the store.
+func (r *Keyring) Sync(ctx context.Context) error {
+	if r.store == nil {
+		return nil
+	}
+
+	stored, err := r.store.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	return r.rebuild(stored)
+}
+
+// Rotate generates a new JWT_SIGNING_ALGORITHM key and makes it the active
+// one. The previous key keeps verifying tokens for JWT_KEY_OVERLAP, but never
+// for less than TOKEN_TTL so that no live token is orphaned. The rotation
+// is recorded in the audit log.
+func (r *Keyring) Rotate(ctx context.Context) (*Key, error) {
+	if r.store == nil {
+		return nil, errors.New("key rotation requires a key store")
+	}
+
+	next, err := GenerateKey(r.cfg.JWTSigningAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := next.MarshalPEM()
+	if err != nil {
+		return nil, err
+	}
+
+	overlap := r.cfg.JWTKeyOverlap
+	if overlap < r.cfg.TokenTTL {
+		overlap = r.cfg.TokenTTL
+	}
+
+	r.mu.RLock()
+	previousID := r.activeID
+	r.mu.RUnlock()
+
+	now := time.Now()
+	err = r.store.RotateSigningKey(ctx, &db.SigningKey{
+		ID:         next.ID,
+		Algorithm:  next.Algorithm,
+		PrivateKey: string(encoded),
+		CreatedAt:  now,
+	}, previousID, now.Add(overlap))
+	if err != nil {
+		return nil, err
+	}
+	event := &db.AuditEvent{OccurredAt: now, Type: audit.KeyRotate, Outcome: audit.Success, Detail: next.ID}
+	if err := r.store.AppendAuditEvent(ctx, event); err != nil {
+		log.Error().Err(err).Msg("failed to record signing key rotation")
+	}
+
+	return next, r.Sync(ctx)
+}
+
+// Maintain keeps the keyring in sync with the store and, when
+// JWT_KEY_ROTATION_INTERVAL is set, rotates the active key once it gets
+// older than that. It never returns.
+func (r *Keyring) Maintain() {
+	ticker := time.NewTicker(r.cfg.JWTKeySyncInterval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	for ; ; <-ticker.C {
+		if err := r.Sync(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to sync signing keys")
+			continue
+		}
+		if !r.rotationDue() {
+			continue
+		}
+
+		key, err := r.Rotate(ctx)
+		if errors.Is(err, db.ErrSigningKeyConflict) {
+			// Another replica rotated first, its key is picked up on the next sync.
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("failed to rotate signing key")
+			continue
+		}
+		log.Info().Str("kid", key.ID).Msg("rotated signing key")
+	}
+}
+
+func (r *Keyring) rotationDue() bool {
+	if r.cfg.JWTKeyRotationInterval <= 0 {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.activeID == "" || time.Since(r.activeSince) >= r.cfg.JWTKeyRotationInterval
+}
+
+// rebuild replaces the keys of the ring with the static keys plus stored,
+// which must be ordered newest first.
+func (r *Keyring) rebuild(stored []db.SigningKey) error {
+	keys := make(map[string]ringKey, len(r.static)+len(stored))
+	for _, key := range r.static {
+		keys[key.ID] = ringKey{key: key}
+	}
+
+	active, activeID, activeSince := r.static[0], "", time.Time{}
+	for _, sk := range stored {
+		private, err := ParsePrivateKeyPEM([]byte(sk.PrivateKey))
+		if err != nil {
+			return fmt.Errorf("error parsing signing key %s: %v", sk.ID, err)
+		}
+		key, err := NewKey(sk.Algorithm, sk.ID, private)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = ringKey{key: key, expiresAt: sk.ExpiresAt}
+
+		if sk.RetiredAt == nil && activeID == "" {
+			active, activeID, activeSince = key, sk.ID, sk.CreatedAt
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = keys
+	r.active = active
+	r.activeID = activeID
+	r.activeSince = activeSince
+	r.lastSync = time.Now()
+	return nil
+}
+
+// verificationKey is the jwt.Keyfunc for tokens signed by this service. It
+// picks the key named by the kid header of token.
+func (r *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	// Tokens issued before kid headers were introduced carry none.
+	key := r.Active()
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = r.Lookup(kid); !ok {
+			return nil, errors.New("unknown signing key")
+		}
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.public, nil
+}
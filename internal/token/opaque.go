@@ -0,0 +1,24 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewOpaque returns a random, URL-safe token that carries no claims of its
+// own. Only its hash (see HashOpaque) should ever be persisted.
+func NewOpaque() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashOpaque returns the value under which an opaque token is stored.
+func HashOpaque(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,72 @@
+package token
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cvele/authentication-service/internal/config"
+	"github.com/dgrijalva/jwt-go/v4"
+)
+
+// PasswordChangeAudience is the audience of the restricted tokens issued to
+// users whose password expired. It is never one of JWT_AUDIENCES, so they
+// cannot pass as access tokens and only serve to change the password.
+const PasswordChangeAudience = "urn:authentication-service:password-change"
+
+// TypePasswordChange is the typ claim of password change tokens. Validate
+// rejects them whatever their audience.
+const TypePasswordChange = "password_change"
+
+type passwordChangeClaims struct {
+	Type string `json:"typ"`
+	jwt.StandardClaims
+}
+
+// NewPasswordChange mints the token allowing userID, who logged in with an
+// expired password, to change it.
+func NewPasswordChange(userID string, keys *Keyring, cfg *config.Config) (string, error) {
+	now := time.Now()
+	claims := &passwordChangeClaims{
+		Type: TypePasswordChange,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    cfg.JWTIssuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{PasswordChangeAudience},
+			IssuedAt:  jwt.At(now),
+			ExpiresAt: jwt.At(now.Add(cfg.PasswordChangeTokenTTL)),
+		},
+	}
+
+	key := keys.Active()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// ValidatePasswordChange checks the signature, issuer, type and lifetime of
+// a password change token and returns its claims.
+func ValidatePasswordChange(tokenString string, keys *Keyring, cfg *config.Config) (*jwt.StandardClaims, error) {
+	options := []jwt.ParserOption{jwt.WithLeeway(cfg.JWTLeeway), jwt.WithoutAudienceValidation()}
+	if cfg.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &passwordChangeClaims{}, keys.verificationKey, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*passwordChangeClaims)
+	if !ok || claims.Subject == "" {
+		return nil, errors.New("invalid token claims")
+	}
+	if claims.Type != TypePasswordChange {
+		return nil, errors.New("not a password change token")
+	}
+	// Unlike the parser's own check, this rejects tokens without audience.
+	if len(claims.Audience) != 1 || claims.Audience[0] != PasswordChangeAudience {
+		return nil, &jwt.InvalidAudienceError{Message: "token is not a password change token"}
+	}
+	return &claims.StandardClaims, nil
+}
@@ -1,51 +1,168 @@
 package token
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/cvele/authentication-service/internal/config"
 	"github.com/dgrijalva/jwt-go/v4"
+	"github.com/google/uuid"
 )
 
+// ErrRevoked is returned by Validate for tokens that were explicitly revoked.
+var ErrRevoked = errors.New("token has been revoked")
+
+// AdminPermission grants access to the admin API.
+const AdminPermission = "admin"
+
+// TypeAccess is the typ claim of access tokens. Validate accepts no other
+// token, whatever its audience, so that ID tokens and the tokens serving a
+// single purpose cannot pass as access tokens even when JWT_AUDIENCES is
+// empty.
+const TypeAccess = "access"
+
+// Claims are the claims of an access token. Tokens issued to a client on its
+// own behalf, through the client credentials grant, have no UserID.
 type Claims struct {
-	UserID string `json:"user_id"`
+	Type        string   `json:"typ"`
+	UserID      string   `json:"user_id,omitempty"`
+	ClientID    string   `json:"client_id,omitempty"`
+	Scope       string   `json:"scope,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
 	jwt.StandardClaims
 }
 
-func New(userID string, cfg *config.Config) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: jwt.At(time.Now().Add(cfg.TokenTTL)),
-		},
-	})
+// HasPermission reports whether the token grants permission.
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
 
-	return token.SignedString([]byte(cfg.JWTSecretKey))
+// Denylist reports whether an otherwise valid token has been revoked, either
+// individually by its jti, because every token of the user issued before a
+// certain point in time was revoked, or because the user no longer exists.
+type Denylist interface {
+	IsTokenRevoked(ctx context.Context, jti, userID string, issuedAt time.Time) (bool, error)
 }
 
-func Validate(tokenString string, cfg *config.Config) (string, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		_, ok := token.Method.(*jwt.SigningMethodHMAC)
-		if !ok {
-			return nil, errors.New("unexpected signing method")
+// New mints an access token carrying claims for audience, signed with the
+// active key of keys. The registered claims are filled in here: iss from
+// JWT_ISSUER, sub from the user id (or the client id if there is no user), a
+// fresh jti and the token lifetime. An empty audience selects the default,
+// the first of JWT_AUDIENCES.
+func New(claims *Claims, audience string, keys *Keyring, cfg *config.Config) (string, error) {
+	if audience == "" && len(cfg.JWTAudiences) > 0 {
+		audience = cfg.JWTAudiences[0]
+	}
+
+	now := time.Now()
+	claims.Type = TypeAccess
+	claims.Issuer = cfg.JWTIssuer
+	claims.Subject = claims.UserID
+	if claims.Subject == "" {
+		claims.Subject = claims.ClientID
+	}
+	claims.ID = uuid.New().String()
+	claims.IssuedAt = jwt.At(now)
+	claims.NotBefore = jwt.At(now)
+	claims.ExpiresAt = jwt.At(now.Add(cfg.TokenTTL))
+	claims.Audience = nil
+	if audience != "" {
+		claims.Audience = jwt.ClaimStrings{audience}
+	}
+
+	key := keys.Active()
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.private)
+}
+
+// AllowedAudience reports whether tokens may be issued for audience. An empty
+// audience always is, it selects the default one.
+func AllowedAudience(audience string, cfg *config.Config) bool {
+	if audience == "" {
+		return true
+	}
+	for _, allowed := range cfg.JWTAudiences {
+		if audience == allowed {
+			return true
 		}
+	}
+	return false
+}
 
-		return []byte(cfg.JWTSecretKey), nil
-	})
+// Validate checks the signature of tokenString against the key of keys named
+// by its kid header, its issuer, audience and lifetime (allowing for
+// JWT_LEEWAY of clock skew), that it is an access token and, when denylist is
+// not nil, that it has not been revoked. When audience is empty any of
+// JWT_AUDIENCES is accepted.
+func Validate(ctx context.Context, tokenString, audience string, keys *Keyring, denylist Denylist, cfg *config.Config) (*Claims, error) {
+	options := []jwt.ParserOption{jwt.WithLeeway(cfg.JWTLeeway), jwt.WithoutAudienceValidation()}
+	if cfg.JWTIssuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.verificationKey, options...)
 
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	claims, ok := token.Claims.(*Claims)
 	if !ok {
-		return "", errors.New("invalid token claims")
+		return nil, errors.New("invalid token claims")
+	}
+	switch claims.Type {
+	case TypeAccess:
+	case TypePasswordChange:
+		return nil, errors.New("password change tokens can only be used to change the password")
+	default:
+		return nil, errors.New("not an access token")
+	}
+
+	if err := validateAudience(claims, audience, cfg); err != nil {
+		return nil, err
+	}
+
+	if denylist != nil {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+		revoked, err := denylist.IsTokenRevoked(ctx, claims.ID, claims.UserID, issuedAt)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrRevoked
+		}
 	}
 
-	if err := claims.Valid(&jwt.ValidationHelper{}); err != nil {
-		return "", err
+	return claims, nil
+}
+
+func validateAudience(claims *Claims, audience string, cfg *config.Config) error {
+	if audience != "" {
+		if len(claims.Audience) == 0 {
+			return &jwt.InvalidAudienceError{Message: "token has no audience"}
+		}
+		return claims.VerifyAudience(jwt.DefaultValidationHelper, audience)
+	}
+	if len(cfg.JWTAudiences) == 0 {
+		return nil
 	}
 
-	return claims.UserID, nil
+	for _, aud := range claims.Audience {
+		if AllowedAudience(aud, cfg) {
+			return nil
+		}
+	}
+	return &jwt.InvalidAudienceError{Message: "token is not intended for any known audience"}
 }
@@ -0,0 +1,97 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as used by
+// authenticator apps: HMAC-SHA1, 6 digits and a 30 second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// Skew is how many periods before and after the current one are accepted, to
+// allow for clock drift and the time it takes to type the code.
+const Skew = 1
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded as authenticator
+// apps expect it.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns the otpauth:// URI for secret, which authenticator apps import
+// from a QR code.
+func URI(issuer, account, secret string) string {
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period.Seconds()))},
+	}
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	// Not every app decodes + in the query as a space.
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// Step returns the time step t falls into.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for secret at the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Validate checks code against secret at time t, allowing for Skew. It
+// returns the time step the code belongs to, so that callers can refuse to
+// accept a code, or an earlier one, twice.
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
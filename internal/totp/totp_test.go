@@ -0,0 +1,159 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed of the test vectors of RFC 6238 appendix B,
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The 8 digit codes of RFC 6238 appendix B, cut to the last 6 digits as
+	// RFC 4226 truncation does.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAcceptsLowerCaseSecrets(t *testing.T) {
+	got, err := Code(strings.ToLower(rfcSecret), Step(time.Unix(59, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "287082" {
+		t.Errorf("Code() = %s, want 287082", got)
+	}
+}
+
+func TestCodeRejectsInvalidSecrets(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code() did not fail")
+	}
+}
+
+func TestStep(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want int64
+	}{
+		{0, 0},
+		{29, 0},
+		{30, 1},
+		{59, 1},
+		{1111111109, 37037036},
+	}
+	for _, tt := range tests {
+		if got := Step(time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("Step(%d) = %d, want %d", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	tests := []struct {
+		name   string
+		offset int64
+		want   bool
+	}{
+		{"two periods before", -2, false},
+		{"previous period", -1, true},
+		{"current period", 0, true},
+		{"next period", 1, true},
+		{"two periods after", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfcSecret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := Validate(rfcSecret, code, now)
+			if ok != tt.want {
+				t.Fatalf("Validate() ok = %v, want %v", ok, tt.want)
+			}
+			// Callers refuse replays by the step returned.
+			if ok && step != current+tt.offset {
+				t.Errorf("Validate() step = %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateRejectsInvalidCodes(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "94287082", "287083", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("Validate(%q) accepted the code", code)
+		}
+	}
+	if _, ok := Validate("not base32!", "287082", now); ok {
+		t.Error("Validate() accepted a code for an invalid secret")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("GenerateSecret() returned the same secret twice")
+	}
+	// 160 bits, as RFC 4226 recommends.
+	if key, err := encoding.DecodeString(a); err != nil || len(key) != 20 {
+		t.Errorf("GenerateSecret() = %q, want 20 base32 encoded bytes", a)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Example Co", "jane@example.com", rfcSecret)
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Example Co:jane@example.com" {
+		t.Errorf("URI() = %s", uri)
+	}
+	if strings.Contains(uri, "+") {
+		t.Errorf("URI() = %s, want spaces encoded as %%20", uri)
+	}
+	query := u.Query()
+	for key, want := range map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "Example Co",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	} {
+		if got := query.Get(key); got != want {
+			t.Errorf("URI() %s = %q, want %q", key, got, want)
+		}
+	}
+}
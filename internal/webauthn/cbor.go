@@ -0,0 +1,183 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth bounds the nesting of decoded CBOR items.
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR item of data and returns it along with
+// the bytes that follow it. It supports the subset of CBOR (RFC 8949) that
+// authenticators produce, which is always definite length. Integers decode
+// to int64, byte strings to []byte, text to string, arrays to
+// []interface{} and maps to map[interface{}]interface{}.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: nested too deeply")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	// Simple values and floats use the additional information differently.
+	if major == 7 {
+		return decodeCBORSimple(info, data)
+	}
+
+	arg, data, err := decodeCBORArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: integer overflows int64")
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		value := data[:arg]
+		if major == 3 {
+			return string(value), data[arg:], nil
+		}
+		return append([]byte{}, value...), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key type %T", key)
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, data, nil
+	default:
+		// Tags carry no meaning for WebAuthn, only the tagged item matters.
+		return decodeCBORItem(data, depth+1)
+	}
+}
+
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errors.New("cbor: indefinite length items are not supported")
+	}
+}
+
+func decodeCBORSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 25:
+		if len(data) < 2 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(float16(binary.BigEndian.Uint16(data))), data[2:], nil
+	case 26:
+		if len(data) < 4 {
+			return nil, nil, errCBORTruncated
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+	case 27:
+		if len(data) < 8 {
+			return nil, nil, errCBORTruncated
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+}
+
+func float16(bits uint16) float64 {
+	exponent := int(bits>>10) & 0x1f
+	mantissa := float64(bits & 0x3ff)
+	var value float64
+	switch exponent {
+	case 0:
+		value = math.Ldexp(mantissa, -24)
+	case 31:
+		if mantissa == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mantissa+1024, exponent-25)
+	}
+	if bits&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,206 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// The examples of RFC 8949 appendix A that authenticators may produce.
+	tests := []struct {
+		in   string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"01", int64(1)},
+		{"0a", int64(10)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1819", int64(25)},
+		{"1864", int64(100)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"29", int64(-10)},
+		{"3863", int64(-100)},
+		{"3903e7", int64(-1000)},
+		{"f90000", 0.0},
+		{"f93c00", 1.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f93e00", 1.5},
+		{"f97bff", 65504.0},
+		{"fa47c35000", 100000.0},
+		{"fa7f7fffff", 3.4028234663852886e+38},
+		{"fb7e37e43c8800759c", 1.0e+300},
+		{"f90001", 5.960464477539063e-8},
+		{"f90400", 0.00006103515625},
+		{"f9c400", -4.0},
+		{"fbc010666666666666", -4.1},
+		{"f97c00", math.Inf(1)},
+		{"f9fc00", math.Inf(-1)},
+		{"fa7f800000", math.Inf(1)},
+		{"fbfff0000000000000", math.Inf(-1)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		// Tags are skipped.
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+		{"c11a514b67b0", int64(1363896240)},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", "http://www.example.com"},
+		{"40", []byte{}},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"60", ""},
+		{"6161", "a"},
+		{"6449455446", "IETF"},
+		{"62225c", "\"\\"},
+		{"62c3bc", "ü"},
+		{"63e6b0b4", "水"},
+		{"64f0908591", "\U00010151"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"98190102030405060708090a0b0c0d0e0f101112131415161718181819", func() []interface{} {
+			items := []interface{}{}
+			for i := int64(1); i <= 25; i++ {
+				items = append(items, i)
+			}
+			return items
+		}()},
+		{"a0", map[interface{}]interface{}{}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"826161a161626163", []interface{}{"a", map[interface{}]interface{}{"b": "c"}}},
+		{"a56161614161626142616361436164614461656145", map[interface{}]interface{}{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}},
+	}
+	for _, tt := range tests {
+		got, rest, err := decodeCBOR(mustHex(t, tt.in))
+		if err != nil {
+			t.Errorf("decodeCBOR(%s) error = %v", tt.in, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("decodeCBOR(%s) left %x", tt.in, rest)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeCBOR(%s) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORNaN(t *testing.T) {
+	for _, in := range []string{"f97e00", "fa7fc00000", "fb7ff8000000000000"} {
+		got, _, err := decodeCBOR(mustHex(t, in))
+		if f, ok := got.(float64); err != nil || !ok || !math.IsNaN(f) {
+			t.Errorf("decodeCBOR(%s) = %v, %v, want NaN", in, got, err)
+		}
+	}
+	got, _, err := decodeCBOR(mustHex(t, "f98000"))
+	if f, ok := got.(float64); err != nil || !ok || f != 0 || !math.Signbit(f) {
+		t.Errorf("decodeCBOR(f98000) = %v, %v, want -0", got, err)
+	}
+}
+
+func TestDecodeCBORReturnsTheRest(t *testing.T) {
+	got, rest, err := decodeCBOR(mustHex(t, "820102ff00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []interface{}{int64(1), int64(2)}) || !bytes.Equal(rest, []byte{0xff, 0x00}) {
+		t.Errorf("decodeCBOR() = %v, %x", got, rest)
+	}
+}
+
+func TestDecodeCBORRejectsMalformedInput(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"empty", ""},
+		{"integer overflowing int64", "1bffffffffffffffff"},
+		{"negative integer overflowing int64", "3bffffffffffffffff"},
+		{"smallest int64 minus one", "3b8000000000000000"},
+		{"indefinite byte string", "5f42010243030405ff"},
+		{"indefinite text", "7f657374726561646d696e67ff"},
+		{"indefinite array", "9fff"},
+		{"indefinite map", "bf6346756ef563416d7421ff"},
+		{"reserved additional information", "1c"},
+		{"simple value", "f0"},
+		{"two byte simple value", "f8ff"},
+		{"byte string map key", "a14001"},
+		{"array map key", "a18001"},
+		{"float map key", "a1f93c0001"},
+		{"argument truncated", "19ff"},
+		{"float truncated", "fb3ff1"},
+		{"byte string truncated", "4401020304"[:8]},
+		{"text truncated", "6449455446"[:6]},
+		{"array truncated", "83010203"[:6]},
+		{"map value missing", "a201020304"[:8]},
+		{"tag without item", "c1"},
+		// Lengths far beyond the data must fail before anything of that
+		// size is allocated.
+		{"huge byte string", "5bffffffffffffffff"},
+		{"huge text", "7bffffffffffffffff"},
+		{"huge array", "9bffffffffffffffff"},
+		{"huge map", "bbffffffffffffffff"},
+		{"array longer than the data", "9a00010000" + strings.Repeat("00", 100)},
+		{"map longer than the data", "ba00010000" + strings.Repeat("00", 100)},
+		{"nested too deeply", strings.Repeat("81", maxCBORDepth+1) + "00"},
+		{"tags nested too deeply", strings.Repeat("c1", maxCBORDepth+1) + "00"},
+		{"maps nested too deeply", strings.Repeat("a100", maxCBORDepth+1) + "00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _, err := decodeCBOR(mustHex(t, tt.in)); err == nil {
+				t.Errorf("decodeCBOR(%s) = %#v, want an error", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestDecodeCBORNestingLimit(t *testing.T) {
+	in := mustHex(t, strings.Repeat("81", maxCBORDepth)+"00")
+	if _, _, err := decodeCBOR(in); err != nil {
+		t.Errorf("decodeCBOR() of items nested %d deep error = %v", maxCBORDepth, err)
+	}
+}
+
+func TestDecodeCBORTruncated(t *testing.T) {
+	// Every prefix of a valid item is an error, never a panic.
+	in := mustHex(t, "a3"+"016161"+"02824401020304a120fb3ff199999999999a"+"03d8206468747470")
+	if _, _, err := decodeCBOR(in); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(in); i++ {
+		if _, _, err := decodeCBOR(in[:i]); err == nil {
+			t.Errorf("decodeCBOR() of the first %d bytes did not fail", i)
+		}
+	}
+}
+
+func TestDecodeCBORRandomInput(t *testing.T) {
+	// Whatever an attacker sends, decoding fails or succeeds without a
+	// panic.
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		in := make([]byte, random.Intn(64))
+		random.Read(in)
+		decodeCBOR(in)
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,414 @@
+// Package webauthn verifies WebAuthn (passkey) registration and assertion
+// ceremonies, as described in the Web Authentication Level 2 specification.
+//
+// Attestation statements are not verified: the relying party asks for "none"
+// attestation and trusts any authenticator the user chooses.
+package webauthn
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithm identifiers of the supported public key types.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// Algorithms lists the supported COSE algorithms in order of preference.
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// ErrVerification is wrapped by every error reporting a ceremony that does not
+// verify, as opposed to one that is malformed.
+var ErrVerification = errors.New("webauthn: verification failed")
+
+// RelyingParty describes this service to authenticators. Origins lists the
+// web origins ceremonies may be performed from.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions are the JSON encoded PublicKeyCredentialCreationOptions
+// for navigator.credentials.create(). Binary values are base64url encoded.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the JSON encoded PublicKeyCredentialRequestOptions for
+// navigator.credentials.get(). Binary values are base64url encoded.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AttestationObject string `json:"attestationObject"`
+}
+
+// RegistrationCredential is the JSON encoded PublicKeyCredential returned by
+// navigator.credentials.create().
+type RegistrationCredential struct {
+	ID       string              `json:"id"`
+	RawID    string              `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+// AssertionCredential is the JSON encoded PublicKeyCredential returned by
+// navigator.credentials.get().
+type AssertionCredential struct {
+	ID       string            `json:"id"`
+	RawID    string            `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// Credential is a newly registered credential. ID is base64url encoded and
+// PublicKey holds the COSE encoded key.
+type Credential struct {
+	ID        string
+	PublicKey []byte
+	Algorithm int
+	SignCount uint32
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// Challenge returns the challenge a ceremony response was made for, so that
+// the state of the ceremony can be looked up before it is verified.
+func Challenge(clientDataJSON string) (string, error) {
+	data, err := decodeBase64(clientDataJSON)
+	if err != nil {
+		return "", err
+	}
+	var cd clientData
+	if err := json.Unmarshal(data, &cd); err != nil {
+		return "", fmt.Errorf("webauthn: invalid client data: %v", err)
+	}
+	return cd.Challenge, nil
+}
+
+// VerifyRegistration verifies the response of a registration ceremony
+// started with challenge and returns the new credential. User verification
+// is required when requireUV is set, user presence always is.
+func (rp *RelyingParty) VerifyRegistration(cred *RegistrationCredential, challenge string, requireUV bool) (*Credential, error) {
+	if cred.Type != "public-key" {
+		return nil, fmt.Errorf("%w: unexpected credential type %q", ErrVerification, cred.Type)
+	}
+	if err := rp.verifyClientData(cred.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	raw, err := decodeBase64(cred.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	decoded, _, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+	rawAuthData, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return nil, err
+	}
+	if authData.credentialID == nil {
+		return nil, errors.New("webauthn: authenticator data has no attested credential")
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(authData.credentialID)
+	if cred.ID != id {
+		return nil, fmt.Errorf("%w: credential ID mismatch", ErrVerification)
+	}
+
+	algorithm, _, err := parseCOSEKey(authData.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        id,
+		PublicKey: authData.publicKey,
+		Algorithm: algorithm,
+		SignCount: authData.signCount,
+	}, nil
+}
+
+// VerifyAssertion verifies the response of an authentication ceremony
+// started with challenge against the COSE encoded publicKey of the credential
+// used, and returns the new signature counter of the authenticator.
+func (rp *RelyingParty) VerifyAssertion(cred *AssertionCredential, challenge string, publicKey []byte, requireUV bool) (uint32, error) {
+	if cred.Type != "public-key" {
+		return 0, fmt.Errorf("%w: unexpected credential type %q", ErrVerification, cred.Type)
+	}
+	if err := rp.verifyClientData(cred.Response.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	rawAuthData, err := decodeBase64(cred.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := rp.verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	clientDataJSON, err := decodeBase64(cred.Response.ClientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+	signature, err := decodeBase64(cred.Response.Signature)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, rawAuthData...), clientDataHash[:]...)
+
+	algorithm, key, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	if !verifySignature(algorithm, key, signed, signature) {
+		return 0, fmt.Errorf("%w: invalid signature", ErrVerification)
+	}
+
+	return authData.signCount, nil
+}
+
+func (rp *RelyingParty) verifyClientData(encoded, ceremony, challenge string) error {
+	data, err := decodeBase64(encoded)
+	if err != nil {
+		return err
+	}
+	var cd clientData
+	if err := json.Unmarshal(data, &cd); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %v", err)
+	}
+
+	if cd.Type != ceremony {
+		return fmt.Errorf("%w: unexpected ceremony %q", ErrVerification, cd.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrVerification)
+	}
+	for _, origin := range rp.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unexpected origin %q", ErrVerification, cd.Origin)
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(authData *authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData.rpIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: credential is scoped to another relying party", ErrVerification)
+	}
+	if authData.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user was not present", ErrVerification)
+	}
+	if requireUV && authData.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user was not verified", ErrVerification)
+	}
+	return nil
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("webauthn: authenticator data is too short")
+	}
+	authData := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+
+	if authData.flags&flagAttestedData != 0 {
+		rest := data[37:]
+		// AAGUID followed by the length of the credential ID.
+		if len(rest) < 18 {
+			return nil, errors.New("webauthn: attested credential data is too short")
+		}
+		length := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < length {
+			return nil, errors.New("webauthn: attested credential data is too short")
+		}
+		authData.credentialID = rest[:length]
+		rest = rest[length:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		authData.publicKey = rest[:len(rest)-len(after)]
+	}
+
+	return authData, nil
+}
+
+// parseCOSEKey decodes a COSE_Key (RFC 8152) of one of the supported
+// algorithms.
+func parseCOSEKey(data []byte) (int, crypto.PublicKey, error) {
+	decoded, _, err := decodeCBOR(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, errors.New("webauthn: invalid COSE key")
+	}
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+
+	switch {
+	case kty == 2 && alg == AlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return 0, nil, errors.New("webauthn: invalid P-256 key")
+		}
+		public := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return 0, nil, errors.New("webauthn: invalid P-256 key")
+		}
+		return AlgES256, public, nil
+	case kty == 1 && alg == AlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return 0, nil, errors.New("webauthn: invalid Ed25519 key")
+		}
+		return AlgEdDSA, ed25519.PublicKey(x), nil
+	case kty == 3 && alg == AlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return 0, nil, errors.New("webauthn: invalid RSA key")
+		}
+		return AlgRS256, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	default:
+		return 0, nil, fmt.Errorf("webauthn: unsupported COSE key type %d with algorithm %d", kty, alg)
+	}
+}
+
+func verifySignature(algorithm int, key crypto.PublicKey, signed, signature []byte) bool {
+	switch algorithm {
+	case AlgES256:
+		digest := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], signature)
+	case AlgEdDSA:
+		return ed25519.Verify(key.(ed25519.PublicKey), signed, signature)
+	case AlgRS256:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	return false
+}
+
+// decodeBase64 decodes base64url, which WebAuthn uses throughout, with or
+// without padding.
+func decodeBase64(s string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid base64url: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,502 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+var testRP = &RelyingParty{ID: testRPID, Name: "Example", Origins: []string{testOrigin, "https://login.example.com"}}
+
+func TestVerifyRegistration(t *testing.T) {
+	for _, alg := range Algorithms {
+		a := newAuthenticator(t, alg)
+		cred, err := testRP.VerifyRegistration(a.register(t, "challenge", testOrigin), "challenge", true)
+		if err != nil {
+			t.Fatalf("alg %d: VerifyRegistration() error = %v", alg, err)
+		}
+		if cred.ID != base64.RawURLEncoding.EncodeToString(a.credentialID) || cred.Algorithm != alg || cred.SignCount != a.signCount {
+			t.Errorf("alg %d: VerifyRegistration() = %+v", alg, cred)
+		}
+		if string(cred.PublicKey) != string(a.publicKey) {
+			t.Errorf("alg %d: public key %x, want %x", alg, cred.PublicKey, a.publicKey)
+		}
+	}
+}
+
+func TestVerifyRegistrationRejectsInvalidResponses(t *testing.T) {
+	a := newAuthenticator(t, AlgES256)
+	tests := []struct {
+		name         string
+		change       func(cred *RegistrationCredential)
+		verification bool
+	}{
+		{"other credential type", func(cred *RegistrationCredential) { cred.Type = "password" }, true},
+		{"other challenge", func(cred *RegistrationCredential) {
+			cred.Response.ClientDataJSON = clientDataJSON("webauthn.create", "other", testOrigin)
+		}, true},
+		{"other origin", func(cred *RegistrationCredential) {
+			cred.Response.ClientDataJSON = clientDataJSON("webauthn.create", "challenge", "https://evil.example")
+		}, true},
+		{"assertion client data", func(cred *RegistrationCredential) {
+			cred.Response.ClientDataJSON = clientDataJSON("webauthn.get", "challenge", testOrigin)
+		}, true},
+		{"other relying party", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = a.attestationObject(a.attestedData("evil.example", flagUserPresent|flagUserVerified))
+		}, true},
+		{"user not present", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = a.attestationObject(a.attestedData(testRPID, flagUserVerified))
+		}, true},
+		{"user not verified", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = a.attestationObject(a.attestedData(testRPID, flagUserPresent))
+		}, true},
+		{"other credential ID", func(cred *RegistrationCredential) { cred.ID = "AAAA" }, true},
+		{"no attested credential", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = a.attestationObject(a.authData(testRPID, flagUserPresent|flagUserVerified))
+		}, false},
+		{"no authenticator data", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(encode(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}}))
+		}, false},
+		{"attestation object not a map", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = base64.RawURLEncoding.EncodeToString(encode([]interface{}{1}))
+		}, false},
+		{"attestation object truncated", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = cred.Response.AttestationObject[:len(cred.Response.AttestationObject)/2]
+		}, false},
+		{"attestation object not base64url", func(cred *RegistrationCredential) {
+			cred.Response.AttestationObject = "not base64!"
+		}, false},
+		{"client data not JSON", func(cred *RegistrationCredential) {
+			cred.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString([]byte("{"))
+		}, false},
+		{"unsupported key", func(cred *RegistrationCredential) {
+			b := newAuthenticator(t, AlgES256)
+			b.publicKey = encode(cborMap{{1, 2}, {3, -36}})
+			cred.Response.AttestationObject = b.attestationObject(b.attestedData(testRPID, flagUserPresent|flagUserVerified))
+			cred.ID = base64.RawURLEncoding.EncodeToString(b.credentialID)
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := a.register(t, "challenge", testOrigin)
+			tt.change(cred)
+			_, err := testRP.VerifyRegistration(cred, "challenge", true)
+			if err == nil {
+				t.Fatal("VerifyRegistration() did not fail")
+			}
+			if errors.Is(err, ErrVerification) != tt.verification {
+				t.Errorf("VerifyRegistration() error = %v, want wrapping ErrVerification %v", err, tt.verification)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationWithoutUserVerification(t *testing.T) {
+	a := newAuthenticator(t, AlgEdDSA)
+	cred := a.register(t, "challenge", testOrigin)
+	cred.Response.AttestationObject = a.attestationObject(a.attestedData(testRPID, flagUserPresent))
+	if _, err := testRP.VerifyRegistration(cred, "challenge", false); err != nil {
+		t.Errorf("VerifyRegistration() error = %v", err)
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	for _, alg := range Algorithms {
+		a := newAuthenticator(t, alg)
+		for _, origin := range testRP.Origins {
+			signCount, err := testRP.VerifyAssertion(a.assert(t, "challenge", origin, flagUserPresent|flagUserVerified), "challenge", a.publicKey, true)
+			if err != nil {
+				t.Fatalf("alg %d: VerifyAssertion() error = %v", alg, err)
+			}
+			if signCount != a.signCount {
+				t.Errorf("alg %d: sign count %d, want %d", alg, signCount, a.signCount)
+			}
+		}
+	}
+}
+
+func TestVerifyAssertionRejectsInvalidResponses(t *testing.T) {
+	a := newAuthenticator(t, AlgES256)
+	other := newAuthenticator(t, AlgES256)
+	tests := []struct {
+		name         string
+		cred         func() *AssertionCredential
+		verification bool
+	}{
+		{"other challenge", func() *AssertionCredential {
+			return a.assert(t, "other", testOrigin, flagUserPresent|flagUserVerified)
+		}, true},
+		{"other origin", func() *AssertionCredential {
+			return a.assert(t, "challenge", "https://evil.example", flagUserPresent|flagUserVerified)
+		}, true},
+		{"registration client data", func() *AssertionCredential {
+			cred := a.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+			cred.Response.ClientDataJSON = clientDataJSON("webauthn.create", "challenge", testOrigin)
+			return cred
+		}, true},
+		{"user not present", func() *AssertionCredential {
+			return a.assert(t, "challenge", testOrigin, flagUserVerified)
+		}, true},
+		{"user not verified", func() *AssertionCredential {
+			return a.assert(t, "challenge", testOrigin, flagUserPresent)
+		}, true},
+		{"signed by another key", func() *AssertionCredential {
+			return other.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+		}, true},
+		{"authenticator data altered", func() *AssertionCredential {
+			cred := a.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+			authData, _ := decodeBase64(cred.Response.AuthenticatorData)
+			authData[36]++
+			cred.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+			return cred
+		}, true},
+		{"signature altered", func() *AssertionCredential {
+			cred := a.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+			signature, _ := decodeBase64(cred.Response.Signature)
+			signature[len(signature)-1]++
+			cred.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+			return cred
+		}, true},
+		{"other credential type", func() *AssertionCredential {
+			cred := a.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+			cred.Type = "password"
+			return cred
+		}, true},
+		{"authenticator data too short", func() *AssertionCredential {
+			cred := a.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+			cred.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(make([]byte, 36))
+			return cred
+		}, false},
+		{"signature not base64url", func() *AssertionCredential {
+			cred := a.assert(t, "challenge", testOrigin, flagUserPresent|flagUserVerified)
+			cred.Response.Signature = "not base64!"
+			return cred
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testRP.VerifyAssertion(tt.cred(), "challenge", a.publicKey, true)
+			if err == nil {
+				t.Fatal("VerifyAssertion() did not fail")
+			}
+			if errors.Is(err, ErrVerification) != tt.verification {
+				t.Errorf("VerifyAssertion() error = %v, want wrapping ErrVerification %v", err, tt.verification)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionAcceptsPaddedBase64(t *testing.T) {
+	a := newAuthenticator(t, AlgEdDSA)
+	cred := a.assert(t, "challenge", testOrigin, flagUserPresent)
+	for _, field := range []*string{&cred.Response.ClientDataJSON, &cred.Response.AuthenticatorData, &cred.Response.Signature} {
+		if n := len(*field) % 4; n != 0 {
+			*field += strings.Repeat("=", 4-n)
+		}
+	}
+	if _, err := testRP.VerifyAssertion(cred, "challenge", a.publicKey, false); err != nil {
+		t.Errorf("VerifyAssertion() error = %v", err)
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	challenge, err := Challenge(clientDataJSON("webauthn.get", "abc", testOrigin))
+	if err != nil || challenge != "abc" {
+		t.Errorf("Challenge() = %q, %v, want abc", challenge, err)
+	}
+	for _, encoded := range []string{"not base64!", base64.RawURLEncoding.EncodeToString([]byte("[]"))} {
+		if _, err := Challenge(encoded); err == nil {
+			t.Errorf("Challenge(%q) did not fail", encoded)
+		}
+	}
+}
+
+func TestParseCOSEKey(t *testing.T) {
+	// The P-256 key of RFC 8152 appendix C.7.1.
+	x := mustHex(t, "65eda5a12577c2bae829437fe338701a10aaa375e1bb5b5de108de439c08551d")
+	y := mustHex(t, "1e52ed75701163f7f9e40ddf9f341b3dc9ba860af7e0ca7ca7e9eecd0084d19c")
+	// The public key of the first test of RFC 8032 section 7.1.
+	ed := mustHex(t, "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a")
+	offCurve := append([]byte{}, y...)
+	offCurve[31] ^= 1
+
+	tests := []struct {
+		name    string
+		key     cborMap
+		wantAlg int
+	}{
+		{"P-256", cborMap{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x}, {-3, y}}, AlgES256},
+		{"Ed25519", cborMap{{1, 1}, {3, AlgEdDSA}, {-1, 6}, {-2, ed}}, AlgEdDSA},
+		{"RSA", cborMap{{1, 3}, {3, AlgRS256}, {-1, make([]byte, 256)}, {-2, []byte{1, 0, 1}}}, AlgRS256},
+		{"point off the curve", cborMap{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x}, {-3, offCurve}}, 0},
+		{"other curve", cborMap{{1, 2}, {3, AlgES256}, {-1, 2}, {-2, x}, {-3, y}}, 0},
+		{"short coordinate", cborMap{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, x[1:]}, {-3, y}}, 0},
+		{"Ed448", cborMap{{1, 1}, {3, AlgEdDSA}, {-1, 7}, {-2, ed}}, 0},
+		{"short Ed25519 key", cborMap{{1, 1}, {3, AlgEdDSA}, {-1, 6}, {-2, ed[1:]}}, 0},
+		{"RSA key under 2048 bits", cborMap{{1, 3}, {3, AlgRS256}, {-1, make([]byte, 128)}, {-2, []byte{1, 0, 1}}}, 0},
+		{"RSA exponent 1", cborMap{{1, 3}, {3, AlgRS256}, {-1, make([]byte, 256)}, {-2, []byte{1}}}, 0},
+		{"huge RSA exponent", cborMap{{1, 3}, {3, AlgRS256}, {-1, make([]byte, 256)}, {-2, make([]byte, 9)}}, 0},
+		{"key type and algorithm mismatch", cborMap{{1, 2}, {3, AlgEdDSA}, {-1, 6}, {-2, ed}}, 0},
+		{"unsupported algorithm", cborMap{{1, 2}, {3, -35}, {-1, 2}, {-2, x}, {-3, y}}, 0},
+		{"no fields", cborMap{}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg, _, err := parseCOSEKey(encode(tt.key))
+			if tt.wantAlg == 0 {
+				if err == nil {
+					t.Error("parseCOSEKey() did not fail")
+				}
+				return
+			}
+			if err != nil || alg != tt.wantAlg {
+				t.Errorf("parseCOSEKey() = %d, %v, want %d", alg, err, tt.wantAlg)
+			}
+		})
+	}
+	if _, _, err := parseCOSEKey(mustHex(t, "8101")); err == nil {
+		t.Error("parseCOSEKey() of an array did not fail")
+	}
+}
+
+func TestVerifySignatureEd25519(t *testing.T) {
+	// The first test of RFC 8032 section 7.1, signing the empty message.
+	public := ed25519.PublicKey(mustHex(t, "d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a"))
+	signature := mustHex(t, "e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b")
+	if !verifySignature(AlgEdDSA, public, nil, signature) {
+		t.Error("signature of RFC 8032 refused")
+	}
+	if verifySignature(AlgEdDSA, public, []byte{0}, signature) {
+		t.Error("signature accepted for another message")
+	}
+	if verifySignature(-35, public, nil, signature) {
+		t.Error("signature accepted for an unsupported algorithm")
+	}
+}
+
+func TestParseAuthenticatorData(t *testing.T) {
+	a := newAuthenticator(t, AlgES256)
+	attested := a.attestedData(testRPID, flagUserPresent)
+	authData, err := parseAuthenticatorData(attested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(authData.credentialID) != string(a.credentialID) || string(authData.publicKey) != string(a.publicKey) {
+		t.Errorf("parseAuthenticatorData() = %+v", authData)
+	}
+
+	// Every cut of attested credential data is refused.
+	for i := 37; i < len(attested); i++ {
+		if _, err := parseAuthenticatorData(attested[:i]); err == nil {
+			t.Errorf("parseAuthenticatorData() of the first %d bytes did not fail", i)
+		}
+	}
+	// A credential ID length beyond the data as well.
+	huge := append([]byte{}, attested...)
+	binary.BigEndian.PutUint16(huge[37+16:], 0xffff)
+	if _, err := parseAuthenticatorData(huge); err == nil {
+		t.Error("parseAuthenticatorData() with a huge credential ID did not fail")
+	}
+}
+
+// authenticator is a software authenticator, producing ceremony responses as
+// a browser would return them for a platform or roaming authenticator.
+type authenticator struct {
+	alg          int
+	signer       crypto.Signer
+	credentialID []byte
+	publicKey    []byte
+	signCount    uint32
+}
+
+func newAuthenticator(t *testing.T, alg int) *authenticator {
+	t.Helper()
+	a := &authenticator{alg: alg, credentialID: make([]byte, 16), signCount: 7}
+	if _, err := rand.Read(a.credentialID); err != nil {
+		t.Fatal(err)
+	}
+	switch alg {
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.signer = key
+		a.publicKey = encode(cborMap{{1, 2}, {3, alg}, {-1, 1}, {-2, pad(key.X, 32)}, {-3, pad(key.Y, 32)}})
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.signer = private
+		a.publicKey = encode(cborMap{{1, 1}, {3, alg}, {-1, 6}, {-2, []byte(public)}})
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.signer = key
+		a.publicKey = encode(cborMap{{1, 3}, {3, alg}, {-1, key.N.Bytes()}, {-2, big.NewInt(int64(key.E)).Bytes()}})
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	return a
+}
+
+func (a *authenticator) register(t *testing.T, challenge, origin string) *RegistrationCredential {
+	t.Helper()
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	return &RegistrationCredential{
+		ID:    id,
+		RawID: id,
+		Type:  "public-key",
+		Response: AttestationResponse{
+			ClientDataJSON:    clientDataJSON("webauthn.create", challenge, origin),
+			AttestationObject: a.attestationObject(a.attestedData(testRPID, flagUserPresent|flagUserVerified)),
+		},
+	}
+}
+
+func (a *authenticator) assert(t *testing.T, challenge, origin string, flags byte) *AssertionCredential {
+	t.Helper()
+	a.signCount++
+	authData := a.authData(testRPID, flags)
+	clientData := clientDataJSON("webauthn.get", challenge, origin)
+	clientDataHash := sha256.Sum256(mustBase64(t, clientData))
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	var signature []byte
+	var err error
+	if a.alg == AlgEdDSA {
+		signature, err = a.signer.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		signature, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(a.credentialID)
+	return &AssertionCredential{
+		ID:    id,
+		RawID: id,
+		Type:  "public-key",
+		Response: AssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(signature),
+		},
+	}
+}
+
+// authData returns authenticator data without attested credential data.
+func (a *authenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+// attestedData returns authenticator data with the credential attested.
+func (a *authenticator) attestedData(rpID string, flags byte) []byte {
+	data := a.authData(rpID, flags|flagAttestedData)
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+	data = append(data, a.credentialID...)
+	return append(data, a.publicKey...)
+}
+
+func (a *authenticator) attestationObject(authData []byte) string {
+	return base64.RawURLEncoding.EncodeToString(encode(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", authData}}))
+}
+
+func clientDataJSON(ceremony, challenge, origin string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        ceremony,
+		"challenge":   challenge,
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// cborMap is a CBOR map whose entries are encoded in the order given.
+type cborMap [][2]interface{}
+
+// encode returns v encoded as CBOR. It supports what authenticators
+// produce.
+func encode(v interface{}) []byte {
+	return appendCBOR(nil, v)
+}
+
+func appendCBOR(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return appendCBORHead(b, 1, uint64(-1-v))
+		}
+		return appendCBORHead(b, 0, uint64(v))
+	case []byte:
+		return append(appendCBORHead(b, 2, uint64(len(v))), v...)
+	case string:
+		return append(appendCBORHead(b, 3, uint64(len(v))), v...)
+	case []interface{}:
+		b = appendCBORHead(b, 4, uint64(len(v)))
+		for _, item := range v {
+			b = appendCBOR(b, item)
+		}
+		return b
+	case cborMap:
+		b = appendCBORHead(b, 5, uint64(len(v)))
+		for _, entry := range v {
+			b = appendCBOR(appendCBOR(b, entry[0]), entry[1])
+		}
+		return b
+	}
+	panic("unsupported type")
+}
+
+func appendCBORHead(b []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(b, major<<5|byte(n))
+	case n <= 0xff:
+		return append(b, major<<5|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), n)
+}
+
+func mustBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := decodeBase64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// pad returns n big-endian in size bytes.
+func pad(n *big.Int, size int) []byte {
+	return n.FillBytes(make([]byte, size))
+}
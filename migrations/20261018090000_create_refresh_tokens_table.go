@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateRefreshTokensTable, downCreateRefreshTokensTable)
+}
+
+func upCreateRefreshTokensTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		family_id UUID NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ,
+		revoked_at TIMESTAMPTZ
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id)`)
+	return err
+}
+
+func downCreateRefreshTokensTable(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS refresh_tokens")
+	return err
+}
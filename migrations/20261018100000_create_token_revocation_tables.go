@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateTokenRevocationTables, downCreateTokenRevocationTables)
+}
+
+func upCreateTokenRevocationTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS revoked_tokens (
+		jti TEXT PRIMARY KEY,
+		user_id UUID NOT NULL,
+		revoked_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON revoked_tokens (expires_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Tokens of user_id issued before revoked_before are no longer valid. The
+	// row can be dropped once expires_at has passed, as every token it covers
+	// has expired by then.
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS user_token_revocations (
+		user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		revoked_before TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`)
+	return err
+}
+
+func downCreateTokenRevocationTables(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS user_token_revocations")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE IF EXISTS revoked_tokens")
+	return err
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateSigningKeysTable, downCreateSigningKeysTable)
+}
+
+func upCreateSigningKeysTable(tx *sql.Tx) error {
+	// The active key is the newest one that has not been retired. Retired
+	// keys keep verifying tokens until expires_at.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS signing_keys (
+		id TEXT PRIMARY KEY,
+		algorithm TEXT NOT NULL,
+		private_key TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		retired_at TIMESTAMPTZ,
+		expires_at TIMESTAMPTZ
+	)`)
+	return err
+}
+
+func downCreateSigningKeysTable(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS signing_keys")
+	return err
+}
@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddAudienceToRefreshTokens, downAddAudienceToRefreshTokens)
+}
+
+func upAddAudienceToRefreshTokens(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS audience TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+func downAddAudienceToRefreshTokens(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS audience")
+	return err
+}
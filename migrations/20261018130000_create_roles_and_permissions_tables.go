@@ -0,0 +1,79 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateRolesAndPermissionsTables, downCreateRolesAndPermissionsTables)
+}
+
+// The admin role and permission are seeded with fixed ids, the admin API is
+// only reachable with the admin permission.
+const (
+	adminRoleID       = "00000000-0000-0000-0000-000000000001"
+	adminPermissionID = "00000000-0000-0000-0000-000000000001"
+)
+
+func upCreateRolesAndPermissionsTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS roles (
+		id UUID PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS permissions (
+		id UUID PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS role_permissions (
+		role_id UUID NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+		permission_id UUID NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+		PRIMARY KEY (role_id, permission_id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS user_roles (
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		role_id UUID NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+		PRIMARY KEY (user_id, role_id)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO roles (id, name, description) VALUES ($1, 'admin', 'Manages users, roles and clients')
+		ON CONFLICT (id) DO NOTHING`, adminRoleID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO permissions (id, name) VALUES ($1, 'admin') ON CONFLICT (id) DO NOTHING`, adminPermissionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO role_permissions (role_id, permission_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		adminRoleID, adminPermissionID)
+	return err
+}
+
+func downCreateRolesAndPermissionsTables(tx *sql.Tx) error {
+	for _, table := range []string{"user_roles", "role_permissions", "permissions", "roles"} {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateOAuthTables, downCreateOAuthTables)
+}
+
+func upCreateOAuthTables(tx *sql.Tx) error {
+	// redirect_uris and scopes are space separated lists, neither may contain
+	// spaces on their own. Public clients have no secret.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS oauth_clients (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		secret_hash TEXT,
+		redirect_uris TEXT NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+		code_hash TEXT PRIMARY KEY,
+		client_id TEXT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		redirect_uri TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		code_challenge TEXT NOT NULL,
+		code_challenge_method TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		used_at TIMESTAMPTZ
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Refresh tokens issued through /token are bound to the client and scope
+	// they were granted for.
+	_, err = tx.Exec(`ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT ''`)
+	return err
+}
+
+func downCreateOAuthTables(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scope")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS client_id")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE IF EXISTS oauth_authorization_codes")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE IF EXISTS oauth_clients")
+	return err
+}
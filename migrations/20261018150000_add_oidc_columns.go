@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddOIDCColumns, downAddOIDCColumns)
+}
+
+func upAddOIDCColumns(tx *sql.Tx) error {
+	// The nonce and the time the user signed in are carried from /authorize
+	// into the ID token issued for the code.
+	_, err := tx.Exec(`ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ`)
+	return err
+}
+
+func downAddOIDCColumns(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS auth_time")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS nonce")
+	return err
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddClientCredentials, downAddClientCredentials)
+}
+
+func upAddClientCredentials(tx *sql.Tx) error {
+	// grant_types is a space separated list. Clients authenticating with
+	// private_key_jwt register their public keys as a JWKS instead of a secret.
+	_, err := tx.Exec(`ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS grant_types TEXT NOT NULL DEFAULT 'authorization_code'`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE oauth_clients ADD COLUMN IF NOT EXISTS jwks TEXT`)
+	if err != nil {
+		return err
+	}
+
+	// The jti of every client assertion is kept until it expires, so that an
+	// assertion can only be used once.
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS oauth_client_assertions (
+		client_id TEXT NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+		jti TEXT NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (client_id, jti)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Tokens issued to clients rather than users can be revoked too.
+	_, err = tx.Exec(`ALTER TABLE revoked_tokens ALTER COLUMN user_id DROP NOT NULL`)
+	return err
+}
+
+func downAddClientCredentials(tx *sql.Tx) error {
+	_, err := tx.Exec("DELETE FROM revoked_tokens WHERE user_id IS NULL")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE revoked_tokens ALTER COLUMN user_id SET NOT NULL")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE IF EXISTS oauth_client_assertions")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE oauth_clients DROP COLUMN IF EXISTS jwks")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE oauth_clients DROP COLUMN IF EXISTS grant_types")
+	return err
+}
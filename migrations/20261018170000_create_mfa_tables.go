@@ -0,0 +1,57 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateMFATables, downCreateMFATables)
+}
+
+func upCreateMFATables(tx *sql.Tx) error {
+	// A secret without enabled_at is still waiting for its first code.
+	// last_used_step keeps a code from being accepted twice.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS user_totp (
+		user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+		secret TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		enabled_at TIMESTAMPTZ,
+		last_used_step BIGINT NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		code_hash TEXT NOT NULL,
+		used_at TIMESTAMPTZ,
+		PRIMARY KEY (user_id, code_hash)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	// Challenges are handed out by /login to users with a second factor and
+	// exchanged for tokens at /login/mfa.
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS mfa_challenges (
+		token_hash TEXT PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		audience TEXT NOT NULL,
+		attempts INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`)
+	return err
+}
+
+func downCreateMFATables(tx *sql.Tx) error {
+	for _, table := range []string{"mfa_challenges", "mfa_recovery_codes", "user_totp"} {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateWebAuthnTables, downCreateWebAuthnTables)
+}
+
+func upCreateWebAuthnTables(tx *sql.Tx) error {
+	// Credential IDs and COSE public keys are stored base64url encoded.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS webauthn_credentials (
+		id TEXT PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		public_key TEXT NOT NULL,
+		sign_count BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL,
+		last_used_at TIMESTAMPTZ
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON webauthn_credentials (user_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Every ceremony is started with a challenge that can be answered once.
+	// Passwordless logins do not know the user up front.
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS webauthn_challenges (
+		challenge_hash TEXT PRIMARY KEY,
+		ceremony TEXT NOT NULL,
+		user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`)
+	return err
+}
+
+func downCreateWebAuthnTables(tx *sql.Tx) error {
+	for _, table := range []string{"webauthn_challenges", "webauthn_credentials"} {
+		if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
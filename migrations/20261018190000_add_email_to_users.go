@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddEmailToUsers, downAddEmailToUsers)
+}
+
+func upAddEmailToUsers(tx *sql.Tx) error {
+	// Users registered before email addresses were collected have none.
+	_, err := tx.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email TEXT UNIQUE`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ`)
+	return err
+}
+
+func downAddEmailToUsers(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE users DROP COLUMN IF EXISTS email")
+	return err
+}
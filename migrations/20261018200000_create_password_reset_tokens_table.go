@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreatePasswordResetTokensTable, downCreatePasswordResetTokensTable)
+}
+
+func upCreatePasswordResetTokensTable(tx *sql.Tx) error {
+	// Only the hash of a reset token is stored, the token itself is mailed to
+	// the user. A token is deleted when it is used.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS password_reset_tokens (
+		token_hash TEXT PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		created_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id_idx ON password_reset_tokens (user_id)`)
+	return err
+}
+
+func downCreatePasswordResetTokensTable(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS password_reset_tokens")
+	return err
+}
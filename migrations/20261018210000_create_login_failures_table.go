@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateLoginFailuresTable, downCreateLoginFailuresTable)
+}
+
+func upCreateLoginFailuresTable(tx *sql.Tx) error {
+	// Failed logins are counted per username and per source address, the
+	// subject of the scope. A row is forgotten, and the count starts over,
+	// once expires_at has passed.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS login_failures (
+		scope TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		failures INT NOT NULL,
+		last_failed_at TIMESTAMPTZ NOT NULL,
+		locked_until TIMESTAMPTZ,
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (scope, subject)
+	)`)
+	return err
+}
+
+func downCreateLoginFailuresTable(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS login_failures")
+	return err
+}
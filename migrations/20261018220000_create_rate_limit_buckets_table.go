@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateRateLimitBucketsTable, downCreateRateLimitBucketsTable)
+}
+
+func upCreateRateLimitBucketsTable(tx *sql.Tx) error {
+	// Token buckets of the database backed rate limiter, shared by all
+	// replicas. The id is the name of the limit followed by the key the
+	// request was counted against.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+		id TEXT PRIMARY KEY,
+		tokens FLOAT8 NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL,
+		expires_at TIMESTAMPTZ NOT NULL
+	)`)
+	return err
+}
+
+func downCreateRateLimitBucketsTable(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS rate_limit_buckets")
+	return err
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddPasswordBreachedAtToUsers, downAddPasswordBreachedAtToUsers)
+}
+
+func upAddPasswordBreachedAtToUsers(tx *sql.Tx) error {
+	// Set when a user logs in with a password found among the breached
+	// passwords, until the password is changed.
+	_, err := tx.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_breached_at TIMESTAMPTZ`)
+	return err
+}
+
+func downAddPasswordBreachedAtToUsers(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE users DROP COLUMN IF EXISTS password_breached_at")
+	return err
+}
@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddPasswordHistory, downAddPasswordHistory)
+}
+
+func upAddPasswordHistory(tx *sql.Tx) error {
+	// Passwords of existing users are taken to have been set now, so that
+	// they do not all expire at once.
+	_, err := tx.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_changed_at TIMESTAMPTZ`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE users SET password_changed_at = $1 WHERE password_changed_at IS NULL`, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// The hashes of the passwords a user had before the current one, which
+	// must not be used again.
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS password_history (
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		password_hash TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (user_id, created_at)
+	)`)
+	return err
+}
+
+func downAddPasswordHistory(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS password_history")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE users DROP COLUMN IF EXISTS password_changed_at")
+	return err
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddUserStatusColumns, downAddUserStatusColumns)
+}
+
+func upAddUserStatusColumns(tx *sql.Tx) error {
+	// Set while an admin keeps the user from logging in.
+	_, err := tx.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ`)
+	if err != nil {
+		return err
+	}
+	// Set when an admin requires the user to choose a new password, until
+	// the password is changed.
+	_, err = tx.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS password_change_required_at TIMESTAMPTZ`)
+	return err
+}
+
+func downAddUserStatusColumns(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE users DROP COLUMN IF EXISTS password_change_required_at")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE users DROP COLUMN IF EXISTS disabled_at")
+	return err
+}
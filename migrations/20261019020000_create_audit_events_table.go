@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upCreateAuditEventsTable, downCreateAuditEventsTable)
+}
+
+func upCreateAuditEventsTable(tx *sql.Tx) error {
+	// Events are only ever appended. Each one carries the hash of the one
+	// before it, so rows that were changed or removed break the chain. User
+	// ids are not references, events outlive the users they are about.
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS audit_events (
+		seq INT8 PRIMARY KEY,
+		occurred_at TIMESTAMPTZ NOT NULL,
+		type TEXT NOT NULL,
+		actor_id TEXT NOT NULL DEFAULT '',
+		target_id TEXT NOT NULL DEFAULT '',
+		client_id TEXT NOT NULL DEFAULT '',
+		username TEXT NOT NULL DEFAULT '',
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		outcome TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		detail TEXT NOT NULL DEFAULT '',
+		prev_hash TEXT NOT NULL,
+		hash TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE INDEX IF NOT EXISTS audit_events_target_id_idx ON audit_events (target_id)`)
+	if err != nil {
+		return err
+	}
+
+	// The last event of the chain, locked while an event is appended so that
+	// events are appended one at a time.
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS audit_chain (
+		id INT PRIMARY KEY CHECK (id = 1),
+		seq INT8 NOT NULL,
+		hash TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO audit_chain (id, seq, hash) VALUES (1, 0, '') ON CONFLICT (id) DO NOTHING`)
+	return err
+}
+
+func downCreateAuditEventsTable(tx *sql.Tx) error {
+	_, err := tx.Exec("DROP TABLE IF EXISTS audit_chain")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DROP TABLE IF EXISTS audit_events")
+	return err
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddFamilyIDToAuthorizationCodes, downAddFamilyIDToAuthorizationCodes)
+}
+
+func upAddFamilyIDToAuthorizationCodes(tx *sql.Tx) error {
+	// The refresh token family issued for a code, revoked when the code is
+	// presented again.
+	_, err := tx.Exec(`ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS family_id TEXT`)
+	return err
+}
+
+func downAddFamilyIDToAuthorizationCodes(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS family_id")
+	return err
+}
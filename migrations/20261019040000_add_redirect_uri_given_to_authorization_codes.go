@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"database/sql"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigration(upAddRedirectURIGivenToAuthorizationCodes, downAddRedirectURIGivenToAuthorizationCodes)
+}
+
+func upAddRedirectURIGivenToAuthorizationCodes(tx *sql.Tx) error {
+	// Whether the authorization request named its redirect_uri, which the
+	// token request then has to repeat.
+	_, err := tx.Exec(`ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS redirect_uri_given BOOLEAN NOT NULL DEFAULT FALSE`)
+	return err
+}
+
+func downAddRedirectURIGivenToAuthorizationCodes(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE oauth_authorization_codes DROP COLUMN IF EXISTS redirect_uri_given")
+	return err
+}